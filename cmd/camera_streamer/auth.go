@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/auth"
+	"go.uber.org/zap"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// makeAuthenticator builds the chain of authenticators enabled in the config. The returned signer is nil if signed
+// urls are disabled.
+func makeAuthenticator(config AuthConfig) (auth.Chain, *auth.URLSigner, error) {
+	var chain auth.Chain
+
+	if len(config.ClientCerts) > 0 {
+		principals := make(map[string]auth.Principal, len(config.ClientCerts))
+		for _, certConfig := range config.ClientCerts {
+			principal := auth.Principal{Subject: certConfig.CommonName}
+			if len(certConfig.Streams) > 0 {
+				principal.Streams = certConfig.Streams
+			}
+			principals[certConfig.CommonName] = principal
+		}
+		chain = append(chain, auth.NewClientCertAuthenticator(principals))
+	}
+
+	if len(config.APIKeys) > 0 {
+		keys := make([]auth.APIKey, 0, len(config.APIKeys))
+		for _, keyConfig := range config.APIKeys {
+			if keyConfig.Key == "" {
+				return nil, nil, fmt.Errorf("api key '%s' is empty", keyConfig.Name)
+			}
+
+			principal := auth.Principal{Subject: keyConfig.Name}
+			if len(keyConfig.Streams) > 0 {
+				principal.Streams = keyConfig.Streams
+			}
+
+			keys = append(keys, auth.APIKey{Key: keyConfig.Key, Principal: principal})
+		}
+		chain = append(chain, auth.NewAPIKeyAuthenticator(keys))
+	}
+
+	jwtConfig := config.JWT
+	if jwtConfig.Secret != "" || jwtConfig.KeyFile != "" || jwtConfig.JWKSFile != "" {
+		authenticator := auth.NewJWTAuthenticator(auth.JWTConfig{
+			Issuer:       jwtConfig.Issuer,
+			Audience:     jwtConfig.Audience,
+			StreamsClaim: jwtConfig.StreamsClaim,
+		})
+
+		if jwtConfig.Secret != "" {
+			authenticator.AddSecret(jwtConfig.Secret)
+		}
+		if jwtConfig.KeyFile != "" {
+			if err := authenticator.LoadKeyFile(jwtConfig.KeyFile); err != nil {
+				return nil, nil, fmt.Errorf("could not load jwt key: %w", err)
+			}
+		}
+		if jwtConfig.JWKSFile != "" {
+			if err := authenticator.LoadJWKSFile(jwtConfig.JWKSFile); err != nil {
+				return nil, nil, fmt.Errorf("could not load jwks: %w", err)
+			}
+		}
+
+		chain = append(chain, authenticator)
+	}
+
+	var signer *auth.URLSigner
+	if config.SignedURLs.Secret != "" {
+		signer = auth.NewURLSigner(config.SignedURLs.Secret, config.SignedURLs.MaxTTL)
+		chain = append(chain, signer)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil, fmt.Errorf("authentication is enabled but no authentication method is configured")
+	}
+
+	return chain, signer, nil
+}
+
+// makeSignURLHandler returns a handler that issues signed urls for paths inside a stream, so that pages can embed
+// them without holding any other credentials.
+func makeSignURLHandler(signer *auth.URLSigner, logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("SignURLHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if signer == nil {
+			http.Error(w, "signed urls are disabled", http.StatusNotFound)
+			return
+		}
+
+		ttl := signer.MaxTTL()
+		if ttlString := r.URL.Query().Get("ttl"); ttlString != "" {
+			requestedTTL, err := time.ParseDuration(ttlString)
+			if err != nil || requestedTTL <= 0 {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			if requestedTTL < ttl {
+				ttl = requestedTTL
+			}
+		}
+
+		// Paths are relative to the stream, the stream's root path is signed by default
+		streamPath := path.Dir(r.URL.Path) + "/"
+		signedPath := path.Join(streamPath, r.URL.Query().Get("path"))
+		if r.URL.Query().Get("path") == "" {
+			signedPath = streamPath
+		} else if !strings.HasPrefix(signedPath, streamPath) {
+			http.Error(w, "path outside of stream", http.StatusBadRequest)
+			return
+		}
+		expires := time.Now().Add(ttl)
+
+		logger.Debugw("signing url", "path", signedPath, "expires", expires)
+
+		response := struct {
+			URL     string    `json:"url"`
+			Expires time.Time `json:"expires"`
+		}{
+			signedPath + "?" + signer.Sign(signedPath, expires).Encode(),
+			expires,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Errorw("could not write response", "err", err)
+		}
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"errors"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/onvif"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"os"
 	"path"
+	"time"
 )
 
 type (
@@ -19,10 +21,138 @@ type (
 		Port     int    `mapstructure:"port"`
 	}
 
+	ICEServerConfig struct {
+		URLs       []string `mapstructure:"urls"`
+		Username   string   `mapstructure:"username"`
+		Credential string   `mapstructure:"credential"`
+		// If set, time limited credentials are generated from this secret using the TURN REST API scheme, and
+		// Credential is ignored
+		SharedSecret  string        `mapstructure:"shared_secret"`
+		CredentialTTL time.Duration `mapstructure:"credential_ttl"`
+	}
+
+	ICEConfig struct {
+		Servers              []ICEServerConfig `mapstructure:"servers"`
+		TransportPolicy      string            `mapstructure:"transport_policy"`
+		NAT1To1IPs           []string          `mapstructure:"nat_1to1_ips"`
+		NAT1To1CandidateType string            `mapstructure:"nat_1to1_candidate_type"`
+		UDPPortMin           uint16            `mapstructure:"udp_port_min"`
+		UDPPortMax           uint16            `mapstructure:"udp_port_max"`
+		Interfaces           []string          `mapstructure:"interfaces"`
+		ExcludedInterfaces   []string          `mapstructure:"excluded_interfaces"`
+		// If not zero, all ICE traffic is multiplexed through this port, both over UDP and TCP
+		MuxPort int `mapstructure:"mux_port"`
+	}
+
+	APIKeyConfig struct {
+		Name string `mapstructure:"name"`
+		Key  string `mapstructure:"key"`
+		// Ids of the streams this key may open, all streams if empty
+		Streams []int `mapstructure:"streams"`
+	}
+
+	JWTAuthConfig struct {
+		// Only one of these is needed to verify tokens
+		Secret   string `mapstructure:"secret"`
+		KeyFile  string `mapstructure:"key_file"`
+		JWKSFile string `mapstructure:"jwks_file"`
+
+		Issuer       string `mapstructure:"issuer"`
+		Audience     string `mapstructure:"audience"`
+		StreamsClaim string `mapstructure:"streams_claim"`
+	}
+
+	SignedURLConfig struct {
+		Secret string        `mapstructure:"secret"`
+		MaxTTL time.Duration `mapstructure:"max_ttl"`
+	}
+
+	AuthConfig struct {
+		Enabled    bool            `mapstructure:"enabled"`
+		APIKeys    []APIKeyConfig  `mapstructure:"api_keys"`
+		JWT        JWTAuthConfig   `mapstructure:"jwt"`
+		SignedURLs SignedURLConfig `mapstructure:"signed_urls"`
+		// Machine consumers authenticated by their TLS client certificate
+		ClientCerts []ClientCertConfig `mapstructure:"client_certs"`
+	}
+
+	TLSConfig struct {
+		Enabled  bool   `mapstructure:"enabled"`
+		CertFile string `mapstructure:"cert_file"`
+		KeyFile  string `mapstructure:"key_file"`
+		// Client certificates are verified against the CAs in this file, according to ClientAuth, which may be "none",
+		// "optional" or "require"
+		ClientCAFile string `mapstructure:"client_ca_file"`
+		ClientAuth   string `mapstructure:"client_auth"`
+	}
+
+	ClientCertConfig struct {
+		CommonName string `mapstructure:"common_name"`
+		// Ids of the streams this certificate may open, all streams if empty
+		Streams []int `mapstructure:"streams"`
+	}
+
+	RecordingCameraConfig struct {
+		Id int `mapstructure:"id"`
+		// Whether to record all footage, otherwise only event recordings are made
+		Continuous bool `mapstructure:"continuous"`
+		// Whether to make an event recording while the camera detects motion, which must be configured for it
+		Motion bool `mapstructure:"motion"`
+	}
+
+	RecordingConfig struct {
+		Enabled   bool   `mapstructure:"enabled"`
+		Directory string `mapstructure:"directory"`
+		// Cameras that are recorded, which keep their pipelines running even if nobody is watching
+		Cameras       []RecordingCameraConfig `mapstructure:"cameras"`
+		ChunkDuration time.Duration           `mapstructure:"chunk_duration"`
+		// Footage kept in memory to be included before the start of event recordings
+		PreRoll          time.Duration `mapstructure:"pre_roll"`
+		MaxEventDuration time.Duration `mapstructure:"max_event_duration"`
+		// Retention limits for all recordings, zero disables them
+		MaxAge    time.Duration `mapstructure:"max_age"`
+		MaxSizeMB int64         `mapstructure:"max_size_mb"`
+	}
+
+	DiscoveryConfig struct {
+		// Multicast address the WS-Discovery probes are sent to
+		Address string        `mapstructure:"address"`
+		Timeout time.Duration `mapstructure:"timeout"`
+		// Credentials used to query the media service of discovered devices
+		Username string `mapstructure:"username"`
+		Password string `mapstructure:"password"`
+	}
+
+	WebhookTargetConfig struct {
+		URL string `mapstructure:"url"`
+		// Key of the requests' HMAC signature, requests are not signed if empty
+		Secret string `mapstructure:"secret"`
+	}
+
+	WebhooksConfig struct {
+		Targets []WebhookTargetConfig `mapstructure:"targets"`
+		// How long a camera's source must stay down or up before it's notified, so flapping cameras are only
+		// notified once they settle
+		Debounce time.Duration `mapstructure:"debounce"`
+		// A pipeline is notified as erroring repeatedly after this many errors within the error window
+		ErrorThreshold int           `mapstructure:"error_threshold"`
+		ErrorWindow    time.Duration `mapstructure:"error_window"`
+		Timeout        time.Duration `mapstructure:"timeout"`
+		// Failed deliveries are retried this many times, waiting twice as long as the previous time before each retry
+		MaxRetries int           `mapstructure:"max_retries"`
+		RetryDelay time.Duration `mapstructure:"retry_delay"`
+	}
+
 	Config struct {
 		Port          int                 `toml:"port"`
 		CameraService CameraServiceConfig `mapstructure:"camera_service"`
 		Cors          CorsConfig          `mapstructure:"cors"`
+		ICE           ICEConfig           `mapstructure:"ice"`
+		Auth          AuthConfig          `mapstructure:"auth"`
+		TLS           TLSConfig           `mapstructure:"tls"`
+		Recording     RecordingConfig     `mapstructure:"recording"`
+		Discovery     DiscoveryConfig     `mapstructure:"discovery"`
+		Webhooks      WebhooksConfig      `mapstructure:"webhooks"`
 	}
 )
 
@@ -60,6 +190,44 @@ func loadConfig(logger *zap.SugaredLogger) Config {
 	configLoader.SetDefault("camera_service.hostname", "localhost")
 	configLoader.SetDefault("camera_service.port", 3000)
 
+	// ice config
+	configLoader.SetDefault("ice.servers", []map[string]any{
+		{"urls": []string{"stun:stun.l.google.com:19302"}},
+	})
+	configLoader.SetDefault("ice.transport_policy", "all")
+	configLoader.SetDefault("ice.nat_1to1_candidate_type", "host")
+	configLoader.SetDefault("ice.mux_port", 0)
+
+	// auth config
+	configLoader.SetDefault("auth.enabled", false)
+	configLoader.SetDefault("auth.jwt.streams_claim", "streams")
+	configLoader.SetDefault("auth.signed_urls.max_ttl", "24h")
+
+	// tls config
+	configLoader.SetDefault("tls.enabled", false)
+	configLoader.SetDefault("tls.client_auth", "none")
+
+	// recording config
+	configLoader.SetDefault("recording.enabled", false)
+	configLoader.SetDefault("recording.directory", "recordings")
+	configLoader.SetDefault("recording.chunk_duration", "5m")
+	configLoader.SetDefault("recording.pre_roll", "10s")
+	configLoader.SetDefault("recording.max_event_duration", "30m")
+	configLoader.SetDefault("recording.max_age", "168h")
+	configLoader.SetDefault("recording.max_size_mb", 0)
+
+	// discovery config
+	configLoader.SetDefault("discovery.address", onvif.DiscoveryAddress)
+	configLoader.SetDefault("discovery.timeout", "3s")
+
+	// webhooks config
+	configLoader.SetDefault("webhooks.debounce", "30s")
+	configLoader.SetDefault("webhooks.error_threshold", 5)
+	configLoader.SetDefault("webhooks.error_window", "5m")
+	configLoader.SetDefault("webhooks.timeout", "10s")
+	configLoader.SetDefault("webhooks.max_retries", 5)
+	configLoader.SetDefault("webhooks.retry_delay", "1s")
+
 	err := configLoader.ReadInConfig()
 
 	if err != nil {
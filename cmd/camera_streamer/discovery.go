@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/onvif"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+)
+
+// discoveredDevice is a device found on the network, along with the camera configurations for its streams
+type discoveredDevice struct {
+	onvif.Device
+	Profiles []onvif.MediaProfile `json:"profiles"`
+	// Ready to be imported into the camera service, once their credentials are filled in
+	Cameras []webrtcstream.Config `json:"cameras"`
+	// Why the device's streams could not be listed, if they couldn't
+	Error string `json:"error,omitempty"`
+}
+
+// describeDevice looks up the streams of a discovered device through its media service
+func describeDevice(ctx context.Context, device onvif.Device, config DiscoveryConfig) discoveredDevice {
+	described := discoveredDevice{
+		Device:   device,
+		Profiles: []onvif.MediaProfile{},
+		Cameras:  []webrtcstream.Config{},
+	}
+
+	if len(device.XAddrs) == 0 {
+		described.Error = "device has no service address"
+		return described
+	}
+
+	client := onvif.NewClient(device.XAddrs[0], config.Username, config.Password)
+	profiles, err := client.GetStreamUris(ctx)
+	if err != nil {
+		described.Error = err.Error()
+		return described
+	}
+	described.Profiles = profiles
+
+	for _, profile := range profiles {
+		name := device.Name
+		if name == "" {
+			name = device.Address
+		}
+		if len(profiles) > 1 {
+			name += " (" + profile.Name + ")"
+		}
+
+		described.Cameras = append(described.Cameras, webrtcstream.Config{
+			Name:             name,
+			ConnectionString: profile.URI,
+			Orientation:      webrtcstream.CameraOrientationHorizontal,
+			Onvif: &webrtcstream.OnvifConfig{
+				Endpoint: device.XAddrs[0],
+				Username: config.Username,
+			},
+		})
+	}
+
+	return described
+}
+
+// makeGetDiscoveryHandler returns a handler that probes the local network for ONVIF cameras, listing the streams of
+// every camera that answers
+func makeGetDiscoveryHandler(config DiscoveryConfig, logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetDiscoveryHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		probeCtx, cancel := context.WithTimeout(r.Context(), config.Timeout)
+		defer cancel()
+
+		devices, err := onvif.Discover(probeCtx, config.Address)
+		if err != nil {
+			logger.Errorw("could not discover devices", "err", err)
+			http.Error(w, "could not discover devices", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Debugw("discovered devices", "count", len(devices))
+
+		described := make([]discoveredDevice, len(devices))
+		var wg sync.WaitGroup
+		for i, device := range devices {
+			wg.Add(1)
+			go func(i int, device onvif.Device) {
+				defer wg.Done()
+				described[i] = describeDevice(r.Context(), device, config)
+			}(i, device)
+		}
+		wg.Wait()
+
+		writeJSON(w, http.StatusOK, described, logger)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventBufferSize is how many events may be waiting to be sent to a client before new ones are dropped
+const eventBufferSize = 64
+
+// Events of the server itself, besides those of each stream's pipeline
+const (
+	EVENT_STREAM_CREATED   webrtcstream.EventType = "stream_created"
+	EVENT_STREAM_DESTROYED webrtcstream.EventType = "stream_destroyed"
+	EVENT_VIEWER_JOINED    webrtcstream.EventType = "viewer_joined"
+	EVENT_VIEWER_LEFT      webrtcstream.EventType = "viewer_left"
+)
+
+type Event struct {
+	webrtcstream.Event
+	StreamId int `json:"stream_id"`
+	// Address of the viewer, for viewer events
+	Viewer string `json:"viewer,omitempty"`
+}
+
+// EventHub hands the events of every stream to its subscribers
+type EventHub struct {
+	mu                sync.Mutex
+	subscribers       map[int]chan Event
+	subscriberCounter int
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[int]chan Event),
+	}
+}
+
+// Publish sends an event to every subscriber. It never blocks, subscribers that fall behind miss events.
+func (h *EventHub) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subscriber := range h.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// streamHandler returns a handler that publishes the events of a stream
+func (h *EventHub) streamHandler(streamId int) webrtcstream.EventHandler {
+	return func(event webrtcstream.Event) {
+		h.Publish(Event{Event: event, StreamId: streamId})
+	}
+}
+
+func (h *EventHub) Subscribe() (int, <-chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.subscriberCounter
+	h.subscriberCounter++
+
+	events := make(chan Event, eventBufferSize)
+	h.subscribers[id] = events
+
+	return id, events
+}
+
+func (h *EventHub) Unsubscribe(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, id)
+}
+
+// makeGetEventsHandler returns a handler that streams the events of every stream as Server-Sent Events
+func makeGetEventsHandler(events *EventHub, logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetEventsHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		subscriberId, subscription := events.Subscribe()
+		defer events.Unsubscribe(subscriberId)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		logger.Infow("starting event stream")
+
+		keepAlive := time.NewTicker(sseKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Infow("event stream ended")
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case event := <-subscription:
+				if err := writeServerSentEvent(w, flusher, string(event.Type), event); err != nil {
+					logger.Debugw("could not write event", "err", err)
+					return
+				}
+			}
+		}
+	}
+}
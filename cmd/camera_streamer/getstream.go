@@ -8,22 +8,19 @@ import (
 	"net/http"
 )
 
-var webrtcConfig = webrtc.Configuration{
-	ICEServers: []webrtc.ICEServer{
-		{
-			URLs: []string{"stun:stun.l.google.com:19302"},
-		},
-	},
-}
-
-func makeTrackHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) webrtcstream.TrackRequestHandler {
+func makeTrackHandler(w http.ResponseWriter, r *http.Request, webrtcConfig WebRTCConfig, peerConnectionFactory *PeerConnectionFactory, events *EventHub, logger *zap.SugaredLogger) webrtcstream.TrackRequestHandler {
 	logger = logger.Named("TrackHandler")
-	return func(ctx context.Context, track webrtc.TrackLocal) {
-		HandleWebRTC(w, r, []webrtc.TrackLocal{track}, logger)
+	return func(ctx context.Context, track webrtc.TrackLocal, liveTrack *webrtcstream.LiveTrack) {
+		streamId := liveTrack.Stream().Id
+		events.Publish(Event{Event: webrtcstream.Event{Type: EVENT_VIEWER_JOINED}, StreamId: streamId, Viewer: r.RemoteAddr})
+		defer events.Publish(Event{Event: webrtcstream.Event{Type: EVENT_VIEWER_LEFT}, StreamId: streamId, Viewer: r.RemoteAddr})
+
+		channel := makeLiveTrackChannel(ctx, liveTrack, logger)
+		HandleWebRTC(w, r, []webrtc.TrackLocal{track}, channel, webrtcConfig, peerConnectionFactory, logger)
 	}
 }
 
-func makeGetStreamHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+func makeGetStreamHandler(webrtcConfig WebRTCConfig, peerConnectionFactory *PeerConnectionFactory, events *EventHub, logger *zap.SugaredLogger) http.HandlerFunc {
 	logger = logger.Named("GetStreamHandler")
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -31,7 +28,7 @@ func makeGetStreamHandler(logger *zap.SugaredLogger) http.HandlerFunc {
 
 		logger.Info("starting webrtc session")
 
-		stream.HandleTrackRequest(ctx, logger, makeTrackHandler(w, r, logger))
+		stream.HandleTrackRequest(ctx, logger, makeTrackHandler(w, r, webrtcConfig, peerConnectionFactory, events, logger))
 
 		logger.Info("webrtc session ended")
 	}
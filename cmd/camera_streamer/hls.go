@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/hls"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// hlsRequestTimeout is how long blocking playlist and part requests are held before giving up
+const hlsRequestTimeout = 10 * time.Second
+
+const (
+	playlistContentType = "application/vnd.apple.mpegurl"
+	mp4ContentType      = "video/mp4"
+)
+
+// hlsMuxer returns the muxer of the stream in the request's context, writing an error response if it's not available
+func hlsMuxer(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) (*hls.Muxer, bool) {
+	stream := r.Context().Value("stream").(*webrtcstream.WebRTCStream)
+
+	muxer, err := stream.HLS(logger)
+	if err != nil {
+		logger.Errorw("could not start hls", "err", err)
+		http.Error(w, "could not start hls", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return muxer, true
+}
+
+// writeHlsError writes the response for an error returned by the muxer
+func writeHlsError(w http.ResponseWriter, err error, logger *zap.SugaredLogger) {
+	switch {
+	case errors.Is(err, hls.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, hls.ErrInvalidRequest):
+		http.Error(w, "invalid request", http.StatusBadRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "camera did not deliver video in time", http.StatusServiceUnavailable)
+	default:
+		logger.Debugw("hls request failed", "err", err)
+	}
+}
+
+func writeHlsResponse(w http.ResponseWriter, contentType string, data []byte, logger *zap.SugaredLogger) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	// playlists change constantly, and segments are only kept around for a short while
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := w.Write(data); err != nil {
+		logger.Debugw("could not write hls response", "err", err)
+	}
+}
+
+// makeGetHlsPlaylistHandler returns a handler for the stream's media playlist. It supports low latency HLS blocking
+// playlist reloads through the _HLS_msn and _HLS_part query parameters.
+func makeGetHlsPlaylistHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetHlsPlaylistHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		sequence, part := int64(-1), -1
+
+		query := r.URL.Query()
+		if msn := query.Get("_HLS_msn"); msn != "" {
+			var err error
+			if sequence, err = strconv.ParseInt(msn, 10, 64); err != nil || sequence < 0 {
+				http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+				return
+			}
+		}
+		if partString := query.Get("_HLS_part"); partString != "" {
+			var err error
+			if part, err = strconv.Atoi(partString); err != nil || part < 0 || sequence < 0 {
+				http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+
+		muxer, ok := hlsMuxer(w, r, logger)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), hlsRequestTimeout)
+		defer cancel()
+
+		playlist, err := muxer.Playlist(ctx, sequence, part)
+		if err != nil {
+			writeHlsError(w, err, logger)
+			return
+		}
+
+		writeHlsResponse(w, playlistContentType, playlist, logger)
+	}
+}
+
+func makeGetHlsInitHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetHlsInitHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		muxer, ok := hlsMuxer(w, r, logger)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), hlsRequestTimeout)
+		defer cancel()
+
+		init, err := muxer.Init(ctx)
+		if err != nil {
+			writeHlsError(w, err, logger)
+			return
+		}
+
+		writeHlsResponse(w, mp4ContentType, init, logger)
+	}
+}
+
+func makeGetHlsSegmentHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetHlsSegmentHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		sequence, err := strconv.ParseUint(chi.URLParam(r, "sequence"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid segment", http.StatusBadRequest)
+			return
+		}
+
+		muxer, ok := hlsMuxer(w, r, logger)
+		if !ok {
+			return
+		}
+
+		segment, err := muxer.Segment(sequence)
+		if err != nil {
+			writeHlsError(w, err, logger)
+			return
+		}
+
+		writeHlsResponse(w, mp4ContentType, segment, logger)
+	}
+}
+
+func makeGetHlsPartHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetHlsPartHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		sequence, err := strconv.ParseUint(chi.URLParam(r, "sequence"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid segment", http.StatusBadRequest)
+			return
+		}
+		index, err := strconv.Atoi(chi.URLParam(r, "part"))
+		if err != nil || index < 0 {
+			http.Error(w, "invalid part", http.StatusBadRequest)
+			return
+		}
+
+		muxer, ok := hlsMuxer(w, r, logger)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), hlsRequestTimeout)
+		defer cancel()
+
+		part, err := muxer.Part(ctx, sequence, index)
+		if err != nil {
+			writeHlsError(w, err, logger)
+			return
+		}
+
+		writeHlsResponse(w, mp4ContentType, part, logger)
+	}
+}
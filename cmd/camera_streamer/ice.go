@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"time"
+)
+
+const defaultCredentialTTL = 24 * time.Hour
+
+// PeerConnectionFactory creates peer connections configured according to the ICE section of the server config
+type PeerConnectionFactory struct {
+	api    *webrtc.API
+	config ICEConfig
+}
+
+func NewPeerConnectionFactory(config ICEConfig, logger *zap.SugaredLogger) (*PeerConnectionFactory, error) {
+	logger = logger.Named("PeerConnectionFactory")
+
+	settingEngine := webrtc.SettingEngine{}
+
+	if config.UDPPortMin != 0 || config.UDPPortMax != 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(config.UDPPortMin, config.UDPPortMax); err != nil {
+			return nil, fmt.Errorf("invalid udp port range: %w", err)
+		}
+	}
+
+	if len(config.NAT1To1IPs) > 0 {
+		candidateType, err := webrtc.NewICECandidateType(config.NAT1To1CandidateType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nat 1:1 candidate type: %w", err)
+		}
+		settingEngine.SetNAT1To1IPs(config.NAT1To1IPs, candidateType)
+	}
+
+	interfaceFilter := makeInterfaceFilter(config.Interfaces, config.ExcludedInterfaces)
+	if interfaceFilter != nil {
+		settingEngine.SetInterfaceFilter(interfaceFilter)
+	}
+
+	if config.MuxPort != 0 {
+		logger.Infow("multiplexing ice traffic through single port", "port", config.MuxPort)
+
+		var udpMuxOptions []ice.UDPMuxFromPortOption
+		if interfaceFilter != nil {
+			udpMuxOptions = append(udpMuxOptions, ice.UDPMuxFromPortWithInterfaceFilter(interfaceFilter))
+		}
+
+		udpMux, err := ice.NewMultiUDPMuxFromPort(config.MuxPort, udpMuxOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("could not open ice udp mux: %w", err)
+		}
+		settingEngine.SetICEUDPMux(udpMux)
+
+		tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.MuxPort})
+		if err != nil {
+			return nil, fmt.Errorf("could not open ice tcp mux: %w", err)
+		}
+		settingEngine.SetICETCPMux(webrtc.NewICETCPMux(nil, tcpListener, 8))
+
+		// ICE-TCP candidates are only gathered if the tcp network types are explicitly enabled
+		settingEngine.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4,
+			webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4,
+			webrtc.NetworkTypeTCP6,
+		})
+	}
+
+	if policy := webrtc.NewICETransportPolicy(config.TransportPolicy); policy.String() != config.TransportPolicy {
+		return nil, fmt.Errorf("invalid ice transport policy '%s'", config.TransportPolicy)
+	}
+
+	return &PeerConnectionFactory{
+		webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine)),
+		config,
+	}, nil
+}
+
+// ICEServers returns the list of configured ICE servers, generating fresh credentials for those that use a shared
+// secret.
+func (f *PeerConnectionFactory) ICEServers() []webrtc.ICEServer {
+	iceServers := make([]webrtc.ICEServer, 0, len(f.config.Servers))
+
+	for _, serverConfig := range f.config.Servers {
+		iceServer := webrtc.ICEServer{
+			URLs:           serverConfig.URLs,
+			Username:       serverConfig.Username,
+			Credential:     serverConfig.Credential,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		}
+
+		if serverConfig.SharedSecret != "" {
+			ttl := serverConfig.CredentialTTL
+			if ttl == 0 {
+				ttl = defaultCredentialTTL
+			}
+			iceServer.Username, iceServer.Credential = turnRESTCredentials(serverConfig.SharedSecret,
+				serverConfig.Username, time.Now().Add(ttl))
+		}
+
+		iceServers = append(iceServers, iceServer)
+	}
+
+	return iceServers
+}
+
+// NewPeerConnection creates a new peer connection using the given ICE servers and the configured network settings
+func (f *PeerConnectionFactory) NewPeerConnection(iceServers []webrtc.ICEServer) (*webrtc.PeerConnection, error) {
+	return f.api.NewPeerConnection(webrtc.Configuration{
+		ICEServers:         iceServers,
+		ICETransportPolicy: webrtc.NewICETransportPolicy(f.config.TransportPolicy),
+	})
+}
+
+// turnRESTCredentials generates a time limited username and password pair following the TURN REST API scheme, as
+// understood by coturn's use-auth-secret option.
+func turnRESTCredentials(secret string, user string, expiration time.Time) (username string, credential string) {
+	username = strconv.FormatInt(expiration.Unix(), 10)
+	if user != "" {
+		username += ":" + user
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return
+}
+
+// makeInterfaceFilter returns a filter that only accepts the included interfaces, if any, and rejects all the excluded
+// ones. Returns nil if no filtering is needed.
+func makeInterfaceFilter(included []string, excluded []string) func(string) bool {
+	if len(included) == 0 && len(excluded) == 0 {
+		return nil
+	}
+
+	return func(name string) bool {
+		for _, excludedName := range excluded {
+			if name == excludedName {
+				return false
+			}
+		}
+
+		if len(included) == 0 {
+			return true
+		}
+
+		for _, includedName := range included {
+			if name == includedName {
+				return true
+			}
+		}
+
+		return false
+	}
+}
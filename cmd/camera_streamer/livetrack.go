@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/onvif"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// controlDataChannel is the label of the data channel opened on every live stream session
+	controlDataChannel = "control"
+	// trackMetadataInterval is how often viewers of live streams are sent the status of their track
+	trackMetadataInterval = time.Second
+	// dataChannelSnapshotWidth is the width of snapshots requested without one, small enough to fit in a data channel
+	// message
+	dataChannelSnapshotWidth = 640
+)
+
+var errRenditionUnsupported = errors.New("rendition switching is not supported, streams are only sent at the camera's resolution")
+
+func trackErrorMetadata(err error) *Message {
+	return &Message{MsgType: TRACK_METADATA, Payload: TrackMetadata{Kind: METADATA_ERROR, Error: err.Error()}}
+}
+
+func trackStatusMetadata(liveTrack *webrtcstream.LiveTrack) Message {
+	status := liveTrack.Status()
+	return Message{MsgType: TRACK_METADATA, Payload: TrackMetadata{
+		Kind:   METADATA_STATUS,
+		Status: &status,
+	}}
+}
+
+// makeLiveTrackChannel returns a session channel that applies the controls sent by a viewer of a live stream to its
+// track, and keeps it informed of the track's status and of the camera's name
+func makeLiveTrackChannel(ctx context.Context, liveTrack *webrtcstream.LiveTrack, logger *zap.SugaredLogger) *SessionChannel {
+	logger = logger.Named("LiveTrackChannel")
+	stream := liveTrack.Stream()
+
+	outgoing := make(chan Message)
+	go func() {
+		ticker := time.NewTicker(trackMetadataInterval)
+		defer ticker.Stop()
+
+		name := ""
+		for {
+			messages := []Message{trackStatusMetadata(liveTrack)}
+			if currentName := stream.CameraName(); currentName != name {
+				name = currentName
+				messages = append(messages, Message{MsgType: TRACK_METADATA, Payload: TrackMetadata{
+					Kind: METADATA_NAME,
+					Name: name,
+				}})
+			}
+
+			for _, message := range messages {
+				select {
+				case <-ctx.Done():
+					return
+				case outgoing <- message:
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return &SessionChannel{
+		Outgoing:    outgoing,
+		DataChannel: controlDataChannel,
+		OnMessage: func(ctx context.Context, message Message) *Message {
+			if message.MsgType != TRACK_CONTROL {
+				logger.Errorw("unknown message type received from peer", "message type", message.MsgType)
+				return nil
+			}
+
+			control, err := message.TrackControl()
+			if err != nil {
+				logger.Errorw("could not parse track control", "err", err)
+				return trackErrorMetadata(err)
+			}
+
+			switch control.Action {
+			case TRACK_PAUSE:
+				err = liveTrack.SetPaused(true)
+			case TRACK_RESUME:
+				err = liveTrack.SetPaused(false)
+			case TRACK_KEYFRAME:
+				err = liveTrack.RequestKeyFrame()
+			case TRACK_RENDITION:
+				err = errRenditionUnsupported
+			case TRACK_SNAPSHOT:
+				return takeTrackSnapshot(ctx, stream, control, logger)
+			case TRACK_PTZ_MOVE, TRACK_PTZ_STOP, TRACK_PTZ_PRESET:
+				err = applyPtzControl(ctx, stream, control)
+			case TRACK_STATUS:
+			default:
+				err = errors.New("unknown track action")
+			}
+			if err != nil {
+				logger.Errorw("could not apply track control", "action", control.Action, "err", err)
+				return trackErrorMetadata(err)
+			}
+
+			status := trackStatusMetadata(liveTrack)
+			return &status
+		},
+	}
+}
+
+func takeTrackSnapshot(ctx context.Context, stream *webrtcstream.WebRTCStream, control TrackControl, logger *zap.SugaredLogger) *Message {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	options := webrtcstream.SnapshotOptions{Width: control.Width, Quality: control.Quality}
+	if options.Width == 0 {
+		options.Width = dataChannelSnapshotWidth
+	}
+
+	snapshot, err := stream.Snapshot(ctx, options, logger)
+	if err != nil {
+		logger.Errorw("could not take snapshot", "err", err)
+		return trackErrorMetadata(err)
+	}
+
+	return &Message{MsgType: TRACK_METADATA, Payload: TrackMetadata{Kind: METADATA_SNAPSHOT, Snapshot: snapshot}}
+}
+
+func applyPtzControl(ctx context.Context, stream *webrtcstream.WebRTCStream, control TrackControl) error {
+	client, ok := stream.Onvif()
+	if !ok {
+		return errors.New("camera has no onvif configuration")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ptzRequestTimeout)
+	defer cancel()
+
+	switch control.Action {
+	case TRACK_PTZ_MOVE:
+		var timeout time.Duration
+		if control.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(control.Timeout); err != nil || timeout < 0 {
+				return errors.New("invalid timeout")
+			}
+		}
+		return client.ContinuousMove(ctx, onvif.Velocity{Pan: control.Pan, Tilt: control.Tilt, Zoom: control.Zoom}, timeout)
+	case TRACK_PTZ_STOP:
+		return client.Stop(ctx, true, true)
+	default:
+		return client.GotoPreset(ctx, control.Preset)
+	}
+}
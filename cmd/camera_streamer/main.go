@@ -2,11 +2,10 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/auth"
 	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
-	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"net/http"
@@ -31,21 +30,48 @@ func main() {
 		allowedOrigins = config.Cors.AllowedOrigins
 	}
 
+	// Signaling websockets are not covered by CORS, so they must check the same origins themselves
+	webrtcConfig := WebRTCConfig{
+		AllowAllOrigins: config.Cors.AllowAllOrigins,
+		ClientOrigins:   config.Cors.AllowedOrigins,
+	}
+
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: allowedOrigins,
-		AllowedMethods: []string{"GET", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
 		ExposedHeaders: []string{"*"},
 	}))
 
-	streamStore := make(map[int]*webrtcstream.WebRTCStream)
-	cameraServiceUrl := fmt.Sprintf("%s:%d/", config.CameraService.Hostname, config.CameraService.Port)
+	var urlSigner *auth.URLSigner
+	if config.Auth.Enabled {
+		authenticator, signer, err := makeAuthenticator(config.Auth)
+		if err != nil {
+			logger.Fatalw("could not configure authentication", "err", err)
+		}
+		urlSigner = signer
+
+		r.Use(auth.Middleware(authenticator, logger))
+	} else {
+		logger.Warnw("authentication is disabled, anyone can watch any camera")
+	}
+
+	peerConnectionFactory, err := NewPeerConnectionFactory(config.ICE, logger)
+	if err != nil {
+		logger.Fatalw("could not configure webrtc", "err", err)
+	}
+
+	events := NewEventHub()
+	streamStore := NewStreamStore(config.CameraService, events)
+	go streamStore.Refresh(context.Background(), cameraRefreshInterval, logger)
+
+	if len(config.Webhooks.Targets) > 0 {
+		StartWebhooks(context.Background(), config.Webhooks, events, logger)
+	}
 
 	streamCtx := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			streamIdString := chi.URLParam(r, "streamID")
-
-			cameraId, err := strconv.ParseInt(streamIdString, 10, 32)
+			cameraId, err := streamIdFromRequest(r)
 
 			if err != nil {
 				logger.Errorw("invalid camera id")
@@ -53,40 +79,15 @@ func main() {
 				return
 			}
 
-			stream, ok := streamStore[int(cameraId)]
-
-			if !ok {
-				logger.Debugw("creating camera stream", "camera id", cameraId)
-				resp, err := http.Get("http://" + cameraServiceUrl + "cameras/" + strconv.FormatInt(cameraId, 10))
-				if err != nil {
-					logger.Errorw("could not get camera info from camera service", "err", err)
-					w.WriteHeader(500)
-					return
-				}
-				if resp.StatusCode == http.StatusNotFound {
-					logger.Errorw("unknown camera stream requested")
-					w.WriteHeader(http.StatusNotFound)
-					return
-				} else if resp.StatusCode != 200 {
-					logger.Errorw("unknown server error")
-					w.WriteHeader(500)
-					return
-				}
-
-				var streamConfig webrtcstream.Config
-				bodyDecoder := json.NewDecoder(resp.Body)
-				if err := bodyDecoder.Decode(&streamConfig); err != nil {
-					logger.Errorw("could not parse camera service response body")
-					w.WriteHeader(500)
-					return
-				}
-
-				newStream, err := webrtcstream.New(streamConfig)
-				if err != nil {
-					logger.Error("error creating stream: %w", err)
-				}
-				streamStore[int(cameraId)] = newStream
-				stream = newStream
+			stream, err := streamStore.Get(cameraId, logger)
+			if errors.Is(err, ErrCameraNotFound) {
+				logger.Errorw("unknown camera stream requested")
+				w.WriteHeader(http.StatusNotFound)
+				return
+			} else if err != nil {
+				logger.Errorw("could not get camera stream", "err", err)
+				w.WriteHeader(500)
+				return
 			}
 
 			ctx := context.WithValue(r.Context(), "stream", stream)
@@ -94,13 +95,75 @@ func main() {
 		})
 	}
 
+	var recorders *Recorders
+	if config.Recording.Enabled {
+		recorders = StartRecorders(context.Background(), config.Recording, streamStore, logger)
+	}
+
+	r.With(auth.RequireAllStreams(logger)).Get("/discovery", makeGetDiscoveryHandler(config.Discovery, logger))
+	r.With(auth.RequireAllStreams(logger)).Get("/events", makeGetEventsHandler(events, logger))
+
 	r.Route("/{streamID}", func(r chi.Router) {
-		r.Use(streamCtx)
-		r.Get("/", makeGetStreamHandler(logger))
+		r.Use(auth.RequireStream(streamIdFromRequest, logger))
+		r.Post("/signed-url", makeSignURLHandler(urlSigner, logger))
+
+		if recorders != nil {
+			r.Route("/recordings", func(r chi.Router) {
+				r.Get("/", makeListRecordingsHandler(recorders, logger))
+				r.Post("/events", makeStartEventRecordingHandler(recorders, logger))
+				r.Delete("/events/{eventID}", makeStopEventRecordingHandler(recorders, logger))
+			})
+			r.Get("/playback", makeGetPlaybackHandler(recorders, webrtcConfig, peerConnectionFactory, logger))
+		}
+
+		r.Group(func(r chi.Router) {
+			r.Use(streamCtx)
+			r.Get("/", makeGetStreamHandler(webrtcConfig, peerConnectionFactory, events, logger))
+			r.Get("/snapshot.jpg", makeGetSnapshotHandler(logger))
+			r.Get("/mjpeg", makeGetMjpegHandler(logger))
+			r.Get("/motion", makeGetMotionHandler(logger))
+			r.Route("/ptz", func(r chi.Router) {
+				r.Get("/capabilities", makeGetPtzCapabilitiesHandler(logger))
+				r.Get("/presets", makeGetPtzPresetsHandler(logger))
+				r.Post("/move", makePtzMoveHandler(logger))
+				r.Post("/stop", makePtzStopHandler(logger))
+				r.Post("/presets/{presetToken}/goto", makePtzGotoPresetHandler(logger))
+			})
+			r.Route("/hls", func(r chi.Router) {
+				r.Get("/index.m3u8", makeGetHlsPlaylistHandler(logger))
+				r.Get("/init.mp4", makeGetHlsInitHandler(logger))
+				r.Get("/segment/{sequence}.m4s", makeGetHlsSegmentHandler(logger))
+				r.Get("/part/{sequence}.{part}.m4s", makeGetHlsPartHandler(logger))
+			})
+		})
 	})
 
-	logger.Infow("starting web server", "port", config.Port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", config.Port), r)
+	server := http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Port),
+		Handler: r,
+	}
+
+	if config.TLS.Enabled {
+		var certificateReloader *CertificateReloader
+		certificateReloader, err = NewCertificateReloader(config.TLS, logger)
+		if err != nil {
+			logger.Fatalw("could not load tls certificates", "err", err)
+		}
+
+		if server.TLSConfig, err = certificateReloader.TLSConfig(); err != nil {
+			logger.Fatalw("could not configure tls", "err", err)
+		}
+
+		if err := certificateReloader.Watch(context.Background()); err != nil {
+			logger.Errorw("could not watch tls certificates, they will only be reloaded on restart", "err", err)
+		}
+
+		logger.Infow("starting web server with tls", "port", config.Port)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		logger.Infow("starting web server", "port", config.Port)
+		err = server.ListenAndServe()
+	}
 	logger.Infow("server stopped")
 
 	if !errors.Is(err, http.ErrServerClosed) {
@@ -108,3 +171,9 @@ func main() {
 	}
 
 }
+
+// streamIdFromRequest parses the stream id in the request's url parameters
+func streamIdFromRequest(r *http.Request) (int, error) {
+	streamId, err := strconv.ParseInt(chi.URLParam(r, "streamID"), 10, 32)
+	return int(streamId), err
+}
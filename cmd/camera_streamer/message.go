@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
 	"github.com/mitchellh/mapstructure"
 	"github.com/pion/webrtc/v3"
 	"reflect"
+	"time"
 )
 
 type MsgType int
@@ -13,11 +15,86 @@ type MsgType int
 const (
 	SESSION_DESCRIPTION MsgType = iota
 	ICE_CANDIDATE
+	ICE_SERVERS
+	// Sent by viewers of recordings to control the playback
+	PLAYBACK_CONTROL
+	// Sent to viewers of recordings with the current state of the playback
+	PLAYBACK_STATUS
+	// Sent by viewers of live streams to control their track
+	TRACK_CONTROL
+	// Sent to viewers of live streams with metadata about their track
+	TRACK_METADATA
 	//STREAMS_DESCRIPTION
 )
 
 var PayloadParseError = errors.New("error parsing payload")
 
+type PlaybackAction string
+
+const (
+	PLAYBACK_PLAY           PlaybackAction = "play"
+	PLAYBACK_PAUSE          PlaybackAction = "pause"
+	PLAYBACK_SEEK           PlaybackAction = "seek"
+	PLAYBACK_RATE           PlaybackAction = "rate"
+	PLAYBACK_STATUS_REQUEST PlaybackAction = "status"
+)
+
+type PlaybackControl struct {
+	Action PlaybackAction `mapstructure:"action"`
+	// Time to seek to, for seek actions
+	Position time.Time `mapstructure:"position"`
+	// Playback speed, for rate actions
+	Rate float64 `mapstructure:"rate"`
+}
+
+type TrackAction string
+
+const (
+	TRACK_PAUSE    TrackAction = "pause"
+	TRACK_RESUME   TrackAction = "resume"
+	TRACK_SNAPSHOT TrackAction = "snapshot"
+	TRACK_KEYFRAME TrackAction = "keyframe"
+	TRACK_STATUS   TrackAction = "status"
+	// Streams are only encoded at the camera's resolution, so switching renditions always fails
+	TRACK_RENDITION TrackAction = "rendition"
+	// Only available for cameras with ONVIF PTZ support
+	TRACK_PTZ_MOVE   TrackAction = "ptz_move"
+	TRACK_PTZ_STOP   TrackAction = "ptz_stop"
+	TRACK_PTZ_PRESET TrackAction = "ptz_preset"
+)
+
+type TrackControl struct {
+	Action TrackAction `mapstructure:"action"`
+	// Size and quality of the image, for snapshot actions
+	Width   int `mapstructure:"width"`
+	Quality int `mapstructure:"quality"`
+	// Velocity and duration of ptz_move actions, which move the camera until stopped if the timeout is empty
+	Pan     float64 `mapstructure:"pan"`
+	Tilt    float64 `mapstructure:"tilt"`
+	Zoom    float64 `mapstructure:"zoom"`
+	Timeout string  `mapstructure:"timeout"`
+	// Token of the preset to go to, for ptz_preset actions
+	Preset string `mapstructure:"preset"`
+}
+
+type TrackMetadataKind string
+
+const (
+	METADATA_STATUS   TrackMetadataKind = "status"
+	METADATA_NAME     TrackMetadataKind = "name"
+	METADATA_SNAPSHOT TrackMetadataKind = "snapshot"
+	METADATA_ERROR    TrackMetadataKind = "error"
+)
+
+type TrackMetadata struct {
+	Kind   TrackMetadataKind         `json:"kind"`
+	Status *webrtcstream.TrackStatus `json:"status,omitempty"`
+	Name   string                    `json:"name,omitempty"`
+	// JPEG image, encoded in base64
+	Snapshot []byte `json:"snapshot,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
 type Message struct {
 	MsgType MsgType `json:"type"`
 	Payload any     `json:"payload"`
@@ -73,3 +150,46 @@ func (m Message) SessionDescription() (webrtc.SessionDescription, error) {
 
 	return sessionDescription, nil
 }
+
+func (m Message) PlaybackControl() (PlaybackControl, error) {
+	if m.MsgType != PLAYBACK_CONTROL {
+		return PlaybackControl{}, fmt.Errorf("message is not a playback control")
+	}
+
+	playbackControl := PlaybackControl{}
+
+	config := mapstructure.DecoderConfig{
+		Result:     &playbackControl,
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339),
+	}
+
+	decoder, err := mapstructure.NewDecoder(&config)
+
+	if err != nil {
+		return PlaybackControl{}, err
+	}
+
+	err = decoder.Decode(m.Payload)
+
+	if err != nil {
+		return PlaybackControl{}, errors.Join(PayloadParseError, err)
+	}
+
+	return playbackControl, nil
+}
+
+func (m Message) TrackControl() (TrackControl, error) {
+	if m.MsgType != TRACK_CONTROL {
+		return TrackControl{}, fmt.Errorf("message is not a track control")
+	}
+
+	trackControl := TrackControl{}
+
+	err := mapstructure.Decode(m.Payload, &trackControl)
+
+	if err != nil {
+		return TrackControl{}, errors.Join(PayloadParseError, err)
+	}
+
+	return trackControl, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+const (
+	defaultMjpegFps = 5
+	maxMjpegFps     = 30
+)
+
+// makeGetMjpegHandler returns a handler that streams the camera as multipart/x-mixed-replace JPEG frames, for clients
+// that can't use WebRTC. The frame rate can be lowered per client with the fps query parameter.
+func makeGetMjpegHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetMjpegHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		stream := ctx.Value("stream").(*webrtcstream.WebRTCStream)
+
+		fps := float64(defaultMjpegFps)
+		if fpsString := r.URL.Query().Get("fps"); fpsString != "" {
+			var err error
+			if fps, err = strconv.ParseFloat(fpsString, 64); err != nil || fps <= 0 || fps > maxMjpegFps {
+				http.Error(w, fmt.Sprintf("fps must be a number between 0 and %d", maxMjpegFps), http.StatusBadRequest)
+				return
+			}
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		writer := multipart.NewWriter(w)
+
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+writer.Boundary())
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+
+		logger.Infow("starting mjpeg stream", "fps", fps)
+
+		err := stream.StreamJpeg(ctx, fps, func(frame []byte) error {
+			part, err := writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type":   {"image/jpeg"},
+				"Content-Length": {strconv.Itoa(len(frame))},
+			})
+			if err != nil {
+				return err
+			}
+
+			if _, err := part.Write(frame); err != nil {
+				return err
+			}
+
+			flusher.Flush()
+			return nil
+		}, logger)
+
+		if err != nil {
+			logger.Debugw("mjpeg stream stopped", "err", err)
+		}
+
+		logger.Infow("mjpeg stream ended")
+	}
+}
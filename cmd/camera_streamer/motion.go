@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const (
+	// motionEventBufferSize is how many motion events may be waiting to be handled before new ones are dropped
+	motionEventBufferSize = 64
+	// sseKeepAliveInterval is how often idle event streams get a comment, so proxies don't close them
+	sseKeepAliveInterval = 15 * time.Second
+)
+
+// writeServerSentEvent writes an event with JSON data to an event stream and flushes it
+func writeServerSentEvent(w http.ResponseWriter, flusher http.Flusher, event string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded); err != nil {
+		return err
+	}
+
+	flusher.Flush()
+	return nil
+}
+
+// makeGetMotionHandler returns a handler that streams the camera's motion events as Server-Sent Events. The camera
+// detects motion while anyone is listening.
+func makeGetMotionHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetMotionHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		stream := ctx.Value("stream").(*webrtcstream.WebRTCStream)
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events := make(chan webrtcstream.MotionEvent, motionEventBufferSize)
+		handlerId, err := stream.AddMotionHandler(func(event webrtcstream.MotionEvent) {
+			select {
+			case events <- event:
+			default:
+				logger.Warnw("dropping motion event, client is falling behind")
+			}
+		}, logger)
+		if errors.Is(err, webrtcstream.ErrMotionDisabled) {
+			http.Error(w, "motion detection is not configured for this camera", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.Errorw("could not start motion detection", "err", err)
+			http.Error(w, "could not start motion detection", http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			if err := stream.RemoveMotionHandler(handlerId, logger); err != nil {
+				logger.Errorw("could not stop motion detection", "err", err)
+			}
+		}()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		logger.Infow("starting motion event stream")
+
+		keepAlive := time.NewTicker(sseKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Infow("motion event stream ended")
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case event := <-events:
+				if err := writeServerSentEvent(w, flusher, "motion", event); err != nil {
+					logger.Debugw("could not write motion event", "err", err)
+					return
+				}
+			}
+		}
+	}
+}
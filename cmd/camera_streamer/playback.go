@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/recording"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// playbackStatusInterval is how often viewers of recordings are sent the state of the playback
+const playbackStatusInterval = time.Second
+
+// playbackFiles selects the recordings covering a time range, preferring continuous recordings and falling back to
+// event recordings when there are none. A zero end means the range is open.
+func playbackFiles(files []recording.File, from time.Time, to time.Time) []webrtcstream.PlaybackFile {
+	for _, kind := range []recording.Kind{recording.KindContinuous, recording.KindEvent} {
+		var selected []webrtcstream.PlaybackFile
+		for _, file := range files {
+			if file.Kind != kind || file.End.Before(from) || (!to.IsZero() && !file.Start.Before(to)) {
+				continue
+			}
+			selected = append(selected, webrtcstream.PlaybackFile{Path: file.Path, Start: file.Start})
+		}
+
+		if len(selected) > 0 {
+			return selected
+		}
+	}
+
+	return nil
+}
+
+func parsePlaybackRange(r *http.Request) (from time.Time, to time.Time, err error) {
+	from, err = time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if toString := r.URL.Query().Get("to"); toString != "" {
+		to, err = time.Parse(time.RFC3339, toString)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	return from, to, nil
+}
+
+// makePlaybackChannel returns a session channel that applies the playback controls sent by the viewer and keeps it
+// informed of the playback's state
+func makePlaybackChannel(ctx context.Context, playback *webrtcstream.Playback, logger *zap.SugaredLogger) *SessionChannel {
+	logger = logger.Named("PlaybackChannel")
+
+	outgoing := make(chan Message)
+	go func() {
+		ticker := time.NewTicker(playbackStatusInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case outgoing <- Message{MsgType: PLAYBACK_STATUS, Payload: playback.Status()}:
+			}
+		}
+	}()
+
+	return &SessionChannel{
+		Outgoing: outgoing,
+		OnMessage: func(ctx context.Context, message Message) *Message {
+			if message.MsgType != PLAYBACK_CONTROL {
+				logger.Errorw("unknown message type received from peer", "message type", message.MsgType)
+				return nil
+			}
+
+			control, err := message.PlaybackControl()
+			if err != nil {
+				logger.Errorw("could not parse playback control", "err", err)
+				return nil
+			}
+
+			switch control.Action {
+			case PLAYBACK_PLAY:
+				err = playback.SetPaused(false)
+			case PLAYBACK_PAUSE:
+				err = playback.SetPaused(true)
+			case PLAYBACK_SEEK:
+				err = playback.Seek(control.Position, logger)
+			case PLAYBACK_RATE:
+				err = playback.SetRate(control.Rate)
+			case PLAYBACK_STATUS_REQUEST:
+			default:
+				logger.Errorw("unknown playback action", "action", control.Action)
+			}
+			if err != nil {
+				logger.Errorw("could not apply playback control", "action", control.Action, "err", err)
+			}
+
+			return &Message{MsgType: PLAYBACK_STATUS, Payload: playback.Status()}
+		},
+	}
+}
+
+// makeGetPlaybackHandler returns a handler that plays the recordings of a camera between the from and to query
+// parameters over a WebRTC session. Viewers control the playback with PLAYBACK_CONTROL messages.
+func makeGetPlaybackHandler(recorders *Recorders, webrtcConfig WebRTCConfig, peerConnectionFactory *PeerConnectionFactory, logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetPlaybackHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		cameraId, err := streamIdFromRequest(r)
+		if err != nil {
+			http.Error(w, "invalid camera id", http.StatusBadRequest)
+			return
+		}
+
+		from, to, err := parsePlaybackRange(r)
+		if err != nil || (!to.IsZero() && !to.After(from)) {
+			http.Error(w, "invalid time range", http.StatusBadRequest)
+			return
+		}
+
+		files, err := recording.List(recorders.cameraDirectory(cameraId))
+		if err != nil {
+			logger.Errorw("could not list recordings", "err", err)
+			http.Error(w, "could not list recordings", http.StatusInternalServerError)
+			return
+		}
+
+		playbackFiles := playbackFiles(files, from, to)
+		if len(playbackFiles) == 0 {
+			http.Error(w, "no recordings in time range", http.StatusNotFound)
+			return
+		}
+
+		playback, err := webrtcstream.NewPlayback(playbackFiles, from, to)
+		if err != nil {
+			logger.Errorw("could not create playback", "err", err)
+			http.Error(w, "could not create playback", http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		go func() {
+			if err := playback.Run(ctx, logger); err != nil {
+				logger.Errorw("playback failed", "err", err)
+			}
+		}()
+
+		logger.Info("starting playback session")
+
+		channel := makePlaybackChannel(ctx, playback, logger)
+		HandleWebRTC(w, r, []webrtc.TrackLocal{playback.Track()}, channel, webrtcConfig, peerConnectionFactory, logger)
+
+		logger.Info("playback session ended")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/onvif"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// ptzRequestTimeout is how long PTZ requests wait for the camera to respond
+const ptzRequestTimeout = 10 * time.Second
+
+type ptzMoveRequest struct {
+	onvif.Velocity
+	// The camera stops after this long, unless it's empty
+	Timeout string `json:"timeout"`
+}
+
+type ptzStopRequest struct {
+	PanTilt *bool `json:"pan_tilt"`
+	Zoom    *bool `json:"zoom"`
+}
+
+// ptzClient returns the ONVIF client of the stream in the request's context, writing an error response if the camera
+// has none
+func ptzClient(w http.ResponseWriter, r *http.Request) (*onvif.Client, bool) {
+	stream := r.Context().Value("stream").(*webrtcstream.WebRTCStream)
+
+	client, ok := stream.Onvif()
+	if !ok {
+		http.Error(w, "camera has no onvif configuration", http.StatusNotFound)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// writePtzError writes the response for an error returned by the camera's ONVIF services
+func writePtzError(w http.ResponseWriter, err error, logger *zap.SugaredLogger) {
+	var fault *onvif.Fault
+	switch {
+	case errors.Is(err, onvif.ErrPTZNotSupported):
+		http.Error(w, "camera does not support ptz", http.StatusNotFound)
+	case errors.Is(err, onvif.ErrUnauthorized):
+		logger.Errorw("camera rejected the onvif credentials", "err", err)
+		http.Error(w, "camera rejected the onvif credentials", http.StatusBadGateway)
+	case errors.As(err, &fault):
+		logger.Errorw("camera rejected ptz request", "err", err)
+		http.Error(w, fault.Reason, http.StatusBadGateway)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "camera did not respond in time", http.StatusGatewayTimeout)
+	default:
+		logger.Errorw("ptz request failed", "err", err)
+		http.Error(w, "could not reach camera", http.StatusBadGateway)
+	}
+}
+
+func makeGetPtzCapabilitiesHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetPtzCapabilitiesHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := ptzClient(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), ptzRequestTimeout)
+		defer cancel()
+
+		capabilities, err := client.GetCapabilities(ctx)
+		if err != nil {
+			writePtzError(w, err, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, capabilities, logger)
+	}
+}
+
+func makeGetPtzPresetsHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetPtzPresetsHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := ptzClient(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), ptzRequestTimeout)
+		defer cancel()
+
+		presets, err := client.GetPresets(ctx)
+		if err != nil {
+			writePtzError(w, err, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, presets, logger)
+	}
+}
+
+// makePtzMoveHandler returns a handler that starts moving the camera at the requested pan, tilt and zoom velocities
+func makePtzMoveHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("PtzMoveHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := ptzClient(w, r)
+		if !ok {
+			return
+		}
+
+		var request ptzMoveRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := request.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var timeout time.Duration
+		if request.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(request.Timeout); err != nil || timeout < 0 {
+				http.Error(w, "invalid timeout", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), ptzRequestTimeout)
+		defer cancel()
+
+		if err := client.ContinuousMove(ctx, request.Velocity, timeout); err != nil {
+			writePtzError(w, err, logger)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// makePtzStopHandler returns a handler that stops the camera's movements. Both pan and tilt and zoom are stopped
+// unless the request body says otherwise.
+func makePtzStopHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("PtzStopHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := ptzClient(w, r)
+		if !ok {
+			return
+		}
+
+		var request ptzStopRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+
+		panTilt, zoom := true, true
+		if request.PanTilt != nil {
+			panTilt = *request.PanTilt
+		}
+		if request.Zoom != nil {
+			zoom = *request.Zoom
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), ptzRequestTimeout)
+		defer cancel()
+
+		if err := client.Stop(ctx, panTilt, zoom); err != nil {
+			writePtzError(w, err, logger)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func makePtzGotoPresetHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("PtzGotoPresetHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := ptzClient(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), ptzRequestTimeout)
+		defer cancel()
+
+		if err := client.GotoPreset(ctx, chi.URLParam(r, "presetToken")); err != nil {
+			writePtzError(w, err, logger)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/recording"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// recorderRetryInterval is how long to wait before retrying to start a recorder whose camera isn't available
+	recorderRetryInterval = 30 * time.Second
+	retentionInterval     = time.Minute
+)
+
+// Recorders keeps the recorder of every camera configured for recording
+type Recorders struct {
+	config RecordingConfig
+
+	mu        sync.Mutex
+	recorders map[int]*recording.Recorder
+}
+
+// StartRecorders starts recording the configured cameras in the background, along with the retention policy. Cameras
+// that can't be reached are retried until ctx is canceled.
+func StartRecorders(ctx context.Context, config RecordingConfig, streamStore *StreamStore, logger *zap.SugaredLogger) *Recorders {
+	logger = logger.Named("Recorders")
+
+	recorders := &Recorders{
+		config:    config,
+		recorders: make(map[int]*recording.Recorder),
+	}
+
+	for _, camera := range config.Cameras {
+		go recorders.startRecorder(ctx, camera, streamStore, logger)
+	}
+
+	policy := recording.RetentionPolicy{
+		MaxAge:  config.MaxAge,
+		MaxSize: config.MaxSizeMB * 1024 * 1024,
+	}
+	go policy.Run(ctx, retentionInterval, recorders.directories, recorders.active, logger)
+
+	return recorders
+}
+
+func (r *Recorders) startRecorder(ctx context.Context, camera RecordingCameraConfig, streamStore *StreamStore, logger *zap.SugaredLogger) {
+	logger = logger.With("camera id", camera.Id)
+
+	var stream *webrtcstream.WebRTCStream
+	for {
+		var err error
+		if stream, err = streamStore.Get(camera.Id, logger); err == nil {
+			break
+		}
+		logger.Errorw("could not get camera stream for recording, retrying", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(recorderRetryInterval):
+		}
+	}
+
+	recorder, err := recording.NewRecorder(recording.Config{
+		Directory:        r.cameraDirectory(camera.Id),
+		Continuous:       camera.Continuous,
+		ChunkDuration:    r.config.ChunkDuration,
+		PreRoll:          r.config.PreRoll,
+		MaxEventDuration: r.config.MaxEventDuration,
+	}, logger)
+	if err != nil {
+		logger.Errorw("could not create recorder", "err", err)
+		return
+	}
+
+	go recorder.Run(ctx)
+
+	_, err = stream.AddEncodedFrameHandler(func(frame webrtcstream.EncodedFrame) {
+		recorder.HandleFrame(recording.Frame(frame))
+	}, logger)
+	if err != nil {
+		logger.Errorw("could not start recording", "err", err)
+		return
+	}
+
+	r.mu.Lock()
+	r.recorders[camera.Id] = recorder
+	r.mu.Unlock()
+
+	if camera.Motion {
+		if err := recordMotion(ctx, stream, recorder, r.config.PreRoll, logger); err != nil {
+			logger.Errorw("could not record motion", "err", err)
+		}
+	}
+
+	logger.Infow("recording camera", "continuous", camera.Continuous, "motion", camera.Motion)
+}
+
+// recordMotion makes an event recording from the moment motion starts in any of the camera's zones until it has ended
+// in all of them
+func recordMotion(ctx context.Context, stream *webrtcstream.WebRTCStream, recorder *recording.Recorder, preRoll time.Duration, logger *zap.SugaredLogger) error {
+	// Events arrive on the streaming thread, which must not wait on the recorder's disk writes
+	events := make(chan webrtcstream.MotionEvent, motionEventBufferSize)
+	_, err := stream.AddMotionHandler(func(event webrtcstream.MotionEvent) {
+		select {
+		case events <- event:
+		default:
+			logger.Warnw("dropping motion event, recorder is falling behind")
+		}
+	}, logger)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		activeZones := make(map[string]bool)
+		eventId := -1
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				if event.Type == webrtcstream.MotionStart {
+					activeZones[event.Zone] = true
+				} else {
+					delete(activeZones, event.Zone)
+				}
+
+				if len(activeZones) > 0 && eventId < 0 {
+					recordingEvent, err := recorder.StartEvent(preRoll)
+					if err != nil {
+						logger.Errorw("could not start motion recording", "err", err)
+						continue
+					}
+					eventId = recordingEvent.Id
+				} else if len(activeZones) == 0 && eventId >= 0 {
+					// the recording may have already been stopped by reaching its maximum duration
+					if _, err := recorder.StopEvent(eventId); err != nil && !errors.Is(err, recording.ErrEventNotFound) {
+						logger.Errorw("could not stop motion recording", "err", err)
+					}
+					eventId = -1
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (r *Recorders) cameraDirectory(cameraId int) string {
+	return filepath.Join(r.config.Directory, strconv.Itoa(cameraId))
+}
+
+// Get returns the recorder of a camera, if it's being recorded
+func (r *Recorders) Get(cameraId int) (*recording.Recorder, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recorder, ok := r.recorders[cameraId]
+	return recorder, ok
+}
+
+// directories returns the recordings directory of every camera, including those no longer recorded
+func (r *Recorders) directories() []string {
+	entries, err := os.ReadDir(r.config.Directory)
+	if err != nil {
+		return nil
+	}
+
+	var directories []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			directories = append(directories, filepath.Join(r.config.Directory, entry.Name()))
+		}
+	}
+	return directories
+}
+
+func (r *Recorders) active(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, recorder := range r.recorders {
+		if recorder.Active(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any, logger *zap.SugaredLogger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Errorw("could not write response", "err", err)
+	}
+}
+
+// makeListRecordingsHandler returns a handler that lists the recordings of a camera along with its event recordings
+// in progress
+func makeListRecordingsHandler(recorders *Recorders, logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("ListRecordingsHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		cameraId, err := streamIdFromRequest(r)
+		if err != nil {
+			http.Error(w, "invalid camera id", http.StatusBadRequest)
+			return
+		}
+
+		files, err := recording.List(recorders.cameraDirectory(cameraId))
+		if err != nil {
+			logger.Errorw("could not list recordings", "err", err)
+			http.Error(w, "could not list recordings", http.StatusInternalServerError)
+			return
+		}
+
+		response := struct {
+			Recordings []recording.File           `json:"recordings"`
+			Events     []recording.EventRecording `json:"events"`
+		}{
+			Recordings: files,
+			Events:     []recording.EventRecording{},
+		}
+		if response.Recordings == nil {
+			response.Recordings = []recording.File{}
+		}
+		if recorder, ok := recorders.Get(cameraId); ok {
+			response.Events = recorder.Events()
+		}
+
+		writeJSON(w, http.StatusOK, response, logger)
+	}
+}
+
+// makeStartEventRecordingHandler returns a handler that starts an event recording, including up to the pre_roll
+// query parameter of footage from before the request
+func makeStartEventRecordingHandler(recorders *Recorders, logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("StartEventRecordingHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		cameraId, err := streamIdFromRequest(r)
+		if err != nil {
+			http.Error(w, "invalid camera id", http.StatusBadRequest)
+			return
+		}
+
+		recorder, ok := recorders.Get(cameraId)
+		if !ok {
+			http.Error(w, "camera is not being recorded", http.StatusNotFound)
+			return
+		}
+
+		preRoll := recorders.config.PreRoll
+		if preRollString := r.URL.Query().Get("pre_roll"); preRollString != "" {
+			if preRoll, err = time.ParseDuration(preRollString); err != nil || preRoll < 0 {
+				http.Error(w, "invalid pre_roll", http.StatusBadRequest)
+				return
+			}
+		}
+
+		event, err := recorder.StartEvent(preRoll)
+		if err != nil {
+			logger.Errorw("could not start event recording", "err", err)
+			http.Error(w, "could not start event recording", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, event, logger)
+	}
+}
+
+func makeStopEventRecordingHandler(recorders *Recorders, logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("StopEventRecordingHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		cameraId, err := streamIdFromRequest(r)
+		if err != nil {
+			http.Error(w, "invalid camera id", http.StatusBadRequest)
+			return
+		}
+		eventId, err := strconv.Atoi(chi.URLParam(r, "eventID"))
+		if err != nil {
+			http.Error(w, "invalid event id", http.StatusBadRequest)
+			return
+		}
+
+		recorder, ok := recorders.Get(cameraId)
+		if !ok {
+			http.Error(w, "camera is not being recorded", http.StatusNotFound)
+			return
+		}
+
+		event, err := recorder.StopEvent(eventId)
+		if errors.Is(err, recording.ErrEventNotFound) {
+			http.Error(w, "event recording not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.Errorw("could not stop event recording", "err", err)
+			http.Error(w, "could not stop event recording", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, event, logger)
+	}
+}
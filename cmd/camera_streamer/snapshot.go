@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// snapshotTimeout is how long a snapshot request waits for the camera to deliver a frame
+const snapshotTimeout = 10 * time.Second
+
+func makeGetSnapshotHandler(logger *zap.SugaredLogger) http.HandlerFunc {
+	logger = logger.Named("GetSnapshotHandler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream := r.Context().Value("stream").(*webrtcstream.WebRTCStream)
+
+		var options webrtcstream.SnapshotOptions
+		var err error
+
+		query := r.URL.Query()
+		if width := query.Get("width"); width != "" {
+			if options.Width, err = strconv.Atoi(width); err != nil || options.Width <= 0 {
+				http.Error(w, "invalid width", http.StatusBadRequest)
+				return
+			}
+		}
+		if quality := query.Get("quality"); quality != "" {
+			if options.Quality, err = strconv.Atoi(quality); err != nil || options.Quality < 1 || options.Quality > 100 {
+				http.Error(w, "invalid quality", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), snapshotTimeout)
+		defer cancel()
+
+		snapshot, err := stream.Snapshot(ctx, options, logger)
+		if errors.Is(err, context.DeadlineExceeded) {
+			logger.Errorw("timed out waiting for snapshot", "err", err)
+			http.Error(w, "camera did not deliver a frame in time", http.StatusGatewayTimeout)
+			return
+		} else if err != nil {
+			logger.Errorw("could not take snapshot", "err", err)
+			http.Error(w, "could not take snapshot", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(snapshot)))
+		w.Header().Set("Cache-Control", "no-store")
+		if _, err := w.Write(snapshot); err != nil {
+			logger.Debugw("could not write snapshot", "err", err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cameraRefreshInterval is how often the configuration of existing streams is looked up again
+const cameraRefreshInterval = time.Minute
+
+// cameraServiceTimeout is how long a request to the camera service may take before giving up
+const cameraServiceTimeout = 10 * time.Second
+
+var ErrCameraNotFound = errors.New("camera not found")
+
+// StreamStore creates the stream of each camera the first time it's needed, using the camera service to look up its
+// configuration, and keeps it around for later requests.
+type StreamStore struct {
+	cameraServiceUrl string
+	client           *http.Client
+	events           *EventHub
+
+	mu      sync.Mutex
+	streams map[int]*webrtcstream.WebRTCStream
+	// Streams being created, so concurrent requests for the same camera wait for a single pipeline to be built
+	pending map[int]*pendingStream
+}
+
+type pendingStream struct {
+	done   chan struct{}
+	stream *webrtcstream.WebRTCStream
+	err    error
+}
+
+func NewStreamStore(config CameraServiceConfig, events *EventHub) *StreamStore {
+	return &StreamStore{
+		cameraServiceUrl: fmt.Sprintf("http://%s:%d/", config.Hostname, config.Port),
+		client:           &http.Client{Timeout: cameraServiceTimeout},
+		events:           events,
+		streams:          make(map[int]*webrtcstream.WebRTCStream),
+		pending:          make(map[int]*pendingStream),
+	}
+}
+
+// Get returns the stream of a camera, creating it if it doesn't exist yet
+func (s *StreamStore) Get(cameraId int, logger *zap.SugaredLogger) (*webrtcstream.WebRTCStream, error) {
+	s.mu.Lock()
+	if stream, ok := s.streams[cameraId]; ok {
+		s.mu.Unlock()
+		return stream, nil
+	}
+
+	if pending, ok := s.pending[cameraId]; ok {
+		s.mu.Unlock()
+		<-pending.done
+		return pending.stream, pending.err
+	}
+
+	pending := &pendingStream{done: make(chan struct{})}
+	s.pending[cameraId] = pending
+	s.mu.Unlock()
+
+	// The lock is not held while the stream is created, so a slow camera service doesn't block other cameras
+	pending.stream, pending.err = s.create(cameraId, logger)
+
+	s.mu.Lock()
+	delete(s.pending, cameraId)
+	if pending.err == nil {
+		s.streams[cameraId] = pending.stream
+	}
+	s.mu.Unlock()
+	close(pending.done)
+
+	if pending.err != nil {
+		return nil, pending.err
+	}
+
+	s.events.Publish(Event{Event: webrtcstream.Event{Type: EVENT_STREAM_CREATED}, StreamId: cameraId})
+	return pending.stream, nil
+}
+
+func (s *StreamStore) create(cameraId int, logger *zap.SugaredLogger) (*webrtcstream.WebRTCStream, error) {
+	logger.Debugw("creating camera stream", "camera id", cameraId)
+
+	streamConfig, err := s.fetchConfig(cameraId)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := webrtcstream.New(streamConfig, logger)
+	if err != nil {
+		return nil, fmt.Errorf("error creating stream: %w", err)
+	}
+
+	stream.OnEvent(s.events.streamHandler(cameraId))
+	return stream, nil
+}
+
+// Refresh looks up the configuration of the existing streams every interval until ctx is canceled, so name changes in
+// the camera service reach their viewers. Streams of cameras removed from the camera service are destroyed once nobody
+// is using them.
+func (s *StreamStore) Refresh(ctx context.Context, interval time.Duration, logger *zap.SugaredLogger) {
+	logger = logger.Named("Refresh")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		streams := make(map[int]*webrtcstream.WebRTCStream, len(s.streams))
+		for id, stream := range s.streams {
+			streams[id] = stream
+		}
+		s.mu.Unlock()
+
+		for id, stream := range streams {
+			config, err := s.fetchConfig(id)
+			if errors.Is(err, ErrCameraNotFound) {
+				s.destroy(id, stream, logger)
+				continue
+			} else if err != nil {
+				logger.Warnw("could not refresh camera configuration", "camera id", id, "err", err)
+				continue
+			}
+
+			if config.Name != stream.CameraName() {
+				logger.Infow("camera was renamed", "camera id", id, "name", config.Name)
+				if err := stream.SetName(config.Name); err != nil {
+					logger.Errorw("could not update camera name overlay", "camera id", id, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// destroy closes the stream of a camera that no longer exists, unless it's still in use
+func (s *StreamStore) destroy(cameraId int, stream *webrtcstream.WebRTCStream, logger *zap.SugaredLogger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := stream.Close(); errors.Is(err, webrtcstream.ErrStreamInUse) {
+		logger.Debugw("camera was removed, but its stream is still in use", "camera id", cameraId)
+		return
+	} else if err != nil {
+		logger.Errorw("could not close stream of removed camera", "camera id", cameraId, "err", err)
+	}
+
+	delete(s.streams, cameraId)
+	s.events.Publish(Event{Event: webrtcstream.Event{Type: EVENT_STREAM_DESTROYED}, StreamId: cameraId})
+	logger.Infow("camera was removed, destroyed its stream", "camera id", cameraId)
+}
+
+func (s *StreamStore) fetchConfig(cameraId int) (webrtcstream.Config, error) {
+	resp, err := s.client.Get(s.cameraServiceUrl + "cameras/" + strconv.Itoa(cameraId))
+	if err != nil {
+		return webrtcstream.Config{}, fmt.Errorf("could not get camera info from camera service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return webrtcstream.Config{}, ErrCameraNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return webrtcstream.Config{}, fmt.Errorf("camera service responded with status %d", resp.StatusCode)
+	}
+
+	var streamConfig webrtcstream.Config
+	if err := json.NewDecoder(resp.Body).Decode(&streamConfig); err != nil {
+		return webrtcstream.Config{}, fmt.Errorf("could not parse camera service response body: %w", err)
+	}
+
+	return streamConfig, nil
+}
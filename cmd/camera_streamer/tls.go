@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reloadDebounce groups together the file events produced while certificates are being replaced
+const reloadDebounce = 500 * time.Millisecond
+
+// CertificateReloader serves the certificate and client CA files in the TLS config, reloading them when they change
+// on disk or when the process receives a SIGHUP. Established connections keep using the certificate they were
+// negotiated with.
+type CertificateReloader struct {
+	config      TLSConfig
+	certificate atomic.Pointer[tls.Certificate]
+	logger      *zap.SugaredLogger
+
+	mu        sync.Mutex
+	clientCAs *x509.CertPool
+	// The server's config and a clone of it with the latest client CAs, handed to every handshake. Both are nil
+	// until TLSConfig is called.
+	baseConfig   *tls.Config
+	clientConfig *tls.Config
+}
+
+func NewCertificateReloader(config TLSConfig, logger *zap.SugaredLogger) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{
+		config: config,
+		logger: logger.Named("CertificateReloader"),
+	}
+
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Reload reads the certificate and client CA files again. The previous ones are kept if any of them is invalid.
+func (c *CertificateReloader) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(c.config.CertFile, c.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if c.config.ClientCAFile != "" {
+		data, err := os.ReadFile(c.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read client ca file: %w", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client ca file %s", c.config.ClientCAFile)
+		}
+	}
+
+	c.certificate.Store(&certificate)
+
+	c.mu.Lock()
+	c.clientCAs = clientCAs
+	c.updateClientConfigLocked()
+	c.mu.Unlock()
+
+	c.logger.Infow("loaded tls certificate", "file", c.config.CertFile)
+
+	return nil
+}
+
+// TLSConfig returns a server TLS config that always uses the latest loaded certificates
+func (c *CertificateReloader) TLSConfig() (*tls.Config, error) {
+	var clientAuth tls.ClientAuthType
+	switch c.config.ClientAuth {
+	case "", "none":
+		clientAuth = tls.NoClientCert
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client auth mode '%s'", c.config.ClientAuth)
+	}
+
+	if clientAuth != tls.NoClientCert && c.config.ClientCAFile == "" {
+		return nil, fmt.Errorf("client certificate authentication requires a client ca file")
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The server only offers HTTP/2 on its own if the config has no protocols, which doesn't cover the configs
+		// handed to each handshake
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.certificate.Load(), nil
+		},
+		ClientAuth: clientAuth,
+	}
+
+	if c.config.ClientCAFile == "" {
+		return config, nil
+	}
+
+	// The client CAs can only be changed through a config for each handshake. It's built when they're loaded, as a
+	// clone of the server's config, so it keeps its settings and session ticket keys.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.baseConfig = config
+	c.updateClientConfigLocked()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return c.clientConfig, nil
+	}
+
+	return config, nil
+}
+
+func (c *CertificateReloader) updateClientConfigLocked() {
+	if c.baseConfig == nil {
+		return
+	}
+
+	clientConfig := c.baseConfig.Clone()
+	clientConfig.GetConfigForClient = nil
+	clientConfig.ClientCAs = c.clientCAs
+	c.clientConfig = clientConfig
+}
+
+// Watch reloads the certificates whenever their files change or a SIGHUP is received, until ctx is canceled
+func (c *CertificateReloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// Watch the folders instead of the files, since certificates are usually replaced by renaming new files or
+	// symlinks over the old ones
+	watchedFolders := make(map[string]bool)
+	for _, file := range []string{c.config.CertFile, c.config.KeyFile, c.config.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		folder := filepath.Dir(file)
+		if watchedFolders[folder] {
+			continue
+		}
+		watchedFolders[folder] = true
+		if err := watcher.Add(folder); err != nil {
+			_ = watcher.Close()
+			return fmt.Errorf("could not watch %s: %w", folder, err)
+		}
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+		defer watcher.Close()
+
+		// Stopped timer that fires a reload once the file events settle down
+		debounce := time.NewTimer(reloadDebounce)
+		debounce.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				c.logger.Infow("received SIGHUP, reloading certificates")
+				c.reloadAndLog()
+			case <-watcher.Events:
+				debounce.Reset(reloadDebounce)
+			case err := <-watcher.Errors:
+				c.logger.Errorw("error watching certificate files", "err", err)
+			case <-debounce.C:
+				c.logger.Infow("certificate files changed, reloading certificates")
+				c.reloadAndLog()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *CertificateReloader) reloadAndLog() {
+	if err := c.Reload(); err != nil {
+		c.logger.Errorw("could not reload certificates, keeping the previous ones", "err", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webhook"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// webhookCheckInterval is how often debounced source transitions are checked for being settled
+	webhookCheckInterval = time.Second
+	// webhookQueueSize is how many webhooks of a stream may wait for the previous one to be delivered to a target
+	webhookQueueSize = 16
+)
+
+type WebhookType string
+
+const (
+	WEBHOOK_CAMERA_OFFLINE  WebhookType = "camera_offline"
+	WEBHOOK_CAMERA_ONLINE   WebhookType = "camera_online"
+	WEBHOOK_PIPELINE_ERRORS WebhookType = "pipeline_errors"
+)
+
+// WebhookPayload is the body of every webhook request
+type WebhookPayload struct {
+	Type     WebhookType `json:"type"`
+	StreamId int         `json:"stream_id"`
+	Time     time.Time   `json:"time"`
+	// When the camera went offline or came back online
+	Since *time.Time `json:"since,omitempty"`
+	// Number of errors within the error window and the text of the latest one, for pipeline errors
+	Errors    int    `json:"errors,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+type webhookStreamState struct {
+	// Whether the source was online when last notified, and whether it is now
+	notifiedOnline bool
+	online         bool
+	since          time.Time
+
+	errors         []time.Time
+	lastError      string
+	errorsNotified time.Time
+}
+
+// StartWebhooks notifies the configured targets when cameras go offline or come back online, or when their pipelines
+// error repeatedly, until ctx is canceled
+func StartWebhooks(ctx context.Context, config WebhooksConfig, events *EventHub, logger *zap.SugaredLogger) {
+	logger = logger.Named("Webhooks")
+
+	sender := webhook.NewSender(config.Timeout, config.MaxRetries, config.RetryDelay)
+	targets := make([]webhook.Target, len(config.Targets))
+	for i, target := range config.Targets {
+		targets[i] = webhook.Target{URL: target.URL, Secret: target.Secret}
+	}
+
+	// The webhooks of a stream are delivered to each target in order, so a retried notification can't arrive after
+	// the one that followed it. Queues are only used from the goroutine below.
+	type queueKey struct {
+		target   int
+		streamId int
+	}
+	queues := make(map[queueKey]chan WebhookPayload)
+	queueOf := func(key queueKey) chan WebhookPayload {
+		queue, ok := queues[key]
+		if ok {
+			return queue
+		}
+
+		queue = make(chan WebhookPayload, webhookQueueSize)
+		queues[key] = queue
+
+		target := targets[key.target]
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case payload := <-queue:
+					if err := sender.Send(ctx, target, payload); err != nil {
+						logger.Errorw("could not deliver webhook", "url", target.URL, "type", payload.Type, "err", err)
+					}
+				}
+			}
+		}()
+
+		return queue
+	}
+
+	notify := func(payload WebhookPayload) {
+		logger.Infow("sending webhook", "type", payload.Type, "stream id", payload.StreamId)
+		for i := range targets {
+			select {
+			case <-ctx.Done():
+			case queueOf(queueKey{i, payload.StreamId}) <- payload:
+			}
+		}
+	}
+
+	subscriberId, subscription := events.Subscribe()
+
+	go func() {
+		defer events.Unsubscribe(subscriberId)
+
+		ticker := time.NewTicker(webhookCheckInterval)
+		defer ticker.Stop()
+
+		// Cameras are assumed to be online until they report otherwise
+		states := make(map[int]*webhookStreamState)
+		stateOf := func(streamId int) *webhookStreamState {
+			state, ok := states[streamId]
+			if !ok {
+				state = &webhookStreamState{notifiedOnline: true, online: true}
+				states[streamId] = state
+			}
+			return state
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for streamId, state := range states {
+					if state.online == state.notifiedOnline || now.Sub(state.since) < config.Debounce {
+						continue
+					}
+
+					state.notifiedOnline = state.online
+					payloadType := WEBHOOK_CAMERA_OFFLINE
+					if state.online {
+						payloadType = WEBHOOK_CAMERA_ONLINE
+					}
+					since := state.since
+					notify(WebhookPayload{Type: payloadType, StreamId: streamId, Time: now, Since: &since})
+				}
+			case event := <-subscription:
+				switch event.Type {
+				case webrtcstream.EventSourceConnected, webrtcstream.EventSourceDisconnected:
+					state := stateOf(event.StreamId)
+					state.online = event.Type == webrtcstream.EventSourceConnected
+					state.since = event.Time
+				case webrtcstream.EventPipelineError:
+					state := stateOf(event.StreamId)
+					state.lastError = event.Error
+
+					// Only the errors within the window count
+					cutoff := event.Time.Add(-config.ErrorWindow)
+					recent := state.errors[:0]
+					for _, errorTime := range state.errors {
+						if errorTime.After(cutoff) {
+							recent = append(recent, errorTime)
+						}
+					}
+					state.errors = append(recent, event.Time)
+
+					if len(state.errors) >= config.ErrorThreshold && event.Time.Sub(state.errorsNotified) >= config.ErrorWindow {
+						state.errorsNotified = event.Time
+						notify(WebhookPayload{
+							Type:      WEBHOOK_PIPELINE_ERRORS,
+							StreamId:  event.StreamId,
+							Time:      event.Time,
+							Errors:    len(state.errors),
+							LastError: state.lastError,
+						})
+					}
+				case EVENT_STREAM_DESTROYED:
+					delete(states, event.StreamId)
+				}
+			}
+		}
+	}()
+}
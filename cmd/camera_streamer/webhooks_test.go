@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/webrtcstream"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver collects the webhooks delivered to it, failing the first failures requests with a server error
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	received []WebhookPayload
+}
+
+func newWebhookReceiver(t *testing.T, failures int) (*webhookReceiver, *httptest.Server) {
+	receiver := &webhookReceiver{failures: failures}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+
+		if receiver.failures > 0 {
+			receiver.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var payload WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("could not decode webhook: %v", err)
+		}
+		receiver.received = append(receiver.received, payload)
+	}))
+	t.Cleanup(server.Close)
+
+	return receiver, server
+}
+
+func (r *webhookReceiver) payloads() []WebhookPayload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]WebhookPayload(nil), r.received...)
+}
+
+// waitForPayloads waits until count webhooks were received, or fails the test after timeout
+func (r *webhookReceiver) waitForPayloads(t *testing.T, count int, timeout time.Duration) []WebhookPayload {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if payloads := r.payloads(); len(payloads) >= count {
+			return payloads
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected %d webhooks, got %+v", count, r.payloads())
+	return nil
+}
+
+func startTestWebhooks(t *testing.T, config WebhooksConfig, url string) *EventHub {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	config.Targets = []WebhookTargetConfig{{URL: url}}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	if config.ErrorThreshold == 0 {
+		config.ErrorThreshold = 100
+		config.ErrorWindow = time.Minute
+	}
+
+	events := NewEventHub()
+	StartWebhooks(ctx, config, events, zap.NewNop().Sugar())
+	return events
+}
+
+func publishStreamEvent(events *EventHub, eventType webrtcstream.EventType, eventTime time.Time) {
+	events.Publish(Event{Event: webrtcstream.Event{Type: eventType, Time: eventTime}, StreamId: 1})
+}
+
+func TestWebhooksDebounceFlapping(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, 0)
+	events := startTestWebhooks(t, WebhooksConfig{Debounce: 2 * time.Second}, server.URL)
+
+	for i := 0; i < 3; i++ {
+		publishStreamEvent(events, webrtcstream.EventSourceDisconnected, time.Now())
+		time.Sleep(100 * time.Millisecond)
+		publishStreamEvent(events, webrtcstream.EventSourceConnected, time.Now())
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The camera settled online, as it was last notified
+	time.Sleep(2*time.Second + 2*webhookCheckInterval)
+	if payloads := receiver.payloads(); len(payloads) != 0 {
+		t.Errorf("expected no webhooks for a flapping camera, got %+v", payloads)
+	}
+}
+
+func TestWebhooksDebounceOffline(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, 0)
+	events := startTestWebhooks(t, WebhooksConfig{Debounce: 2 * time.Second}, server.URL)
+
+	since := time.Now()
+	publishStreamEvent(events, webrtcstream.EventSourceDisconnected, since)
+
+	time.Sleep(time.Second)
+	if payloads := receiver.payloads(); len(payloads) != 0 {
+		t.Fatalf("expected no webhooks within the debounce, got %+v", payloads)
+	}
+
+	payloads := receiver.waitForPayloads(t, 1, 2*time.Second+2*webhookCheckInterval)
+	if payloads[0].Type != WEBHOOK_CAMERA_OFFLINE || payloads[0].StreamId != 1 {
+		t.Errorf("unexpected webhook %+v", payloads[0])
+	}
+	if payloads[0].Since == nil || !payloads[0].Since.Equal(since) {
+		t.Errorf("expected the webhook to be since %s, got %v", since, payloads[0].Since)
+	}
+}
+
+func TestWebhooksOrderedDelivery(t *testing.T) {
+	// The offline webhook fails once, and is retried after the online one was queued
+	receiver, server := newWebhookReceiver(t, 1)
+	events := startTestWebhooks(t, WebhooksConfig{
+		MaxRetries: 1,
+		RetryDelay: 3 * webhookCheckInterval,
+	}, server.URL)
+
+	publishStreamEvent(events, webrtcstream.EventSourceDisconnected, time.Now())
+	time.Sleep(webhookCheckInterval + webhookCheckInterval/2)
+	publishStreamEvent(events, webrtcstream.EventSourceConnected, time.Now())
+
+	payloads := receiver.waitForPayloads(t, 2, 5*webhookCheckInterval)
+	if payloads[0].Type != WEBHOOK_CAMERA_OFFLINE || payloads[1].Type != WEBHOOK_CAMERA_ONLINE {
+		t.Errorf("expected offline then online webhooks, got %+v", payloads)
+	}
+}
+
+func TestWebhooksErrorThreshold(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, 0)
+	events := startTestWebhooks(t, WebhooksConfig{ErrorThreshold: 3, ErrorWindow: time.Minute}, server.URL)
+
+	now := time.Now()
+	publishError := func(eventTime time.Time, text string) {
+		events.Publish(Event{Event: webrtcstream.Event{Type: webrtcstream.EventPipelineError, Time: eventTime, Error: text}, StreamId: 1})
+	}
+
+	// Errors outside the window don't count
+	publishError(now.Add(-2*time.Minute), "old")
+	publishError(now.Add(-90*time.Second), "old")
+	publishError(now, "first")
+	publishError(now.Add(time.Millisecond), "second")
+
+	time.Sleep(200 * time.Millisecond)
+	if payloads := receiver.payloads(); len(payloads) != 0 {
+		t.Fatalf("expected no webhooks below the threshold, got %+v", payloads)
+	}
+
+	publishError(now.Add(2*time.Millisecond), "third")
+	payloads := receiver.waitForPayloads(t, 1, time.Second)
+	if payloads[0].Type != WEBHOOK_PIPELINE_ERRORS || payloads[0].Errors != 3 || payloads[0].LastError != "third" {
+		t.Errorf("unexpected webhook %+v", payloads[0])
+	}
+
+	// Further errors within the window are not notified again
+	publishError(now.Add(3*time.Millisecond), "fourth")
+	time.Sleep(200 * time.Millisecond)
+	if payloads := receiver.payloads(); len(payloads) != 1 {
+		t.Errorf("expected a single webhook per window, got %+v", payloads)
+	}
+}
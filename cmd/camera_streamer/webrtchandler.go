@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/pion/webrtc/v3"
@@ -10,34 +11,83 @@ import (
 	"net/http"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
+	"strings"
 )
 
 type WebRTCConfig struct {
 	AllowAllOrigins bool
-	ClientOrigins   []string
+	// Origins allowed to open signaling sessions, which may contain wildcards, as in https://*.example.com
+	ClientOrigins []string
 }
 
-// HandleWebRTC configures the signaling session utilizing a given context
-func HandleWebRTC(w http.ResponseWriter, r *http.Request, tracks []webrtc.TrackLocal, logger *zap.SugaredLogger) {
-	logger = logger.Named("HandleWebRTC")
+// acceptOptions makes the signaling websocket accept the allowed origins, as well as same origin requests and
+// requests without an origin, which don't come from browsers. Websocket origins are matched by host alone, so the
+// scheme of the allowed origins is ignored.
+func (c WebRTCConfig) acceptOptions() *websocket.AcceptOptions {
+	options := websocket.AcceptOptions{InsecureSkipVerify: c.AllowAllOrigins}
+
+	for _, origin := range c.ClientOrigins {
+		if origin == "*" {
+			options.InsecureSkipVerify = true
+			continue
+		}
 
-	acceptOptions := websocket.AcceptOptions{
-		InsecureSkipVerify: true,
+		if _, host, found := strings.Cut(origin, "://"); found {
+			origin = host
+		}
+		options.OriginPatterns = append(options.OriginPatterns, origin)
 	}
 
+	return &options
+}
+
+// SessionChannel carries application messages alongside the signaling messages of a session, such as playback
+// controls. Peers may send them over the signaling socket or over a data channel they open.
+type SessionChannel struct {
+	// OnMessage handles messages that aren't part of the signaling, returning a reply to send back if it's not nil
+	OnMessage func(ctx context.Context, message Message) *Message
+	// Messages received here are sent to the peer over the server's data channel if there's one, or over the
+	// signaling socket otherwise
+	Outgoing <-chan Message
+	// Label of a data channel the server opens on the peer connection. The session outlives the signaling socket
+	// while it's open. Empty if the server doesn't open one.
+	DataChannel string
+}
+
+// HandleWebRTC configures the signaling session utilizing a given context. The session channel is optional.
+func HandleWebRTC(w http.ResponseWriter, r *http.Request, tracks []webrtc.TrackLocal, channel *SessionChannel, webrtcConfig WebRTCConfig, peerConnectionFactory *PeerConnectionFactory, logger *zap.SugaredLogger) {
+	logger = logger.Named("HandleWebRTC")
+
 	logger.Debugw("opening websocket")
 
-	// Open socket for signaling session
-	socket, err := websocket.Accept(w, r, &acceptOptions)
+	// Open socket for signaling session. Accept answers requests it rejects, such as those of disallowed origins.
+	socket, err := websocket.Accept(w, r, webrtcConfig.acceptOptions())
 	if err != nil {
-		logger.Error(fmt.Errorf("error opening socket: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		logger.Infow("could not open socket", "origin", r.Header.Get("Origin"), "err", err)
+		return
+	}
+
+	// Share the session's ICE servers with the client before negotiation starts, so both peers use the same servers
+	// and TURN credentials
+	iceServers := peerConnectionFactory.ICEServers()
+
+	logger.Debugw("sending ice servers to peer")
+	err = wsjson.Write(r.Context(), socket, Message{MsgType: ICE_SERVERS, Payload: iceServers})
+	if err != nil {
+		logger.Error(fmt.Errorf("error sending ice servers to peer: %w", err))
+
+		err := socket.Close(websocket.StatusInternalError, "signaling error")
+
+		if err != nil {
+			logger.Error(err)
+		}
+
 		return
 	}
 
 	logger.Debugw("opening peer connection")
 	// Open peer connection
-	peerConnection, err := webrtc.NewPeerConnection(webrtcConfig)
+	peerConnection, err := peerConnectionFactory.NewPeerConnection(iceServers)
 	if err != nil {
 		logger.Error(fmt.Errorf("error creating peer connection: %w", err))
 
@@ -76,10 +126,42 @@ func HandleWebRTC(w http.ResponseWriter, r *http.Request, tracks []webrtc.TrackL
 		}
 	}
 
-	HandleSignalingSession(signalingCtx, socket, peerConnection, logger)
+	var dataChannel *webrtc.DataChannel
+	if channel != nil {
+		peerConnection.OnDataChannel(makeDataChannelHandler(signalingCtx, channel, logger))
+
+		if channel.DataChannel != "" {
+			logger.Debugw("opening data channel", "label", channel.DataChannel)
+			dataChannel, err = peerConnection.CreateDataChannel(channel.DataChannel, nil)
+			if err != nil {
+				logger.Error(fmt.Errorf("error creating data channel: %w", err))
+			}
+		}
+
+		if dataChannel != nil {
+			handleDataChannelMessages(signalingCtx, dataChannel, channel, logger)
+			dataChannel.OnOpen(func() {
+				go forwardOutgoingMessages(signalingCtx, channel.Outgoing, func(message Message) error {
+					return sendDataChannelMessage(dataChannel, message)
+				}, logger)
+			})
+		} else {
+			go forwardOutgoingMessages(signalingCtx, channel.Outgoing, func(message Message) error {
+				return wsjson.Write(signalingCtx, socket, message)
+			}, logger)
+		}
+	}
+
+	HandleSignalingSession(signalingCtx, socket, peerConnection, channel, logger)
+
+	// Once connected, the data channel can carry on the session without the signaling socket
+	if dataChannel != nil && dataChannel.ReadyState() == webrtc.DataChannelStateOpen && signalingCtx.Err() == nil {
+		logger.Debugw("signaling socket closed, continuing session over data channel")
+		<-signalingCtx.Done()
+	}
 }
 
-func HandleSignalingSession(ctx context.Context, socket *websocket.Conn, peerConnection *webrtc.PeerConnection, logger *zap.SugaredLogger) {
+func HandleSignalingSession(ctx context.Context, socket *websocket.Conn, peerConnection *webrtc.PeerConnection, channel *SessionChannel, logger *zap.SugaredLogger) {
 	logger = logger.Named("HandleSignalingSession")
 
 	for {
@@ -131,9 +213,80 @@ func HandleSignalingSession(ctx context.Context, socket *websocket.Conn, peerCon
 
 			handleIceCandidate(iceCandidate, peerConnection, logger)
 		default:
-			logger.Errorw("unknown message type received from peer", "message type", message.MsgType)
+			if channel == nil {
+				logger.Errorw("unknown message type received from peer", "message type", message.MsgType)
+				continue
+			}
+
+			reply := channel.OnMessage(ctx, message)
+			if reply == nil {
+				continue
+			}
+			if err := wsjson.Write(ctx, socket, *reply); err != nil {
+				logger.Error(fmt.Errorf("error sending reply to peer: %w", err))
+			}
+		}
+	}
+}
+
+func forwardOutgoingMessages(ctx context.Context, outgoing <-chan Message, send func(message Message) error, logger *zap.SugaredLogger) {
+	logger = logger.Named("forwardOutgoingMessages")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-outgoing:
+			if !ok {
+				return
+			}
+			if err := send(message); err != nil {
+				logger.Error(fmt.Errorf("error sending message to peer: %w", err))
+				return
+			}
+		}
+	}
+}
+
+// makeDataChannelHandler returns a handler that passes the messages of data channels opened by the peer to the
+// session channel
+func makeDataChannelHandler(ctx context.Context, channel *SessionChannel, logger *zap.SugaredLogger) func(dataChannel *webrtc.DataChannel) {
+	logger = logger.Named("DataChannelHandler")
+	return func(dataChannel *webrtc.DataChannel) {
+		logger.Debugw("peer opened data channel", "label", dataChannel.Label())
+		handleDataChannelMessages(ctx, dataChannel, channel, logger)
+	}
+}
+
+// handleDataChannelMessages passes the messages of a data channel to the session channel, replying over the same data
+// channel
+func handleDataChannelMessages(ctx context.Context, dataChannel *webrtc.DataChannel, channel *SessionChannel, logger *zap.SugaredLogger) {
+	logger = logger.Named("handleDataChannelMessages").With("label", dataChannel.Label())
+
+	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		message := Message{}
+		if err := json.Unmarshal(msg.Data, &message); err != nil {
+			logger.Errorw("could not parse data channel message", "err", err)
+			return
 		}
+
+		reply := channel.OnMessage(ctx, message)
+		if reply == nil {
+			return
+		}
+
+		if err := sendDataChannelMessage(dataChannel, *reply); err != nil {
+			logger.Errorw("could not send reply over data channel", "err", err)
+		}
+	})
+}
+
+func sendDataChannelMessage(dataChannel *webrtc.DataChannel, message Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
 	}
+
+	return dataChannel.SendText(string(data))
 }
 
 func makeSignalingStateChangeHandler(cancelSignaling context.CancelFunc, logger *zap.SugaredLogger) func(state webrtc.SignalingState) {
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// APIKey is a static key and the principal it authenticates as
+type APIKey struct {
+	Key       string
+	Principal Principal
+}
+
+// APIKeyAuthenticator accepts static API keys sent in the X-API-Key header, as an "ApiKey" authorization header or as
+// the api_key query parameter.
+type APIKeyAuthenticator struct {
+	keys []APIKey
+}
+
+func NewAPIKeyAuthenticator(keys []APIKey) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{keys}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		var err error
+		if key, err = bearerToken(r, "ApiKey", "api_key"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, apiKey := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey.Key)) == 1 {
+			principal := apiKey.Principal
+			return &principal, nil
+		}
+	}
+
+	return nil, invalidCredentials("unknown api key")
+}
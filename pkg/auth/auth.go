@@ -0,0 +1,168 @@
+// Package auth implements the authentication schemes accepted by the streamer and the middleware that enforces them.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request carries no credentials it understands
+	ErrNoCredentials = errors.New("no credentials found in request")
+	// ErrInvalidCredentials is returned by an Authenticator when the request's credentials were rejected
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated client of the server
+type Principal struct {
+	Subject string
+	// Streams this principal may open. A nil slice grants access to all streams.
+	Streams []int
+}
+
+// CanView reports whether the principal may open the stream with the given id
+func (p *Principal) CanView(streamId int) bool {
+	if p.Streams == nil {
+		return true
+	}
+
+	for _, id := range p.Streams {
+		if id == streamId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticator extracts and validates the credentials of a request
+type Authenticator interface {
+	// Authenticate returns the principal that issued the request. It returns ErrNoCredentials if the request does not
+	// contain credentials for this scheme, and an error wrapping ErrInvalidCredentials if they are not valid.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each of its authenticators in order, returning the first principal found
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return principal, nil
+	}
+
+	return nil, ErrNoCredentials
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx that carries the given principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal stored in ctx by the middleware, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Middleware returns a middleware that rejects requests that can't be authenticated with a 401 status code, and
+// stores the principal in the request context otherwise.
+func Middleware(authenticator Authenticator, logger *zap.SugaredLogger) func(next http.Handler) http.Handler {
+	logger = logger.Named("AuthMiddleware")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticator.Authenticate(r)
+			if err != nil {
+				logger.Infow("rejected unauthenticated request", "path", r.URL.Path, "reason", err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="camera_streamer"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// RequireStream returns a middleware that rejects requests with a 403 status code if the principal in their context
+// may not open the stream identified by streamId. Requests without a principal are let through, so that the
+// middleware does nothing when authentication is disabled.
+func RequireStream(streamId func(r *http.Request) (int, error), logger *zap.SugaredLogger) func(next http.Handler) http.Handler {
+	logger = logger.Named("RequireStream")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := FromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id, err := streamId(r)
+			if err != nil {
+				http.Error(w, "invalid camera id", http.StatusBadRequest)
+				return
+			}
+
+			if !principal.CanView(id) {
+				logger.Infow("rejected unauthorized stream request", "subject", principal.Subject, "stream id", id)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAllStreams returns a middleware that rejects requests with a 403 status code unless the principal in their
+// context may open every stream, for routes that aren't limited to a single stream. Like RequireStream, requests
+// without a principal are let through.
+func RequireAllStreams(logger *zap.SugaredLogger) func(next http.Handler) http.Handler {
+	logger = logger.Named("RequireAllStreams")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := FromContext(r.Context())
+			if ok && principal.Streams != nil {
+				logger.Infow("rejected request from principal limited to some streams", "subject", principal.Subject,
+					"path", r.URL.Path)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken returns the token in the request's authorization header with the given scheme, or the given query
+// parameter as a fallback, since browsers can't set headers when opening websockets.
+func bearerToken(r *http.Request, scheme string, queryParam string) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		prefix := scheme + " "
+		if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+			return strings.TrimSpace(header[len(prefix):]), nil
+		}
+	}
+
+	if token := r.URL.Query().Get(queryParam); token != "" {
+		return token, nil
+	}
+
+	return "", ErrNoCredentials
+}
+
+func invalidCredentials(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,29 @@
+package auth
+
+import "net/http"
+
+// ClientCertAuthenticator authenticates machine consumers by the common name of their verified TLS client certificate
+type ClientCertAuthenticator struct {
+	principals map[string]Principal
+}
+
+// NewClientCertAuthenticator creates an authenticator that accepts the given principals, keyed by common name
+func NewClientCertAuthenticator(principals map[string]Principal) *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{principals}
+}
+
+func (a *ClientCertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	// Chains are only present if the certificate was verified by the TLS server
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+
+	principal, ok := a.principals[commonName]
+	if !ok {
+		return nil, invalidCredentials("unknown client certificate '%s'", commonName)
+	}
+
+	return &principal, nil
+}
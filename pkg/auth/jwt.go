@@ -0,0 +1,402 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockSkew is the tolerance applied when validating the time based claims of a token
+const clockSkew = 30 * time.Second
+
+type JWTConfig struct {
+	// Issuer and Audience are only validated if not empty
+	Issuer   string
+	Audience string
+	// StreamsClaim is the name of the claim listing the ids of the streams the bearer may open. It may hold an array
+	// of ids or "*" to grant access to all streams. Tokens without it can't open any stream.
+	StreamsClaim string
+}
+
+// JWTAuthenticator validates JSON Web Tokens sent as bearer tokens or in the access_token query parameter
+type JWTAuthenticator struct {
+	config JWTConfig
+	// keys maps a key id to its verification key
+	keys map[string]verificationKey
+	// The secret and the key loaded from a key file have no id. They verify the tokens whose key id is unknown, the
+	// secret those signed with HMAC and the key the rest.
+	secret []byte
+	key    any
+}
+
+func NewJWTAuthenticator(config JWTConfig) *JWTAuthenticator {
+	if config.StreamsClaim == "" {
+		config.StreamsClaim = "streams"
+	}
+
+	return &JWTAuthenticator{config: config, keys: make(map[string]verificationKey)}
+}
+
+// verificationKey is a key of a key set, which may be restricted to its use and to a single algorithm
+type verificationKey struct {
+	key any
+	// Empty if the key set doesn't say
+	use string
+	alg string
+}
+
+// AddSecret registers a shared secret for HMAC signed tokens
+func (a *JWTAuthenticator) AddSecret(secret string) {
+	a.secret = []byte(secret)
+}
+
+// LoadKeyFile registers the PEM encoded public key or certificate in the given file
+func (a *JWTAuthenticator) LoadKeyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("no pem data found in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		key = certificate.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse key in %s: %w", path, err)
+	}
+
+	a.key = key
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// LoadJWKSFile registers all the keys in the JSON Web Key Set stored in the given file
+func (a *JWTAuthenticator) LoadJWKSFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return fmt.Errorf("could not parse key set in %s: %w", path, err)
+	}
+
+	for _, jwk := range keySet.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("could not parse key '%s' in %s: %w", jwk.Kid, path, err)
+		}
+		a.keys[jwk.Kid] = verificationKey{key: key, use: jwk.Use, alg: jwk.Alg}
+	}
+
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return decode(k.K)
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, err := bearerToken(r, "Bearer", "access_token")
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidCredentials("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalidCredentials("malformed token header")
+	}
+
+	var key any
+	keySetKey, ok := a.keys[header.Kid]
+	if ok {
+		if keySetKey.use != "" && keySetKey.use != "sig" {
+			return nil, invalidCredentials("key '%s' is not for signatures", header.Kid)
+		}
+		if keySetKey.alg != "" && keySetKey.alg != header.Alg {
+			return nil, invalidCredentials("key '%s' is not for %s signatures", header.Kid, header.Alg)
+		}
+		key = keySetKey.key
+	} else if strings.HasPrefix(header.Alg, "HS") {
+		key, ok = a.secret, a.secret != nil
+	} else {
+		key, ok = a.key, a.key != nil
+	}
+	if !ok {
+		return nil, invalidCredentials("unknown signing key '%s'", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidCredentials("malformed token signature")
+	}
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, invalidCredentials("%s", err)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidCredentials("malformed token claims")
+	}
+
+	return a.principalFromClaims(claims)
+}
+
+func (a *JWTAuthenticator) principalFromClaims(claims map[string]any) (*Principal, error) {
+	now := time.Now()
+
+	if exp, ok := claims["exp"].(float64); !ok {
+		return nil, invalidCredentials("token has no expiration time")
+	} else if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, invalidCredentials("token expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, invalidCredentials("token not valid yet")
+	}
+
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return nil, invalidCredentials("unexpected token issuer")
+	}
+
+	if a.config.Audience != "" && !hasAudience(claims["aud"], a.config.Audience) {
+		return nil, invalidCredentials("unexpected token audience")
+	}
+
+	principal := Principal{Streams: []int{}}
+	principal.Subject, _ = claims["sub"].(string)
+
+	switch streams := claims[a.config.StreamsClaim].(type) {
+	case string:
+		if streams == "*" {
+			principal.Streams = nil
+		}
+	case []any:
+		for _, stream := range streams {
+			switch stream := stream.(type) {
+			case float64:
+				principal.Streams = append(principal.Streams, int(stream))
+			case string:
+				if stream == "*" {
+					principal.Streams = nil
+					return &principal, nil
+				}
+				id, err := strconv.Atoi(stream)
+				if err != nil {
+					return nil, invalidCredentials("invalid stream id '%s' in token", stream)
+				}
+				principal.Streams = append(principal.Streams, id)
+			}
+		}
+	}
+
+	return &principal, nil
+}
+
+func hasAudience(claim any, audience string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == audience
+	case []any:
+		for _, aud := range claim {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func verifySignature(alg string, key any, signed []byte, signature []byte) error {
+	var hashFunc crypto.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		hashFunc = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		hashFunc = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hashFunc = crypto.SHA512
+	}
+
+	var digest []byte
+	if hashFunc != 0 {
+		hasher := hashFunc.New()
+		hasher.Write(signed)
+		digest = hasher.Sum(nil)
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "HS") && hashFunc != 0:
+		secret, ok := key.([]byte)
+		if !ok {
+			break
+		}
+		var mac []byte
+		switch hashFunc {
+		case crypto.SHA256:
+			mac = hmacSum(sha256.New, secret, signed)
+		case crypto.SHA384:
+			mac = hmacSum(sha512.New384, secret, signed)
+		case crypto.SHA512:
+			mac = hmacSum(sha512.New, secret, signed)
+		}
+		if !hmac.Equal(mac, signature) {
+			return fmt.Errorf("bad token signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "RS") && hashFunc != 0:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		return rsa.VerifyPKCS1v15(publicKey, hashFunc, digest, signature)
+	case strings.HasPrefix(alg, "PS") && hashFunc != 0:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		return rsa.VerifyPSS(publicKey, hashFunc, digest, signature, nil)
+	case strings.HasPrefix(alg, "ES") && hashFunc != 0:
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			break
+		}
+		// The signature is r and s, each padded to the size of the curve
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("bad token signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(publicKey, digest, r, s) {
+			return fmt.Errorf("bad token signature")
+		}
+		return nil
+	case alg == "EdDSA":
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			break
+		}
+		if !ed25519.Verify(publicKey, signed, signature) {
+			return fmt.Errorf("bad token signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported signing algorithm '%s' for key", alg)
+}
+
+func hmacSum(newHash func() hash.Hash, secret []byte, data []byte) []byte {
+	mac := hmac.New(newHash, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
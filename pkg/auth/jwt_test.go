@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+type testKeys struct {
+	rsa      *rsa.PrivateKey
+	ecdsa    *ecdsa.PrivateKey
+	ed25519  ed25519.PrivateKey
+	keyFile  string
+	jwksFile string
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{
+			{"kty": "EC", "kid": "ec", "use": "sig", "alg": "ES256", "crv": "P-256", "x": encode(ecdsaKey.X.Bytes()), "y": encode(ecdsaKey.Y.Bytes())},
+			{"kty": "EC", "kid": "ec-enc", "use": "enc", "crv": "P-256", "x": encode(ecdsaKey.X.Bytes()), "y": encode(ecdsaKey.Y.Bytes())},
+			{"kty": "EC", "kid": "ec-384", "alg": "ES384", "crv": "P-256", "x": encode(ecdsaKey.X.Bytes()), "y": encode(ecdsaKey.Y.Bytes())},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encode(ed25519Key.Public().(ed25519.PublicKey))},
+			{"kty": "oct", "kid": "oct", "k": encode([]byte("jwks-secret"))},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return testKeys{rsaKey, ecdsaKey, ed25519Key, keyFile, jwksFile}
+}
+
+// signToken builds a token with the given header and claims, signed by sign
+func signToken(t *testing.T, header map[string]any, claims map[string]any, sign func(signed []byte) []byte) string {
+	t.Helper()
+
+	encodeSegment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encodeSegment(header) + "." + encodeSegment(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func signHS256(secret []byte) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func signRS256(key *rsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			panic(err)
+		}
+		return signature
+	}
+}
+
+func signES256(key *ecdsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			panic(err)
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	}
+}
+
+// resized changes the length of the signatures made by sign, cutting them or padding them with zeros at the start
+func resized(sign func([]byte) []byte, length int) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		signature := sign(signed)
+		if length < len(signature) {
+			return signature[:length]
+		}
+		return append(make([]byte, length-len(signature)), signature...)
+	}
+}
+
+func signEdDSA(key ed25519.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		return ed25519.Sign(key, signed)
+	}
+}
+
+func unsigned([]byte) []byte {
+	return nil
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	keys := newTestKeys(t)
+
+	authenticator := NewJWTAuthenticator(JWTConfig{Issuer: "issuer", Audience: "streamer"})
+	authenticator.AddSecret(testSecret)
+	if err := authenticator.LoadKeyFile(keys.keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := authenticator.LoadJWKSFile(keys.jwksFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The public key an attacker could sign HMAC tokens with, hoping it's used as the secret
+	publicKeyPEM, err := os.ReadFile(keys.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	validClaims := func(overrides map[string]any) map[string]any {
+		claims := map[string]any{
+			"sub":     "viewer",
+			"iss":     "issuer",
+			"aud":     "streamer",
+			"exp":     now.Add(time.Hour).Unix(),
+			"streams": []any{1, 2},
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		header  map[string]any
+		claims  map[string]any
+		sign    func([]byte) []byte
+		streams []int
+		wantErr bool
+	}{
+		{name: "HS256 secret", header: map[string]any{"alg": "HS256"}, claims: validClaims(nil), sign: signHS256([]byte(testSecret)), streams: []int{1, 2}},
+		{name: "RS256 key file", header: map[string]any{"alg": "RS256"}, claims: validClaims(nil), sign: signRS256(keys.rsa), streams: []int{1, 2}},
+		{name: "ES256 jwks", header: map[string]any{"alg": "ES256", "kid": "ec"}, claims: validClaims(nil), sign: signES256(keys.ecdsa), streams: []int{1, 2}},
+		{name: "ES256 truncated signature", header: map[string]any{"alg": "ES256", "kid": "ec"}, claims: validClaims(nil), sign: resized(signES256(keys.ecdsa), 63), wantErr: true},
+		{name: "ES256 padded signature", header: map[string]any{"alg": "ES256", "kid": "ec"}, claims: validClaims(nil), sign: resized(signES256(keys.ecdsa), 66), wantErr: true},
+		{name: "ES256 with kid of enc key", header: map[string]any{"alg": "ES256", "kid": "ec-enc"}, claims: validClaims(nil), sign: signES256(keys.ecdsa), wantErr: true},
+		{name: "ES256 with kid of ES384 key", header: map[string]any{"alg": "ES256", "kid": "ec-384"}, claims: validClaims(nil), sign: signES256(keys.ecdsa), wantErr: true},
+		{name: "EdDSA jwks", header: map[string]any{"alg": "EdDSA", "kid": "ed"}, claims: validClaims(nil), sign: signEdDSA(keys.ed25519), streams: []int{1, 2}},
+		{name: "HS256 jwks", header: map[string]any{"alg": "HS256", "kid": "oct"}, claims: validClaims(nil), sign: signHS256([]byte("jwks-secret")), streams: []int{1, 2}},
+		{name: "wrong secret", header: map[string]any{"alg": "HS256"}, claims: validClaims(nil), sign: signHS256([]byte("other")), wantErr: true},
+		{name: "secret of another kid", header: map[string]any{"alg": "HS256", "kid": "oct"}, claims: validClaims(nil), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "alg none", header: map[string]any{"alg": "none"}, claims: validClaims(nil), sign: unsigned, wantErr: true},
+		{name: "HS256 with public key as secret", header: map[string]any{"alg": "HS256"}, claims: validClaims(nil), sign: signHS256(publicKeyPEM), wantErr: true},
+		{name: "HS256 with kid of ec key", header: map[string]any{"alg": "HS256", "kid": "ec"}, claims: validClaims(nil), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "RS256 with kid of oct key", header: map[string]any{"alg": "RS256", "kid": "oct"}, claims: validClaims(nil), sign: signRS256(keys.rsa), wantErr: true},
+		{name: "unknown kid falls back to secret", header: map[string]any{"alg": "HS256", "kid": "unknown"}, claims: validClaims(nil), sign: signHS256([]byte(testSecret)), streams: []int{1, 2}},
+		{name: "unknown kid falls back to key file", header: map[string]any{"alg": "RS256", "kid": "unknown"}, claims: validClaims(nil), sign: signRS256(keys.rsa), streams: []int{1, 2}},
+		{name: "expired", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"exp": now.Add(-time.Hour).Unix()}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "expired within skew", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"exp": now.Add(-clockSkew + 5*time.Second).Unix()}), sign: signHS256([]byte(testSecret)), streams: []int{1, 2}},
+		{name: "expired past skew", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"exp": now.Add(-clockSkew - 5*time.Second).Unix()}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "no expiration", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"exp": nil}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "not valid yet", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"nbf": now.Add(time.Hour).Unix()}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "not valid yet within skew", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"nbf": now.Add(clockSkew - 5*time.Second).Unix()}), sign: signHS256([]byte(testSecret)), streams: []int{1, 2}},
+		{name: "not valid yet past skew", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"nbf": now.Add(clockSkew + 5*time.Second).Unix()}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "wrong audience", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"aud": "other"}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "audience list", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"aud": []any{"other", "streamer"}}), sign: signHS256([]byte(testSecret)), streams: []int{1, 2}},
+		{name: "audience list without it", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"aud": []any{"other"}}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "no audience", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"aud": nil}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "wrong issuer", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"iss": "other"}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "all streams", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"streams": "*"}), sign: signHS256([]byte(testSecret)), streams: nil},
+		{name: "all streams in list", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"streams": []any{"3", "*"}}), sign: signHS256([]byte(testSecret)), streams: nil},
+		{name: "string stream ids", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"streams": []any{"3", 4}}), sign: signHS256([]byte(testSecret)), streams: []int{3, 4}},
+		{name: "invalid stream id", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"streams": []any{"camera"}}), sign: signHS256([]byte(testSecret)), wantErr: true},
+		{name: "other string grants nothing", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"streams": "1"}), sign: signHS256([]byte(testSecret)), streams: []int{}},
+		{name: "no streams claim", header: map[string]any{"alg": "HS256"}, claims: validClaims(map[string]any{"streams": nil}), sign: signHS256([]byte(testSecret)), streams: []int{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/1/", nil)
+			request.Header.Set("Authorization", "Bearer "+signToken(t, test.header, test.claims, test.sign))
+
+			principal, err := authenticator.Authenticate(request)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("expected invalid credentials, got principal %+v and error %v", principal, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if principal.Subject != "viewer" {
+				t.Errorf("expected subject viewer, got %s", principal.Subject)
+			}
+			if !reflect.DeepEqual(principal.Streams, test.streams) {
+				t.Errorf("expected streams %#v, got %#v", test.streams, principal.Streams)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticateQueryParameter(t *testing.T) {
+	authenticator := NewJWTAuthenticator(JWTConfig{})
+	authenticator.AddSecret(testSecret)
+
+	token := signToken(t, map[string]any{"alg": "HS256"}, map[string]any{"exp": time.Now().Add(time.Hour).Unix()},
+		signHS256([]byte(testSecret)))
+
+	principal, err := authenticator.Authenticate(httptest.NewRequest("GET", "/1/?access_token="+token, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if principal.Streams == nil || len(principal.Streams) != 0 {
+		t.Errorf("expected no streams, got %#v", principal.Streams)
+	}
+
+	if _, err := authenticator.Authenticate(httptest.NewRequest("GET", "/1/", nil)); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected no credentials, got %v", err)
+	}
+}
+
+func TestJWTAuthenticateMalformed(t *testing.T) {
+	authenticator := NewJWTAuthenticator(JWTConfig{})
+	authenticator.AddSecret(testSecret)
+
+	for _, token := range []string{"abc", "a.b", "a.b.c", "!!.e30.", "e30.e30.!!"} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+
+		if _, err := authenticator.Authenticate(request); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("token %q: expected invalid credentials, got %v", token, err)
+		}
+	}
+}
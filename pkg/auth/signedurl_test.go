@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner(testSecret, time.Hour)
+	expires := time.Now().Add(time.Minute)
+	values := signer.Sign("/1/", expires)
+
+	tampered := signer.Sign("/1/", expires)
+	tampered.Set("expires", strconv.FormatInt(expires.Add(time.Hour).Unix(), 10))
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{name: "valid", url: "/1/?" + values.Encode()},
+		{name: "other path", url: "/2/?" + values.Encode(), wantErr: ErrInvalidCredentials},
+		{name: "tampered expiration", url: "/1/?" + tampered.Encode(), wantErr: ErrInvalidCredentials},
+		{name: "expired", url: "/1/?" + signer.Sign("/1/", time.Now().Add(-time.Second)).Encode(), wantErr: ErrInvalidCredentials},
+		{name: "other secret", url: "/1/?" + NewURLSigner("other", time.Hour).Sign("/1/", expires).Encode(), wantErr: ErrInvalidCredentials},
+		{name: "malformed expiration", url: "/1/?expires=soon&signature=" + values.Get("signature"), wantErr: ErrInvalidCredentials},
+		{name: "no signature", url: "/1/?expires=" + values.Get("expires"), wantErr: ErrNoCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := signer.Authenticate(httptest.NewRequest("GET", test.url, nil))
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got principal %+v and error %v", test.wantErr, principal, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if principal.Streams != nil {
+				t.Errorf("expected signed urls to be limited by path only, got streams %v", principal.Streams)
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ import "C"
 import (
 	"fmt"
 	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -46,6 +47,24 @@ func (a *AppSink) PullSample() (*Sample, error) {
 	return &sample, nil
 }
 
+// TryPullSample waits up to timeout for a sample, returning false if none arrived or the appsink has reached EOS
+func (a *AppSink) TryPullSample(timeout time.Duration) (*Sample, bool) {
+	gstSample := C.gst_app_sink_try_pull_sample((*C.GstAppSink)(unsafe.Pointer(a.gstElement)), C.GstClockTime(timeout.Nanoseconds()))
+	if gstSample == nil {
+		return nil, false
+	}
+
+	sample := wrapSample(gstSample)
+	enableGarbageCollection(&sample)
+
+	return &sample, true
+}
+
+// IsEOS returns whether the appsink has reached EOS and has no samples left to pull
+func (a *AppSink) IsEOS() bool {
+	return C.gst_app_sink_is_eos((*C.GstAppSink)(unsafe.Pointer(a.gstElement))) != 0
+}
+
 type NewSampleCallback func(newSample *Sample)
 
 var (
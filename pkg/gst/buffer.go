@@ -6,6 +6,7 @@ package gst
 */
 import "C"
 import (
+	"fmt"
 	"time"
 	"unsafe"
 )
@@ -20,6 +21,7 @@ func wrapGstBuffer(gstBuffer *C.GstBuffer) Buffer {
 	}
 }
 
+// Bytes returns a copy of the buffer's contents, which unlike the memory given by Map may be kept
 func (b *Buffer) Bytes() []byte {
 	var (
 		bufferCopy C.gpointer
@@ -30,10 +32,114 @@ func (b *Buffer) Bytes() []byte {
 	return C.GoBytes(unsafe.Pointer(bufferCopy), C.int(copySize))
 }
 
+// MapInfo is the memory of a buffer mapped for reading. Data is the buffer's own memory, so it must not be modified, and
+// it's only valid until Unmap is called.
+type MapInfo struct {
+	Data []byte
+
+	buffer *Buffer
+	info   C.GstMapInfo
+}
+
+// Map gives access to the buffer's memory without copying it. The buffer is kept alive until it's unmapped.
+func (b *Buffer) Map() (*MapInfo, error) {
+	mapInfo := &MapInfo{buffer: b}
+	if C.gst_buffer_map(b.gstBuffer, &mapInfo.info, C.GST_MAP_READ) == 0 {
+		return nil, fmt.Errorf("could not map buffer")
+	}
+	b.ref()
+
+	mapInfo.Data = unsafe.Slice((*byte)(unsafe.Pointer(mapInfo.info.data)), int(mapInfo.info.size))
+	return mapInfo, nil
+}
+
+func (m *MapInfo) Unmap() {
+	m.Data = nil
+	C.gst_buffer_unmap(m.buffer.gstBuffer, &m.info)
+	m.buffer.unref()
+}
+
+// WithBytes maps the buffer for the duration of f, which must not keep or modify the bytes it's given
+func (b *Buffer) WithBytes(f func(data []byte)) error {
+	mapInfo, err := b.Map()
+	if err != nil {
+		return err
+	}
+	defer mapInfo.Unmap()
+
+	f(mapInfo.Data)
+	return nil
+}
+
 func (b *Buffer) Duration() time.Duration {
 	return time.Duration(b.gstBuffer.duration)
 }
 
+// PTS returns when the buffer should be presented, relative to its segment, if it's known
+func (b *Buffer) PTS() (time.Duration, bool) {
+	return wrapClockTime(b.gstBuffer.pts)
+}
+
+// DTS returns when the buffer should be decoded, relative to its segment, if it's known. It's only different from the
+// PTS for formats whose frames are decoded out of order.
+func (b *Buffer) DTS() (time.Duration, bool) {
+	return wrapClockTime(b.gstBuffer.dts)
+}
+
+// Offset returns the position of the buffer in its stream, in a unit that depends on the format, like the frame number
+// for raw video or the byte offset for files
+func (b *Buffer) Offset() uint64 {
+	return uint64(b.gstBuffer.offset)
+}
+
+// OffsetEnd returns the position in the stream of the end of the buffer, in the same unit as Offset
+func (b *Buffer) OffsetEnd() uint64 {
+	return uint64(b.gstBuffer.offset_end)
+}
+
+type BufferFlags uint
+
+const (
+	BUFFER_FLAG_LIVE BufferFlags = 1 << (iota + 4)
+	BUFFER_FLAG_DECODE_ONLY
+	// The buffer isn't contiguous to the previous one, like after a seek or a packet loss
+	BUFFER_FLAG_DISCONT
+	BUFFER_FLAG_RESYNC
+	BUFFER_FLAG_CORRUPTED
+	BUFFER_FLAG_MARKER
+	// The buffer holds stream headers, like codec configuration
+	BUFFER_FLAG_HEADER
+	BUFFER_FLAG_GAP
+	BUFFER_FLAG_DROPPABLE
+	// The buffer can't be decoded on its own, so it's not a key frame
+	BUFFER_FLAG_DELTA_UNIT
+	BUFFER_FLAG_TAG_MEMORY
+	BUFFER_FLAG_SYNC_AFTER
+	BUFFER_FLAG_NON_DROPPABLE
+)
+
+func (b *Buffer) Flags() BufferFlags {
+	return BufferFlags(b.gstBuffer.mini_object.flags)
+}
+
+// HasFlags returns whether the buffer has all the given flags set
+func (b *Buffer) HasFlags(flags BufferFlags) bool {
+	return b.Flags()&flags == flags
+}
+
+// IsKeyFrame returns whether the buffer can be decoded on its own
+func (b *Buffer) IsKeyFrame() bool {
+	return !b.HasFlags(BUFFER_FLAG_DELTA_UNIT)
+}
+
+// wrapClockTime converts a clock time to a duration, returning false if it's GST_CLOCK_TIME_NONE
+func wrapClockTime(clockTime C.GstClockTime) (time.Duration, bool) {
+	if clockTime == C.GST_CLOCK_TIME_NONE {
+		return 0, false
+	}
+	return time.Duration(clockTime), true
+}
+
 func (b *Buffer) ref() {
 	C.gst_buffer_ref(b.gstBuffer)
 }
@@ -41,3 +147,7 @@ func (b *Buffer) ref() {
 func (b *Buffer) unref() {
 	C.gst_buffer_unref(b.gstBuffer)
 }
+
+func (b *Buffer) Size() int {
+	return int(C.gst_buffer_get_size(b.gstBuffer))
+}
@@ -0,0 +1,239 @@
+package gst
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	testFrames    = 10
+	testWidth     = 320
+	testHeight    = 240
+	testFormat    = "I420"
+	testFrameRate = 30
+)
+
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+// runTestPipeline plays testFrames frames of videotestsrc through the given elements into an appsink, returning every
+// sample it received
+func runTestPipeline(t *testing.T, elements ...elementCastable) []*Sample {
+	t.Helper()
+
+	pipeline, err := NewGstPipeline("test-pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewVideoTestSrc("test-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewCapsFilter("test-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewAppSink("test-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caps, err := NewCapsFromString("video/x-raw,format=I420,width=320,height=240,framerate=30/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetProperty("num-buffers", testFrames); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.SetProperty("caps", caps); err != nil {
+		t.Fatal(err)
+	}
+	// Frames are pulled as fast as they're produced
+	if err := sink.SetProperty("sync", false); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := append([]elementCastable{src, filter}, elements...)
+	chain = append(chain, sink)
+	for _, element := range chain {
+		pipeline.AddElement(element)
+	}
+	for i := 1; i < len(chain); i++ {
+		if err := LinkElements(chain[i-1], chain[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pipeline.SetState(PLAYING); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := pipeline.SetState(NULL); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var samples []*Sample
+	for {
+		sample, err := sink.PullSample()
+		if err != nil {
+			break
+		}
+		samples = append(samples, sample)
+	}
+
+	if len(samples) != testFrames {
+		t.Fatalf("expected %d samples, got %d", testFrames, len(samples))
+	}
+	return samples
+}
+
+func TestBufferTimestamps(t *testing.T) {
+	samples := runTestPipeline(t)
+
+	frameDuration := time.Second / testFrameRate
+	var previous time.Duration
+	for i, sample := range samples {
+		buffer := sample.Buffer()
+
+		pts, ok := buffer.PTS()
+		if !ok {
+			t.Fatalf("frame %d has no pts", i)
+		}
+		if i > 0 && pts <= previous {
+			t.Errorf("frame %d pts %s does not follow %s", i, pts, previous)
+		}
+		previous = pts
+
+		if duration := buffer.Duration(); duration < frameDuration-time.Millisecond || duration > frameDuration+time.Millisecond {
+			t.Errorf("frame %d lasts %s, expected %s", i, duration, frameDuration)
+		}
+
+		// videotestsrc numbers its frames with the offsets
+		if offset := buffer.Offset(); offset != uint64(i) {
+			t.Errorf("frame %d has offset %d", i, offset)
+		}
+		if offsetEnd := buffer.OffsetEnd(); offsetEnd != uint64(i+1) {
+			t.Errorf("frame %d has end offset %d", i, offsetEnd)
+		}
+
+		if expected := testWidth * testHeight * 3 / 2; buffer.Size() != expected {
+			t.Errorf("frame %d has %d bytes, expected %d", i, buffer.Size(), expected)
+		}
+	}
+}
+
+func TestBufferMap(t *testing.T) {
+	samples := runTestPipeline(t)
+	buffer := samples[0].Buffer()
+
+	mapInfo, err := buffer.Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapInfo.Data) != buffer.Size() {
+		t.Errorf("mapped %d bytes of a %d byte buffer", len(mapInfo.Data), buffer.Size())
+	}
+	mapped := append([]byte(nil), mapInfo.Data...)
+	mapInfo.Unmap()
+
+	if copied := buffer.Bytes(); string(copied) != string(mapped) {
+		t.Error("mapped data differs from the buffer's bytes")
+	}
+}
+
+func TestSampleCaps(t *testing.T) {
+	samples := runTestPipeline(t)
+
+	caps, ok := samples[0].Caps()
+	if !ok {
+		t.Fatal("sample has no caps")
+	}
+	structure, err := caps.Format(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := structure.Name(); name != "video/x-raw" {
+		t.Errorf("expected video/x-raw caps, got %s", name)
+	}
+	if width, err := structure.QueryIntProperty("width"); err != nil || width != testWidth {
+		t.Errorf("expected width %d, got %d (%v)", testWidth, width, err)
+	}
+	if height, err := structure.QueryIntProperty("height"); err != nil || height != testHeight {
+		t.Errorf("expected height %d, got %d (%v)", testHeight, height, err)
+	}
+	if format, err := structure.QueryStringProperty("format"); err != nil || format != testFormat {
+		t.Errorf("expected format %s, got %s (%v)", testFormat, format, err)
+	}
+}
+
+func TestSampleSegment(t *testing.T) {
+	samples := runTestPipeline(t)
+
+	for i, sample := range samples {
+		segment, ok := sample.Segment()
+		if !ok {
+			t.Fatalf("frame %d has no segment", i)
+		}
+		if !segment.IsTime() {
+			t.Fatalf("frame %d segment is not in time format", i)
+		}
+		if rate := segment.Rate(); rate != 1 {
+			t.Errorf("frame %d segment has rate %f", i, rate)
+		}
+
+		pts, _ := sample.Buffer().PTS()
+		// The segment starts with the stream, so running time and stream time match the timestamps
+		if runningTime, ok := segment.ToRunningTime(pts); !ok || runningTime != pts-segment.Start()+segment.Base() {
+			t.Errorf("frame %d at %s has running time %s", i, pts, runningTime)
+		}
+		if streamTime, ok := segment.ToStreamTime(pts); !ok || streamTime != pts-segment.Start()+segment.Time() {
+			t.Errorf("frame %d at %s has stream time %s", i, pts, streamTime)
+		}
+	}
+
+	// Timestamps before the segment have no running time
+	segment, _ := samples[0].Segment()
+	if segment.Start() > 0 {
+		if _, ok := segment.ToRunningTime(segment.Start() - 1); ok {
+			t.Error("expected a timestamp before the segment to have no running time")
+		}
+	}
+}
+
+func TestBufferKeyFrames(t *testing.T) {
+	encoder, err := NewVp8Enc("test-enc")
+	if err != nil {
+		t.Skipf("vp8enc is not available: %v", err)
+	}
+	if err := encoder.SetProperty("keyframe-max-dist", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.SetProperty("deadline", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	samples := runTestPipeline(t, encoder)
+
+	keyFrames := 0
+	for _, sample := range samples {
+		if sample.Buffer().IsKeyFrame() {
+			keyFrames++
+		}
+	}
+
+	if !samples[0].Buffer().IsKeyFrame() {
+		t.Error("expected the first frame to be a key frame")
+	}
+	if !samples[1].Buffer().HasFlags(BUFFER_FLAG_DELTA_UNIT) {
+		t.Error("expected the second frame to be a delta unit")
+	}
+	// Key frames are at most 5 frames apart, and delta frames fill the gaps
+	if keyFrames < testFrames/5 || keyFrames == testFrames {
+		t.Errorf("expected between %d and %d key frames, got %d", testFrames/5, testFrames-1, keyFrames)
+	}
+}
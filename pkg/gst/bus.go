@@ -7,10 +7,15 @@ package gst
 */
 import "C"
 import (
+	"context"
 	"fmt"
+	"time"
 	"unsafe"
 )
 
+// busWatchTimeout is how long a watch waits for a message before checking whether it was canceled
+const busWatchTimeout = 100 * time.Millisecond
+
 type Bus struct {
 	gstBus *C.GstBus
 	Object
@@ -48,3 +53,31 @@ func (b *Bus) PopMessage() (*Message, error) {
 
 	return &message, nil
 }
+
+// Watch delivers the messages matching filter as soon as they're posted, until ctx is canceled and the channel is
+// closed. Only one watch should run on a bus at a time, since every message is only delivered once.
+func (b *Bus) Watch(ctx context.Context, filter MessageType) <-chan *Message {
+	messages := make(chan *Message)
+
+	go func() {
+		defer close(messages)
+
+		for ctx.Err() == nil {
+			gstMessage := C.gst_bus_timed_pop_filtered(b.gstBus, C.GstClockTime(busWatchTimeout.Nanoseconds()), C.GstMessageType(filter))
+			if gstMessage == nil {
+				continue
+			}
+
+			message := wrapGstMessage(gstMessage)
+			enableGarbageCollection(&message)
+
+			select {
+			case messages <- &message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return messages
+}
@@ -7,3 +7,40 @@ void connectSignalHandler(char * signalName, GstElement *element, void *callback
 void callSignalByName(GstElement *element, const char *signalName, void *returnLocation) {
     g_signal_emit_by_name((GObject*)element, signalName, returnLocation);
 }
+
+// the probe being added by this thread, and whether it was called before gst_pad_add_probe returned
+static __thread long addingProbe = -1;
+static __thread gboolean addingProbeCalled = FALSE;
+
+static GstPadProbeReturn padProbe(GstPad *pad, GstPadProbeInfo *info, gpointer index) {
+    if ((long) index == addingProbe) {
+        addingProbeCalled = TRUE;
+    }
+    return padProbeHandler(pad, info, (long) index);
+}
+
+static void padProbeDestroyed(gpointer index) {
+    padProbeDestroyHandler((long) index);
+}
+
+gulong addPadProbe(GstPad *pad, GstPadProbeType mask, long index, gboolean *called) {
+    // probes may add other probes while they're called, so the outer probe is restored afterwards
+    long previousProbe = addingProbe;
+    gboolean previousCalled = addingProbeCalled;
+    addingProbe = index;
+    addingProbeCalled = FALSE;
+
+    gulong id = gst_pad_add_probe(pad, mask, padProbe, (gpointer) index, padProbeDestroyed);
+
+    *called = addingProbeCalled;
+    addingProbe = previousProbe;
+    addingProbeCalled = previousCalled;
+    return id;
+}
+
+GstBuffer *makeProbeBufferWritable(GstPadProbeInfo *info) {
+    // the probe owns the buffer it passes on, so a copy replaces the original
+    GstBuffer *buffer = gst_buffer_make_writable(GST_PAD_PROBE_INFO_BUFFER(info));
+    GST_PAD_PROBE_INFO_DATA(info) = buffer;
+    return buffer;
+}
@@ -3,9 +3,16 @@
 
 
 extern void overrunHandler(GstElement* object, long index);
+extern GstPadProbeReturn padProbeHandler(GstPad *pad, GstPadProbeInfo *info, long index);
+extern void padProbeDestroyHandler(long index);
 
 void connectSignalHandler(char *signalName, GstElement *element, void *callback, long index);
 
 void callSignalByName(GstElement *element, const char *signalName, void *returnLocation);
 
+// called is set if the probe was called before it was added, like idle probes of idle pads
+gulong addPadProbe(GstPad *pad, GstPadProbeType mask, long index, gboolean *called);
+
+GstBuffer *makeProbeBufferWritable(GstPadProbeInfo *info);
+
 #endif // CALLBACKS_h
@@ -0,0 +1,18 @@
+package gst
+
+type ClockOverlay struct {
+	Element
+}
+
+func NewClockOverlay(name string) (*ClockOverlay, error) {
+	element, err := makeElement(name, "clockoverlay")
+
+	if err != nil {
+		return nil, err
+	}
+
+	clockOverlay := ClockOverlay{element}
+	enableGarbageCollection(&clockOverlay)
+
+	return &clockOverlay, nil
+}
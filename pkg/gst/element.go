@@ -121,8 +121,40 @@ const (
 	PLAYING
 	READY
 	PAUSED
+	// VOID_PENDING is the pending state of elements that aren't changing state
+	VOID_PENDING
 )
 
+func wrapGstState(state C.GstState) ElementState {
+	switch state {
+	case C.GST_STATE_NULL:
+		return NULL
+	case C.GST_STATE_READY:
+		return READY
+	case C.GST_STATE_PAUSED:
+		return PAUSED
+	case C.GST_STATE_PLAYING:
+		return PLAYING
+	default:
+		return VOID_PENDING
+	}
+}
+
+func (s ElementState) String() string {
+	switch s {
+	case NULL:
+		return "NULL"
+	case READY:
+		return "READY"
+	case PAUSED:
+		return "PAUSED"
+	case PLAYING:
+		return "PLAYING"
+	default:
+		return "VOID_PENDING"
+	}
+}
+
 func (e *Element) SetState(state ElementState) error {
 	switch state {
 	case PLAYING:
@@ -155,6 +187,14 @@ func (e *Element) AddPad(pad *Pad) error {
 	return nil
 }
 
+// SyncStateWithParent changes the element's state to that of the bin containing it
+func (e *Element) SyncStateWithParent() error {
+	if C.gst_element_sync_state_with_parent(e.gstElement) == 0 {
+		return fmt.Errorf("could not sync state of %s with its parent", e.Name())
+	}
+	return nil
+}
+
 type PadAddedCallback func(pad *Pad)
 
 var (
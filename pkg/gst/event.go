@@ -0,0 +1,108 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0 gstreamer-video-1.0
+
+#include <gst/gst.h>
+#include <gst/video/video.h>
+*/
+import "C"
+import "fmt"
+
+type EventType int
+
+const (
+	EVENT_CAPS EventType = C.GST_EVENT_CAPS
+)
+
+type Event struct {
+	event *C.GstEvent
+	MiniObject
+}
+
+func wrapEvent(gstEvent *C.GstEvent) Event {
+	return Event{
+		gstEvent,
+		wrapGstMiniObject(&gstEvent.mini_object),
+	}
+}
+
+// NewUpstreamForceKeyUnitEvent creates an event that asks upstream encoders to produce a key frame as soon as
+// possible, optionally resending the stream headers along with it
+func NewUpstreamForceKeyUnitEvent(allHeaders bool, count uint) *Event {
+	var cAllHeaders C.gboolean
+	if allHeaders {
+		cAllHeaders = 1
+	}
+
+	gstEvent := C.gst_video_event_new_upstream_force_key_unit(C.GST_CLOCK_TIME_NONE, cAllHeaders, C.guint(count))
+
+	event := wrapEvent(gstEvent)
+	enableGarbageCollection(&event)
+
+	return &event
+}
+
+// NewFlushStartEvent creates an event that makes the pads it goes through discard data until a flush stop event
+func NewFlushStartEvent() *Event {
+	event := wrapEvent(C.gst_event_new_flush_start())
+	enableGarbageCollection(&event)
+
+	return &event
+}
+
+// NewFlushStopEvent creates an event that ends a flush, which clears the end of stream of the pads it goes through.
+// Their running time is reset to 0 if resetTime is set.
+func NewFlushStopEvent(resetTime bool) *Event {
+	var cResetTime C.gboolean
+	if resetTime {
+		cResetTime = 1
+	}
+
+	event := wrapEvent(C.gst_event_new_flush_stop(cResetTime))
+	enableGarbageCollection(&event)
+
+	return &event
+}
+
+func (e *Event) Type() EventType {
+	return EventType(e.event._type)
+}
+
+// ParseCaps returns the caps carried by a caps event
+func (e *Event) ParseCaps() (*Caps, bool) {
+	if e.Type() != EVENT_CAPS {
+		return nil, false
+	}
+
+	var gstCaps *C.GstCaps
+	C.gst_event_parse_caps(e.event, &gstCaps)
+
+	// The caps belong to the event, so they're referenced to outlive it
+	caps := wrapGstCaps(gstCaps)
+	caps.ref()
+	enableGarbageCollection(&caps)
+
+	return &caps, true
+}
+
+// SendEvent sends an event to the element, which passes it upstream or downstream depending on the event
+func (e *Element) SendEvent(event *Event) error {
+	// the element takes ownership of the event, while the wrapper still releases its own reference
+	event.ref()
+	if C.gst_element_send_event(e.gstElement, event.event) == 0 {
+		return fmt.Errorf("element %s did not handle event", e.Name())
+	}
+
+	return nil
+}
+
+// SendEvent sends an event to the pad, which passes it to its peer or handles it depending on its direction
+func (p *Pad) SendEvent(event *Event) error {
+	event.ref()
+	if C.gst_pad_send_event(p.gstPad, event.event) == 0 {
+		return fmt.Errorf("pad did not handle event")
+	}
+
+	return nil
+}
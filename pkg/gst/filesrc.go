@@ -0,0 +1,22 @@
+package gst
+
+type FileSrc struct {
+	Element
+}
+
+func NewFileSrc(name string, location string) (*FileSrc, error) {
+	element, err := makeElement(name, "filesrc")
+
+	if err != nil {
+		return nil, err
+	}
+
+	fileSrc := FileSrc{element}
+	enableGarbageCollection(&fileSrc)
+
+	if err := fileSrc.SetProperty("location", location); err != nil {
+		return nil, err
+	}
+
+	return &fileSrc, nil
+}
@@ -8,6 +8,7 @@ package gst
 import "C"
 import (
 	"fmt"
+	"unsafe"
 )
 
 type Structure struct {
@@ -35,4 +36,74 @@ func (s *Structure) QueryStringProperty(propertyName string) (string, error) {
 	return C.GoString(value), nil
 }
 
-// TODO implement getter for format properties
+func (s *Structure) HasField(fieldName string) bool {
+	cFieldName := C.CString(fieldName)
+	defer C.free(unsafe.Pointer(cFieldName))
+
+	return C.gst_structure_has_field(s.gstStructure, cFieldName) != 0
+}
+
+// FieldNames returns the names of every field of the structure, in order
+func (s *Structure) FieldNames() []string {
+	names := make([]string, int(C.gst_structure_n_fields(s.gstStructure)))
+	for i := range names {
+		names[i] = C.GoString(C.gst_structure_nth_field_name(s.gstStructure, C.guint(i)))
+	}
+	return names
+}
+
+func (s *Structure) QueryIntProperty(propertyName string) (int, error) {
+	cPropertyName := C.CString(propertyName)
+	defer C.free(unsafe.Pointer(cPropertyName))
+
+	var value C.gint
+	if C.gst_structure_get_int(s.gstStructure, cPropertyName, &value) == 0 {
+		return 0, fmt.Errorf("int property '%s' not found", propertyName)
+	}
+
+	return int(value), nil
+}
+
+func (s *Structure) QueryUintProperty(propertyName string) (uint, error) {
+	cPropertyName := C.CString(propertyName)
+	defer C.free(unsafe.Pointer(cPropertyName))
+
+	var value C.guint
+	if C.gst_structure_get_uint(s.gstStructure, cPropertyName, &value) == 0 {
+		return 0, fmt.Errorf("uint property '%s' not found", propertyName)
+	}
+
+	return uint(value), nil
+}
+
+func (s *Structure) QueryUint64Property(propertyName string) (uint64, error) {
+	cPropertyName := C.CString(propertyName)
+	defer C.free(unsafe.Pointer(cPropertyName))
+
+	var value C.guint64
+	if C.gst_structure_get_uint64(s.gstStructure, cPropertyName, &value) == 0 {
+		return 0, fmt.Errorf("uint64 property '%s' not found", propertyName)
+	}
+
+	return uint64(value), nil
+}
+
+func (s *Structure) QueryBoolProperty(propertyName string) (bool, error) {
+	cPropertyName := C.CString(propertyName)
+	defer C.free(unsafe.Pointer(cPropertyName))
+
+	var value C.gboolean
+	if C.gst_structure_get_boolean(s.gstStructure, cPropertyName, &value) == 0 {
+		return false, fmt.Errorf("boolean property '%s' not found", propertyName)
+	}
+
+	return value != 0, nil
+}
+
+// String serializes the structure with its name and every field, as in gst-launch pipelines
+func (s *Structure) String() string {
+	cString := C.gst_structure_to_string(s.gstStructure)
+	defer C.g_free(C.gpointer(unsafe.Pointer(cString)))
+
+	return C.GoString(cString)
+}
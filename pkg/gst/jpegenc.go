@@ -0,0 +1,18 @@
+package gst
+
+type JpegEnc struct {
+	Element
+}
+
+func NewJpegEnc(name string) (*JpegEnc, error) {
+	element, err := makeElement(name, "jpegenc")
+
+	if err != nil {
+		return nil, err
+	}
+
+	jpegEnc := JpegEnc{element}
+	enableGarbageCollection(&jpegEnc)
+
+	return &jpegEnc, nil
+}
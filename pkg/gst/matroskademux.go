@@ -0,0 +1,18 @@
+package gst
+
+type MatroskaDemux struct {
+	Element
+}
+
+func NewMatroskaDemux(name string) (*MatroskaDemux, error) {
+	element, err := makeElement(name, "matroskademux")
+
+	if err != nil {
+		return nil, err
+	}
+
+	matroskaDemux := MatroskaDemux{element}
+	enableGarbageCollection(&matroskaDemux)
+
+	return &matroskaDemux, nil
+}
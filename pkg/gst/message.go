@@ -8,6 +8,7 @@ package gst
 import "C"
 import (
 	"fmt"
+	"unsafe"
 )
 
 type MessageType int
@@ -72,18 +73,146 @@ func wrapGstMessage(gstMessage *C.GstMessage) Message {
 	}
 }
 
-// TODO improve parsing
-func (m *Message) ParseAsError() (string, error) {
+// SourceName returns the name of the object that posted the message
+func (m *Message) SourceName() string {
+	if m.gstMessage.src == nil {
+		return ""
+	}
+
+	source := wrapGstObject(m.gstMessage.src)
+	return source.Name()
+}
+
+// MessageError is the error carried by ERROR, WARNING and INFO messages
+type MessageError struct {
+	// Name of the element that posted the message
+	Source string
+	// Domain and Code identify the kind of error, as in GLib's GError
+	Domain  string
+	Code    int
+	Message string
+	// Additional details for debugging, which may be empty
+	Debug string
+}
+
+func (e *MessageError) Error() string {
+	if e.Debug == "" {
+		return fmt.Sprintf("%s: %s", e.Source, e.Message)
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.Source, e.Message, e.Debug)
+}
+
+func (m *Message) ParseError() (*MessageError, error) {
 	if m.Type() != ERROR {
-		return "", fmt.Errorf("message is not an error")
+		return nil, fmt.Errorf("message is not an error")
 	}
 
 	var (
 		gError      *C.GError
-		errorString *C.char
+		debugString *C.gchar
 	)
+	C.gst_message_parse_error(m.gstMessage, &gError, &debugString)
+
+	return m.wrapMessageError(gError, debugString), nil
+}
+
+func (m *Message) ParseWarning() (*MessageError, error) {
+	if m.Type() != WARNING {
+		return nil, fmt.Errorf("message is not a warning")
+	}
+
+	var (
+		gError      *C.GError
+		debugString *C.gchar
+	)
+	C.gst_message_parse_warning(m.gstMessage, &gError, &debugString)
+
+	return m.wrapMessageError(gError, debugString), nil
+}
+
+// wrapMessageError copies a parsed error, freeing it along with its debug string
+func (m *Message) wrapMessageError(gError *C.GError, debugString *C.gchar) *MessageError {
+	defer C.g_error_free(gError)
+	defer C.g_free(C.gpointer(unsafe.Pointer(debugString)))
+
+	messageError := &MessageError{
+		Source:  m.SourceName(),
+		Domain:  C.GoString(C.g_quark_to_string(gError.domain)),
+		Code:    int(gError.code),
+		Message: C.GoString(gError.message),
+	}
+	if debugString != nil {
+		messageError.Debug = C.GoString(debugString)
+	}
+
+	return messageError
+}
 
-	C.gst_message_parse_error(m.gstMessage, &gError, &errorString)
+// ParseStateChanged returns the state the posting element was in, the one it changed to and the one it's changing to
+// next, which is VOID_PENDING once it has reached its target state
+func (m *Message) ParseStateChanged() (oldState ElementState, newState ElementState, pending ElementState, err error) {
+	if m.Type() != STATE_CHANGED {
+		return 0, 0, 0, fmt.Errorf("message is not a state change")
+	}
+
+	var gstOld, gstNew, gstPending C.GstState
+	C.gst_message_parse_state_changed(m.gstMessage, &gstOld, &gstNew, &gstPending)
+
+	return wrapGstState(gstOld), wrapGstState(gstNew), wrapGstState(gstPending), nil
+}
+
+// ParseBuffering returns how full the posting element's buffer is, in percent
+func (m *Message) ParseBuffering() (int, error) {
+	if m.Type() != BUFFERING {
+		return 0, fmt.Errorf("message is not a buffering message")
+	}
+
+	var percent C.gint
+	C.gst_message_parse_buffering(m.gstMessage, &percent)
+
+	return int(percent), nil
+}
+
+// ParseQOS returns how many buffers the posting element has processed and dropped since it last started
+func (m *Message) ParseQOS() (processed uint64, dropped uint64, err error) {
+	if m.Type() != QUALITY_OF_SERVICE {
+		return 0, 0, fmt.Errorf("message is not a quality of service message")
+	}
+
+	var (
+		format       C.GstFormat
+		gstProcessed C.guint64
+		gstDropped   C.guint64
+	)
+	C.gst_message_parse_qos_stats(m.gstMessage, &format, &gstProcessed, &gstDropped)
+
+	return uint64(gstProcessed), uint64(gstDropped), nil
+}
+
+// ParseTag returns the tags found by the posting element
+func (m *Message) ParseTag() (*TagList, error) {
+	if m.Type() != TAG {
+		return nil, fmt.Errorf("message is not a tag message")
+	}
+
+	var gstTagList *C.GstTagList
+	C.gst_message_parse_tag(m.gstMessage, &gstTagList)
+
+	// parsing transfers ownership of the list
+	tagList := wrapGstTagList(gstTagList)
+	enableGarbageCollection(&tagList)
+
+	return &tagList, nil
+}
+
+// Structure returns the fields of the message, like those of STREAM_STATUS, LATENCY and ELEMENT messages. It belongs to
+// the message, so it's only valid while the message is.
+func (m *Message) Structure() (*Structure, bool) {
+	gstStructure := C.gst_message_get_structure(m.gstMessage)
+	if gstStructure == nil {
+		return nil, false
+	}
 
-	return C.GoString(errorString), nil
+	structure := wrapGstStructure((*C.GstStructure)(unsafe.Pointer(gstStructure)))
+	return &structure, true
 }
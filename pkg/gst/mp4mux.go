@@ -0,0 +1,18 @@
+package gst
+
+type Mp4Mux struct {
+	Element
+}
+
+func NewMp4Mux(name string) (*Mp4Mux, error) {
+	element, err := makeElement(name, "mp4mux")
+
+	if err != nil {
+		return nil, err
+	}
+
+	mp4Mux := Mp4Mux{element}
+	enableGarbageCollection(&mp4Mux)
+
+	return &mp4Mux, nil
+}
@@ -4,10 +4,12 @@ package gst
 #cgo pkg-config: gstreamer-1.0
 
 #include <gst/gst.h>
+#include "callbacks.h"
 */
 import "C"
 import (
 	"fmt"
+	"sync"
 	"unsafe"
 )
 
@@ -81,3 +83,196 @@ func UnlinkPads(first *Pad, second *Pad) error {
 	return nil
 
 }
+
+func (p *Pad) IsLinked() bool {
+	return C.gst_pad_is_linked(p.gstPad) != 0
+}
+
+type PadProbeType uint
+
+const (
+	// PAD_PROBE_TYPE_IDLE probes are called once no data is flowing through the pad
+	PAD_PROBE_TYPE_IDLE PadProbeType = 1 << 0
+	// PAD_PROBE_TYPE_BLOCK probes hold the data back until they're removed
+	PAD_PROBE_TYPE_BLOCK            PadProbeType = 1 << 1
+	PAD_PROBE_TYPE_BUFFER           PadProbeType = 1 << 4
+	PAD_PROBE_TYPE_BUFFER_LIST      PadProbeType = 1 << 5
+	PAD_PROBE_TYPE_EVENT_DOWNSTREAM PadProbeType = 1 << 6
+	PAD_PROBE_TYPE_EVENT_UPSTREAM   PadProbeType = 1 << 7
+	PAD_PROBE_TYPE_EVENT_FLUSH      PadProbeType = 1 << 8
+	PAD_PROBE_TYPE_QUERY_DOWNSTREAM PadProbeType = 1 << 9
+	PAD_PROBE_TYPE_QUERY_UPSTREAM   PadProbeType = 1 << 10
+	PAD_PROBE_TYPE_PUSH             PadProbeType = 1 << 12
+	PAD_PROBE_TYPE_PULL             PadProbeType = 1 << 13
+	PAD_PROBE_TYPE_BLOCKING                      = PAD_PROBE_TYPE_IDLE | PAD_PROBE_TYPE_BLOCK
+	PAD_PROBE_TYPE_DATA_DOWNSTREAM               = PAD_PROBE_TYPE_BUFFER | PAD_PROBE_TYPE_BUFFER_LIST | PAD_PROBE_TYPE_EVENT_DOWNSTREAM
+	PAD_PROBE_TYPE_DATA_UPSTREAM                 = PAD_PROBE_TYPE_EVENT_UPSTREAM
+	PAD_PROBE_TYPE_DATA_BOTH                     = PAD_PROBE_TYPE_DATA_DOWNSTREAM | PAD_PROBE_TYPE_DATA_UPSTREAM
+	PAD_PROBE_TYPE_BLOCK_DOWNSTREAM              = PAD_PROBE_TYPE_BLOCK | PAD_PROBE_TYPE_DATA_DOWNSTREAM
+	PAD_PROBE_TYPE_BLOCK_UPSTREAM                = PAD_PROBE_TYPE_BLOCK | PAD_PROBE_TYPE_DATA_UPSTREAM
+	PAD_PROBE_TYPE_EVENT_BOTH                    = PAD_PROBE_TYPE_EVENT_DOWNSTREAM | PAD_PROBE_TYPE_EVENT_UPSTREAM
+	PAD_PROBE_TYPE_QUERY_BOTH                    = PAD_PROBE_TYPE_QUERY_DOWNSTREAM | PAD_PROBE_TYPE_QUERY_UPSTREAM
+	PAD_PROBE_TYPE_ALL_BOTH                      = PAD_PROBE_TYPE_DATA_BOTH | PAD_PROBE_TYPE_QUERY_BOTH
+	PAD_PROBE_TYPE_SCHEDULING                    = PAD_PROBE_TYPE_PUSH | PAD_PROBE_TYPE_PULL
+)
+
+type PadProbeReturn int
+
+const (
+	// PAD_PROBE_DROP drops the data, or unblocks a blocking probe without passing it on
+	PAD_PROBE_DROP PadProbeReturn = iota
+	// PAD_PROBE_OK passes the data on, and keeps blocking probes blocked
+	PAD_PROBE_OK
+	// PAD_PROBE_REMOVE removes the probe and passes the data on
+	PAD_PROBE_REMOVE
+	// PAD_PROBE_PASS passes the data on without unblocking blocking probes
+	PAD_PROBE_PASS
+)
+
+type ProbeID uint64
+
+// PadProbeInfo is the data a probe was called for, which is only valid during the call
+type PadProbeInfo struct {
+	info *C.GstPadProbeInfo
+}
+
+func (i *PadProbeInfo) Type() PadProbeType {
+	return PadProbeType(i.info._type)
+}
+
+func (i *PadProbeInfo) ID() ProbeID {
+	return ProbeID(i.info.id)
+}
+
+// Buffer returns the buffer flowing through the pad, which must not be modified
+func (i *PadProbeInfo) Buffer() (*Buffer, bool) {
+	if i.Type()&PAD_PROBE_TYPE_BUFFER == 0 {
+		return nil, false
+	}
+
+	// The probe only borrows the buffer, so it's not released by the wrapper
+	buffer := wrapGstBuffer((*C.GstBuffer)(i.info.data))
+	return &buffer, true
+}
+
+// WritableBuffer is like Buffer, but the buffer may be modified in place. Buffers shared with other elements are
+// copied first.
+func (i *PadProbeInfo) WritableBuffer() (*Buffer, bool) {
+	if i.Type()&PAD_PROBE_TYPE_BUFFER == 0 {
+		return nil, false
+	}
+
+	buffer := wrapGstBuffer(C.makeProbeBufferWritable(i.info))
+	return &buffer, true
+}
+
+func (i *PadProbeInfo) Event() (*Event, bool) {
+	if i.Type()&PAD_PROBE_TYPE_EVENT_BOTH == 0 {
+		return nil, false
+	}
+
+	event := wrapEvent((*C.GstEvent)(i.info.data))
+	return &event, true
+}
+
+func (i *PadProbeInfo) Query() (*Query, bool) {
+	if i.Type()&PAD_PROBE_TYPE_QUERY_BOTH == 0 {
+		return nil, false
+	}
+
+	query := wrapQuery((*C.GstQuery)(i.info.data))
+	return &query, true
+}
+
+// PadProbeCallback is called from the thread using the pad, or from the one adding an idle probe if the pad is
+// already idle
+type PadProbeCallback func(pad *Pad, info *PadProbeInfo) PadProbeReturn
+
+var (
+	padProbeIndex     int64 = 0
+	padProbeCallbacks       = make(map[int64]PadProbeCallback)
+	padProbeLock      sync.RWMutex
+)
+
+//export padProbeHandler
+func padProbeHandler(gstPad *C.GstPad, info *C.GstPadProbeInfo, callbackID C.long) C.GstPadProbeReturn {
+	// Probes run in the streaming threads of every pipeline, so they must not wait on each other
+	padProbeLock.RLock()
+	callback, ok := padProbeCallbacks[int64(callbackID)]
+	padProbeLock.RUnlock()
+
+	if !ok {
+		panic("callback not found")
+	}
+
+	// The probe only borrows the pad, so it's not released by the wrapper
+	pad := wrapPad(gstPad)
+	return C.GstPadProbeReturn(callback(&pad, &PadProbeInfo{info}))
+}
+
+//export padProbeDestroyHandler
+func padProbeDestroyHandler(callbackID C.long) {
+	padProbeLock.Lock()
+	defer padProbeLock.Unlock()
+
+	delete(padProbeCallbacks, int64(callbackID))
+}
+
+// AddProbe calls callback with the data of the types in mask that goes through the pad, until it's removed with
+// RemoveProbe or the callback returns PAD_PROBE_REMOVE. Idle probes added to an idle pad are called before AddProbe
+// returns, and if they removed themselves their id is 0.
+func (p *Pad) AddProbe(mask PadProbeType, callback PadProbeCallback) (ProbeID, error) {
+	padProbeLock.Lock()
+	index := padProbeIndex
+	padProbeCallbacks[index] = callback
+	padProbeIndex++
+	padProbeLock.Unlock()
+
+	var called C.gboolean
+	id := C.addPadProbe(p.gstPad, C.GstPadProbeType(mask), C.long(index), &called)
+	if id == 0 && called == 0 {
+		// The probe was never added, so it won't be destroyed either
+		padProbeLock.Lock()
+		delete(padProbeCallbacks, index)
+		padProbeLock.Unlock()
+
+		return 0, fmt.Errorf("could not add probe to pad %s", p.Name())
+	}
+
+	return ProbeID(id), nil
+}
+
+func (p *Pad) RemoveProbe(id ProbeID) {
+	C.gst_pad_remove_probe(p.gstPad, C.gulong(id))
+}
+
+// BufferCallback is called with every buffer that flows through a pad, and returns whether the buffer should be passed
+// on or dropped
+type BufferCallback func(pad *Pad, buffer *Buffer) bool
+
+func (p *Pad) addBufferProbe(callback BufferCallback, writable bool) error {
+	_, err := p.AddProbe(PAD_PROBE_TYPE_BUFFER, func(pad *Pad, info *PadProbeInfo) PadProbeReturn {
+		buffer, _ := info.Buffer()
+		if writable {
+			buffer, _ = info.WritableBuffer()
+		}
+
+		if callback(pad, buffer) {
+			return PAD_PROBE_OK
+		}
+		return PAD_PROBE_DROP
+	})
+	return err
+}
+
+// OnBuffer calls callback with every buffer that flows through the pad, from the thread pushing it. The buffer must
+// not be modified.
+func (p *Pad) OnBuffer(callback BufferCallback) error {
+	return p.addBufferProbe(callback, false)
+}
+
+// OnWritableBuffer is like OnBuffer, but the callback may modify the buffer in place. Buffers shared with other
+// elements are copied first.
+func (p *Pad) OnWritableBuffer(callback BufferCallback) error {
+	return p.addBufferProbe(callback, true)
+}
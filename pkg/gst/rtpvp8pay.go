@@ -0,0 +1,18 @@
+package gst
+
+type RtpVp8Pay struct {
+	Element
+}
+
+func NewRtpVp8Pay(name string) (*RtpVp8Pay, error) {
+	element, err := makeElement(name, "rtpvp8pay")
+
+	if err != nil {
+		return nil, err
+	}
+
+	rtpVp8Pay := RtpVp8Pay{element}
+	enableGarbageCollection(&rtpVp8Pay)
+
+	return &rtpVp8Pay, nil
+}
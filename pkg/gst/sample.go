@@ -23,6 +23,31 @@ func (s *Sample) Buffer() *Buffer {
 	return &buffer
 }
 
+// Caps returns the format of the sample's buffer
+func (s *Sample) Caps() (*Caps, bool) {
+	gstCaps := C.gst_sample_get_caps(s.gstSample)
+	if gstCaps == nil {
+		return nil, false
+	}
+
+	// the sample keeps ownership, so the caps need their own reference
+	caps := wrapGstCaps(gstCaps)
+	caps.ref()
+	enableGarbageCollection(&caps)
+
+	return &caps, true
+}
+
+// Segment returns a copy of the segment the sample's buffer belongs to
+func (s *Sample) Segment() (*Segment, bool) {
+	gstSegment := C.gst_sample_get_segment(s.gstSample)
+	if gstSegment == nil {
+		return nil, false
+	}
+
+	return &Segment{*gstSegment}, true
+}
+
 func (s *Sample) ref() {
 	C.gst_sample_ref(s.gstSample)
 }
@@ -0,0 +1,82 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+
+#include <gst/gst.h>
+*/
+import "C"
+import (
+	"fmt"
+	"time"
+)
+
+type SeekFlags int
+
+const (
+	SEEK_FLAG_NONE  SeekFlags = 0
+	SEEK_FLAG_FLUSH SeekFlags = 1 << (iota - 1)
+	SEEK_FLAG_ACCURATE
+	SEEK_FLAG_KEY_UNIT
+	SEEK_FLAG_SEGMENT
+	SEEK_FLAG_TRICKMODE
+	SEEK_FLAG_SNAP_BEFORE
+	SEEK_FLAG_SNAP_AFTER
+	SEEK_FLAG_TRICKMODE_KEY_UNITS
+	SEEK_FLAG_TRICKMODE_NO_AUDIO
+	SEEK_FLAG_TRICKMODE_FORWARD_PREDICTED
+	SEEK_FLAG_INSTANT_RATE_CHANGE
+	SEEK_FLAG_SNAP_NEAREST = SEEK_FLAG_SNAP_BEFORE | SEEK_FLAG_SNAP_AFTER
+)
+
+// Seek changes the playback rate and position of the element, playing from start until stop. A negative stop plays
+// until the end of the stream. Negative rates play backwards.
+func (e *Element) Seek(rate float64, flags SeekFlags, start time.Duration, stop time.Duration) error {
+	if rate == 0 {
+		return fmt.Errorf("seek rate can't be zero")
+	}
+
+	stopType := C.GstSeekType(C.GST_SEEK_TYPE_SET)
+	if stop < 0 {
+		stopType = C.GST_SEEK_TYPE_NONE
+		stop = 0
+	}
+
+	result := C.gst_element_seek(e.gstElement, C.gdouble(rate), C.GST_FORMAT_TIME, C.GstSeekFlags(flags),
+		C.GST_SEEK_TYPE_SET, C.gint64(start.Nanoseconds()), stopType, C.gint64(stop.Nanoseconds()))
+	if result == 0 {
+		return fmt.Errorf("could not seek element %s", e.Name())
+	}
+
+	return nil
+}
+
+// SeekSimple moves the playback position of the element, keeping its current rate
+func (e *Element) SeekSimple(flags SeekFlags, position time.Duration) error {
+	result := C.gst_element_seek_simple(e.gstElement, C.GST_FORMAT_TIME, C.GstSeekFlags(flags), C.gint64(position.Nanoseconds()))
+	if result == 0 {
+		return fmt.Errorf("could not seek element %s", e.Name())
+	}
+
+	return nil
+}
+
+// QueryPosition returns the current playback position of the element
+func (e *Element) QueryPosition() (time.Duration, error) {
+	var position C.gint64
+	if C.gst_element_query_position(e.gstElement, C.GST_FORMAT_TIME, &position) == 0 {
+		return 0, fmt.Errorf("could not query position of element %s", e.Name())
+	}
+
+	return time.Duration(position), nil
+}
+
+// QueryDuration returns the total duration of the element's stream
+func (e *Element) QueryDuration() (time.Duration, error) {
+	var duration C.gint64
+	if C.gst_element_query_duration(e.gstElement, C.GST_FORMAT_TIME, &duration) == 0 {
+		return 0, fmt.Errorf("could not query duration of element %s", e.Name())
+	}
+
+	return time.Duration(duration), nil
+}
@@ -0,0 +1,63 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+#include <gst/gst.h>
+*/
+import "C"
+import (
+	"time"
+)
+
+// Segment describes the part of a stream being played, mapping the timestamps of its buffers to running time, which
+// is synchronized with the pipeline clock, and to stream time, which is the position within the stream
+type Segment struct {
+	segment C.GstSegment
+}
+
+// IsTime returns whether the segment is in time format, which the rest of its methods assume
+func (s *Segment) IsTime() bool {
+	return s.segment.format == C.GST_FORMAT_TIME
+}
+
+func (s *Segment) Rate() float64 {
+	return float64(s.segment.rate)
+}
+
+// AppliedRate returns the rate that upstream elements have already applied to the stream
+func (s *Segment) AppliedRate() float64 {
+	return float64(s.segment.applied_rate)
+}
+
+func (s *Segment) Start() time.Duration {
+	return time.Duration(s.segment.start)
+}
+
+// Stop returns where the segment ends, if it's known
+func (s *Segment) Stop() (time.Duration, bool) {
+	return wrapClockTime(C.GstClockTime(s.segment.stop))
+}
+
+// Time returns the stream time of the segment's start
+func (s *Segment) Time() time.Duration {
+	return time.Duration(s.segment.time)
+}
+
+// Base returns the running time of the segment's start, which adds up the duration of the previous segments
+func (s *Segment) Base() time.Duration {
+	return time.Duration(s.segment.base)
+}
+
+func (s *Segment) Position() time.Duration {
+	return time.Duration(s.segment.position)
+}
+
+// ToRunningTime converts a buffer timestamp to running time, returning false if it's outside the segment
+func (s *Segment) ToRunningTime(timestamp time.Duration) (time.Duration, bool) {
+	return wrapClockTime(C.GstClockTime(C.gst_segment_to_running_time(&s.segment, C.GST_FORMAT_TIME, C.guint64(timestamp))))
+}
+
+// ToStreamTime converts a buffer timestamp to stream time, returning false if it's outside the segment
+func (s *Segment) ToStreamTime(timestamp time.Duration) (time.Duration, bool) {
+	return wrapClockTime(C.GstClockTime(C.gst_segment_to_stream_time(&s.segment, C.GST_FORMAT_TIME, C.guint64(timestamp))))
+}
@@ -0,0 +1,66 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+
+#include <gst/gst.h>
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// TagList holds metadata about a stream, like its codec or bitrate, by tag name
+type TagList struct {
+	gstTagList *C.GstTagList
+	MiniObject
+}
+
+func wrapGstTagList(gstTagList *C.GstTagList) TagList {
+	return TagList{
+		gstTagList,
+		wrapGstMiniObject(&gstTagList.mini_object),
+	}
+}
+
+// Tags returns the name of every tag in the list
+func (t *TagList) Tags() []string {
+	tags := make([]string, int(C.gst_tag_list_n_tags(t.gstTagList)))
+	for i := range tags {
+		tags[i] = C.GoString(C.gst_tag_list_nth_tag_name(t.gstTagList, C.guint(i)))
+	}
+	return tags
+}
+
+func (t *TagList) GetString(tag string) (string, error) {
+	cTag := C.CString(tag)
+	defer C.free(unsafe.Pointer(cTag))
+
+	var value *C.gchar
+	if C.gst_tag_list_get_string(t.gstTagList, cTag, &value) == 0 {
+		return "", fmt.Errorf("string tag '%s' not found", tag)
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(value)))
+
+	return C.GoString(value), nil
+}
+
+func (t *TagList) GetUint(tag string) (uint, error) {
+	cTag := C.CString(tag)
+	defer C.free(unsafe.Pointer(cTag))
+
+	var value C.guint
+	if C.gst_tag_list_get_uint(t.gstTagList, cTag, &value) == 0 {
+		return 0, fmt.Errorf("uint tag '%s' not found", tag)
+	}
+
+	return uint(value), nil
+}
+
+func (t *TagList) String() string {
+	cString := C.gst_tag_list_to_string(t.gstTagList)
+	defer C.g_free(C.gpointer(unsafe.Pointer(cString)))
+
+	return C.GoString(cString)
+}
@@ -0,0 +1,23 @@
+package gst
+
+type Valve struct {
+	Element
+}
+
+func NewValve(name string) (*Valve, error) {
+	element, err := makeElement(name, "valve")
+
+	if err != nil {
+		return nil, err
+	}
+
+	valve := Valve{element}
+	enableGarbageCollection(&valve)
+
+	return &valve, nil
+}
+
+// SetOpen lets buffers through the valve if open is true, dropping them otherwise
+func (v *Valve) SetOpen(open bool) error {
+	return v.SetProperty("drop", !open)
+}
@@ -0,0 +1,18 @@
+package gst
+
+type VideoConvert struct {
+	Element
+}
+
+func NewVideoConvert(name string) (*VideoConvert, error) {
+	element, err := makeElement(name, "videoconvert")
+
+	if err != nil {
+		return nil, err
+	}
+
+	videoConvert := VideoConvert{element}
+	enableGarbageCollection(&videoConvert)
+
+	return &videoConvert, nil
+}
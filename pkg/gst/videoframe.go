@@ -0,0 +1,111 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0 gstreamer-video-1.0
+
+#include <gst/gst.h>
+#include <gst/video/video.h>
+
+static guint8 *framePlaneData(GstVideoFrame *frame, guint plane) {
+	return GST_VIDEO_FRAME_PLANE_DATA(frame, plane);
+}
+
+static gint framePlaneStride(GstVideoFrame *frame, guint plane) {
+	return GST_VIDEO_FRAME_PLANE_STRIDE(frame, plane);
+}
+
+static gint frameComponentWidth(GstVideoFrame *frame, guint component) {
+	return GST_VIDEO_FRAME_COMP_WIDTH(frame, component);
+}
+
+static gint frameComponentHeight(GstVideoFrame *frame, guint component) {
+	return GST_VIDEO_FRAME_COMP_HEIGHT(frame, component);
+}
+
+static guint frameNPlanes(GstVideoFrame *frame) {
+	return GST_VIDEO_FRAME_N_PLANES(frame);
+}
+
+static const gchar *frameFormat(GstVideoFrame *frame) {
+	return gst_video_format_to_string(GST_VIDEO_FRAME_FORMAT(frame));
+}
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// VideoFrame is a raw video buffer mapped into memory, laid out as described by its caps
+type VideoFrame struct {
+	frame C.GstVideoFrame
+}
+
+// VideoPlane is a plane of a mapped video frame. Every row starts Stride bytes after the previous one.
+type VideoPlane struct {
+	Data   []byte
+	Stride int
+	Width  int
+	Height int
+}
+
+// VideoInfo describes the layout of the raw video frames with some caps
+type VideoInfo struct {
+	info C.GstVideoInfo
+}
+
+func NewVideoInfoFromCaps(caps *Caps) (*VideoInfo, error) {
+	info := &VideoInfo{}
+	if C.gst_video_info_from_caps(&info.info, caps.gstCaps) == 0 {
+		return nil, fmt.Errorf("caps do not describe raw video")
+	}
+
+	return info, nil
+}
+
+// MapVideoFrame maps a raw video buffer laid out as described by info for reading and writing. The frame must be
+// unmapped once done with it, and the buffer must be writable.
+func MapVideoFrame(buffer *Buffer, info *VideoInfo) (*VideoFrame, error) {
+	frame := &VideoFrame{}
+	if C.gst_video_frame_map(&frame.frame, &info.info, buffer.gstBuffer, C.GST_MAP_READ|C.GST_MAP_WRITE) == 0 {
+		return nil, fmt.Errorf("could not map video frame")
+	}
+
+	return frame, nil
+}
+
+func (f *VideoFrame) Unmap() {
+	C.gst_video_frame_unmap(&f.frame)
+}
+
+func (f *VideoFrame) Format() string {
+	return C.GoString(C.frameFormat(&f.frame))
+}
+
+func (f *VideoFrame) Width() int {
+	return int(f.frame.info.width)
+}
+
+func (f *VideoFrame) Height() int {
+	return int(f.frame.info.height)
+}
+
+func (f *VideoFrame) NPlanes() int {
+	return int(C.frameNPlanes(&f.frame))
+}
+
+// Plane returns a plane of the frame, which is only valid until the frame is unmapped. Its size is that of the
+// component with the same index, so it's only meaningful for planar formats like I420.
+func (f *VideoFrame) Plane(index int) VideoPlane {
+	stride := int(C.framePlaneStride(&f.frame, C.guint(index)))
+	width := int(C.frameComponentWidth(&f.frame, C.guint(index)))
+	height := int(C.frameComponentHeight(&f.frame, C.guint(index)))
+	data := unsafe.Pointer(C.framePlaneData(&f.frame, C.guint(index)))
+
+	return VideoPlane{
+		Data:   unsafe.Slice((*byte)(data), stride*(height-1)+width),
+		Stride: stride,
+		Width:  width,
+		Height: height,
+	}
+}
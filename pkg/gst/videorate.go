@@ -0,0 +1,18 @@
+package gst
+
+type VideoRate struct {
+	Element
+}
+
+func NewVideoRate(name string) (*VideoRate, error) {
+	element, err := makeElement(name, "videorate")
+
+	if err != nil {
+		return nil, err
+	}
+
+	videoRate := VideoRate{element}
+	enableGarbageCollection(&videoRate)
+
+	return &videoRate, nil
+}
@@ -0,0 +1,246 @@
+package hls
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// box is an ISO base media file format box, with its payload excluding the header
+type box struct {
+	boxType string
+	payload []byte
+}
+
+// nextBox reads the box at the start of data, returning the number of bytes it takes. It returns zero if data does
+// not contain a whole box yet.
+func nextBox(data []byte) (box, int, error) {
+	if len(data) < 8 {
+		return box{}, 0, nil
+	}
+
+	size := uint64(binary.BigEndian.Uint32(data[0:4]))
+	boxType := string(data[4:8])
+	headerSize := uint64(8)
+
+	switch size {
+	case 0:
+		return box{}, 0, fmt.Errorf("unbounded '%s' box in live stream", boxType)
+	case 1:
+		if len(data) < 16 {
+			return box{}, 0, nil
+		}
+		size = binary.BigEndian.Uint64(data[8:16])
+		headerSize = 16
+	}
+
+	if size < headerSize {
+		return box{}, 0, fmt.Errorf("invalid size %d for '%s' box", size, boxType)
+	}
+
+	if uint64(len(data)) < size {
+		return box{}, 0, nil
+	}
+
+	return box{boxType, data[headerSize:size]}, int(size), nil
+}
+
+// childBoxes returns all the boxes contained in a payload
+func childBoxes(payload []byte) ([]box, error) {
+	var boxes []box
+	for len(payload) > 0 {
+		child, size, err := nextBox(payload)
+		if err != nil {
+			return nil, err
+		} else if size == 0 {
+			return nil, fmt.Errorf("truncated box")
+		}
+		boxes = append(boxes, child)
+		payload = payload[size:]
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []box, boxType string) (box, bool) {
+	for _, b := range boxes {
+		if b.boxType == boxType {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// findPath descends through nested boxes following the given box types
+func findPath(payload []byte, path ...string) (box, error) {
+	var current box
+	for _, boxType := range path {
+		children, err := childBoxes(payload)
+		if err != nil {
+			return box{}, err
+		}
+
+		var ok bool
+		if current, ok = findBox(children, boxType); !ok {
+			return box{}, fmt.Errorf("missing '%s' box", boxType)
+		}
+		payload = current.payload
+	}
+	return current, nil
+}
+
+// parseTimescale returns the timescale of the first track in a moov box
+func parseTimescale(moov box) (uint32, error) {
+	mdhd, err := findPath(moov.payload, "trak", "mdia", "mdhd")
+	if err != nil {
+		return 0, err
+	}
+
+	// version 1 uses 64 bit creation and modification times
+	if len(mdhd.payload) < 4 {
+		return 0, fmt.Errorf("truncated mdhd box")
+	}
+	offset := 12
+	if mdhd.payload[0] == 1 {
+		offset = 20
+	}
+	if len(mdhd.payload) < offset+4 {
+		return 0, fmt.Errorf("truncated mdhd box")
+	}
+
+	return binary.BigEndian.Uint32(mdhd.payload[offset : offset+4]), nil
+}
+
+const (
+	tfhdBaseDataOffset         = 0x000001
+	tfhdSampleDescriptionIndex = 0x000002
+	tfhdDefaultSampleDuration  = 0x000008
+	tfhdDefaultSampleSize      = 0x000010
+	tfhdDefaultSampleFlags     = 0x000020
+
+	trunDataOffset       = 0x000001
+	trunFirstSampleFlags = 0x000004
+	trunSampleDuration   = 0x000100
+	trunSampleSize       = 0x000200
+	trunSampleFlags      = 0x000400
+	trunCompositionTime  = 0x000800
+
+	sampleIsNonSync = 0x00010000
+)
+
+// parseFragment returns the duration in timescale units of the fragment described by a moof box, and whether it
+// starts with a sync sample.
+func parseFragment(moof box) (duration uint64, independent bool, err error) {
+	traf, err := findPath(moof.payload, "traf")
+	if err != nil {
+		return 0, false, err
+	}
+	children, err := childBoxes(traf.payload)
+	if err != nil {
+		return 0, false, err
+	}
+
+	tfhd, ok := findBox(children, "tfhd")
+	if !ok {
+		return 0, false, fmt.Errorf("missing 'tfhd' box")
+	}
+	reader := fullBoxReader{data: tfhd.payload}
+	flags := reader.flags()
+	reader.skip(4) // track id
+	if flags&tfhdBaseDataOffset != 0 {
+		reader.skip(8)
+	}
+	if flags&tfhdSampleDescriptionIndex != 0 {
+		reader.skip(4)
+	}
+	var defaultDuration, defaultFlags uint32
+	if flags&tfhdDefaultSampleDuration != 0 {
+		defaultDuration = reader.uint32()
+	}
+	if flags&tfhdDefaultSampleSize != 0 {
+		reader.skip(4)
+	}
+	if flags&tfhdDefaultSampleFlags != 0 {
+		defaultFlags = reader.uint32()
+	}
+	if reader.err != nil {
+		return 0, false, reader.err
+	}
+
+	first := true
+	for _, child := range children {
+		if child.boxType != "trun" {
+			continue
+		}
+
+		reader := fullBoxReader{data: child.payload}
+		flags := reader.flags()
+		sampleCount := reader.uint32()
+		if flags&trunDataOffset != 0 {
+			reader.skip(4)
+		}
+		firstSampleFlags := defaultFlags
+		if flags&trunFirstSampleFlags != 0 {
+			firstSampleFlags = reader.uint32()
+		}
+
+		for i := uint32(0); i < sampleCount && reader.err == nil; i++ {
+			sampleDuration := defaultDuration
+			if flags&trunSampleDuration != 0 {
+				sampleDuration = reader.uint32()
+			}
+			if flags&trunSampleSize != 0 {
+				reader.skip(4)
+			}
+			sampleFlags := defaultFlags
+			if flags&trunSampleFlags != 0 {
+				sampleFlags = reader.uint32()
+			} else if i == 0 {
+				sampleFlags = firstSampleFlags
+			}
+			if flags&trunCompositionTime != 0 {
+				reader.skip(4)
+			}
+
+			if first {
+				independent = sampleFlags&sampleIsNonSync == 0
+				first = false
+			}
+			duration += uint64(sampleDuration)
+		}
+
+		if reader.err != nil {
+			return 0, false, reader.err
+		}
+	}
+
+	return duration, independent, nil
+}
+
+// fullBoxReader reads the fields of a full box, recording the first out of bounds access
+type fullBoxReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *fullBoxReader) flags() uint32 {
+	// the version takes the first byte
+	return r.uint32() & 0x00ffffff
+}
+
+func (r *fullBoxReader) uint32() uint32 {
+	if r.err != nil || r.offset+4 > len(r.data) {
+		r.err = fmt.Errorf("truncated box")
+		return 0
+	}
+	value := binary.BigEndian.Uint32(r.data[r.offset : r.offset+4])
+	r.offset += 4
+	return value
+}
+
+func (r *fullBoxReader) skip(n int) {
+	if r.err != nil || r.offset+n > len(r.data) {
+		r.err = fmt.Errorf("truncated box")
+		return
+	}
+	r.offset += n
+}
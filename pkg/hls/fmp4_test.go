@@ -0,0 +1,240 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// makeBox encodes a box with the given payload, or with the given children concatenated
+func makeBox(boxType string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(8+len(data)))
+	copy(header[4:8], boxType)
+	return append(header, data...)
+}
+
+// makeFullBox encodes a full box with the given version and flags followed by 32 bit fields
+func makeFullBox(boxType string, version byte, flags uint32, fields ...uint32) []byte {
+	payload := make([]byte, 4+4*len(fields))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(version)<<24|flags)
+	for i, field := range fields {
+		binary.BigEndian.PutUint32(payload[4+4*i:], field)
+	}
+	return makeBox(boxType, payload)
+}
+
+// makeInit encodes the ftyp and moov boxes of an init segment with a single track
+func makeInit(timescale uint32) []byte {
+	mdhd := makeFullBox("mdhd", 0, 0, 0, 0, timescale, 0, 0)
+	moov := makeBox("moov", makeBox("trak", makeBox("mdia", mdhd)))
+	return append(makeBox("ftyp", []byte("iso6"), []byte{0, 0, 0, 0}), moov...)
+}
+
+// makeFragment encodes a moof and mdat pair whose samples last the given durations. Only the first sample is a sync
+// sample, if keyFrame is set.
+func makeFragment(keyFrame bool, durations ...uint32) []byte {
+	firstFlags := uint32(sampleIsNonSync)
+	if keyFrame {
+		firstFlags = 0
+	}
+
+	fields := []uint32{uint32(len(durations)), firstFlags}
+	for _, duration := range durations {
+		fields = append(fields, duration)
+	}
+
+	tfhd := makeFullBox("tfhd", 0, tfhdDefaultSampleFlags, 1, sampleIsNonSync)
+	trun := makeFullBox("trun", 0, trunFirstSampleFlags|trunSampleDuration, fields...)
+	moof := makeBox("moof", makeFullBox("mfhd", 0, 0, 1), makeBox("traf", tfhd, trun))
+	return append(moof, makeBox("mdat", bytes.Repeat([]byte{0xab}, len(durations)))...)
+}
+
+func TestNextBox(t *testing.T) {
+	largeBox := []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 18, 1, 2}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		boxType  string
+		payload  []byte
+		size     int
+		wantErr  bool
+		complete bool
+	}{
+		{name: "empty", data: nil},
+		{name: "partial header", data: makeBox("free", []byte{1, 2})[:6]},
+		{name: "partial payload", data: makeBox("free", []byte{1, 2, 3})[:10]},
+		{name: "whole box", data: makeBox("free", []byte{1, 2, 3}), boxType: "free", payload: []byte{1, 2, 3}, size: 11, complete: true},
+		{name: "followed by another box", data: append(makeBox("free", []byte{1}), makeBox("skip")...), boxType: "free", payload: []byte{1}, size: 9, complete: true},
+		{name: "large size", data: largeBox, boxType: "mdat", payload: []byte{1, 2}, size: 18, complete: true},
+		{name: "partial large size", data: largeBox[:12]},
+		{name: "unbounded", data: []byte{0, 0, 0, 0, 'm', 'd', 'a', 't'}, wantErr: true},
+		{name: "size smaller than header", data: []byte{0, 0, 0, 4, 'f', 'r', 'e', 'e'}, wantErr: true},
+		{name: "large size smaller than header", data: []byte{0, 0, 0, 1, 'f', 'r', 'e', 'e', 0, 0, 0, 0, 0, 0, 0, 8}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, size, err := nextBox(test.data)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !test.complete {
+				if size != 0 {
+					t.Errorf("expected no box yet, got %d bytes", size)
+				}
+				return
+			}
+			if size != test.size || b.boxType != test.boxType || !bytes.Equal(b.payload, test.payload) {
+				t.Errorf("expected %s box of %d bytes with %v, got %s box of %d bytes with %v",
+					test.boxType, test.size, test.payload, b.boxType, size, b.payload)
+			}
+		})
+	}
+}
+
+func TestParseTimescale(t *testing.T) {
+	mdhdV1 := makeFullBox("mdhd", 1, 0, 0, 0, 0, 0, 90000, 0, 0, 0)
+
+	tests := []struct {
+		name      string
+		moov      []byte
+		timescale uint32
+		wantErr   bool
+	}{
+		{name: "version 0", moov: makeInit(1000)[16:], timescale: 1000},
+		{name: "version 1", moov: makeBox("moov", makeBox("trak", makeBox("mdia", mdhdV1))), timescale: 90000},
+		{name: "missing mdhd", moov: makeBox("moov", makeBox("trak", makeBox("mdia"))), wantErr: true},
+		{name: "truncated mdhd", moov: makeBox("moov", makeBox("trak", makeBox("mdia", makeFullBox("mdhd", 0, 0, 0, 0)))), wantErr: true},
+		{name: "truncated child", moov: makeBox("moov", makeBox("trak", []byte{0, 0, 0, 20, 'm', 'd'})), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			moov, _, err := nextBox(test.moov)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			timescale, err := parseTimescale(moov)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if timescale != test.timescale {
+				t.Errorf("expected timescale %d, got %d", test.timescale, timescale)
+			}
+		})
+	}
+}
+
+func TestParseFragment(t *testing.T) {
+	// tfhd with a default duration of 40 and non sync samples
+	defaultsTfhd := makeFullBox("tfhd", 0, tfhdDefaultSampleDuration|tfhdDefaultSampleFlags, 1, 40, sampleIsNonSync)
+
+	tests := []struct {
+		name        string
+		moof        []byte
+		duration    uint64
+		independent bool
+		wantErr     bool
+	}{
+		{
+			name:        "key frame",
+			moof:        makeFragment(true, 100, 100, 50),
+			duration:    250,
+			independent: true,
+		},
+		{
+			name:     "delta frames",
+			moof:     makeFragment(false, 100, 100),
+			duration: 200,
+		},
+		{
+			name:     "default durations and flags",
+			moof:     makeBox("moof", makeBox("traf", defaultsTfhd, makeFullBox("trun", 0, 0, 3))),
+			duration: 120,
+		},
+		{
+			name: "per sample flags",
+			moof: makeBox("moof", makeBox("traf", defaultsTfhd,
+				makeFullBox("trun", 0, trunSampleFlags|trunSampleSize, 2, 10, 0, 10, sampleIsNonSync))),
+			duration:    80,
+			independent: true,
+		},
+		{
+			name: "several runs",
+			moof: makeBox("moof", makeBox("traf", defaultsTfhd,
+				makeFullBox("trun", 0, trunFirstSampleFlags|trunDataOffset, 1, 8, 0),
+				makeFullBox("trun", 0, trunSampleDuration|trunCompositionTime, 2, 10, 0, 20, 0))),
+			duration:    70,
+			independent: true,
+		},
+		{
+			name: "base data offset and description index",
+			moof: makeBox("moof", makeBox("traf",
+				makeFullBox("tfhd", 0, tfhdBaseDataOffset|tfhdSampleDescriptionIndex|tfhdDefaultSampleDuration|tfhdDefaultSampleSize, 1, 0, 0, 1, 25, 100),
+				makeFullBox("trun", 0, 0, 2))),
+			duration:    50,
+			independent: true,
+		},
+		{
+			name:    "missing traf",
+			moof:    makeBox("moof", makeFullBox("mfhd", 0, 0, 1)),
+			wantErr: true,
+		},
+		{
+			name:    "missing tfhd",
+			moof:    makeBox("moof", makeBox("traf", makeFullBox("trun", 0, 0, 1))),
+			wantErr: true,
+		},
+		{
+			name:    "truncated tfhd",
+			moof:    makeBox("moof", makeBox("traf", makeFullBox("tfhd", 0, tfhdDefaultSampleDuration, 1))),
+			wantErr: true,
+		},
+		{
+			name:    "truncated trun",
+			moof:    makeBox("moof", makeBox("traf", defaultsTfhd, makeFullBox("trun", 0, trunSampleDuration, 3, 10, 10))),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			moof, _, err := nextBox(test.moof)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			duration, independent, err := parseFragment(moof)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if duration != test.duration || independent != test.independent {
+				t.Errorf("expected duration %d and independent %t, got %d and %t",
+					test.duration, test.independent, duration, independent)
+			}
+		})
+	}
+}
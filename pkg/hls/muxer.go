@@ -0,0 +1,418 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a segment or part is not, or no longer, available
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidRequest is returned when a blocking playlist request asks for a segment too far in the future
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+// partsWindow is how many complete segments keep their parts listed in the playlist
+const partsWindow = 3
+
+type Config struct {
+	// Minimum duration of each segment, segments are only cut at key frames
+	SegmentDuration time.Duration
+	// Duration of each part, as configured in the fragmenting muxer
+	PartDuration time.Duration
+	// Number of complete segments kept in memory and listed in the playlist
+	WindowSize int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		SegmentDuration: 2 * time.Second,
+		PartDuration:    500 * time.Millisecond,
+		WindowSize:      6,
+	}
+}
+
+type part struct {
+	data        []byte
+	duration    float64
+	independent bool
+}
+
+type segment struct {
+	sequence uint64
+	parts    []*part
+	duration float64
+	complete bool
+}
+
+func (s *segment) bytes() []byte {
+	var size int
+	for _, p := range s.parts {
+		size += len(p.data)
+	}
+
+	data := make([]byte, 0, size)
+	for _, p := range s.parts {
+		data = append(data, p.data...)
+	}
+	return data
+}
+
+// Muxer splits a fragmented MP4 stream into low latency HLS segments and parts, keeping a window of them in memory.
+// Each moof and mdat pair written by the fragmenting muxer becomes a part, and a new segment is started on the first
+// independent part after the segment duration is reached.
+type Muxer struct {
+	config Config
+
+	mu sync.Mutex
+	// Bytes written that don't make a whole box yet
+	pending   []byte
+	init      []byte
+	initDone  bool
+	timescale uint32
+	// moof box waiting for its mdat
+	moof []byte
+
+	segments     []*segment
+	nextSequence uint64
+	// Parts are dropped until an independent one arrives
+	waitingForKeyFrame bool
+	targetDuration     int
+
+	// closed and replaced every time a part is added
+	changed chan struct{}
+}
+
+func NewMuxer(config Config) (*Muxer, error) {
+	if config.SegmentDuration <= 0 || config.PartDuration <= 0 {
+		return nil, fmt.Errorf("segment and part durations must be positive")
+	}
+	if config.PartDuration > config.SegmentDuration {
+		return nil, fmt.Errorf("part duration must not be longer than the segment duration")
+	}
+	if config.WindowSize < 1 {
+		return nil, fmt.Errorf("window size must be at least 1")
+	}
+
+	return &Muxer{
+		config:             config,
+		waitingForKeyFrame: true,
+		targetDuration:     int(math.Ceil(config.SegmentDuration.Seconds())),
+		changed:            make(chan struct{}),
+	}, nil
+}
+
+// Write feeds the output of the fragmenting muxer. Data doesn't need to be aligned to box boundaries.
+func (m *Muxer) Write(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending = append(m.pending, data...)
+
+	for {
+		b, size, err := nextBox(m.pending)
+		if err != nil {
+			// the stream can't be recovered from here, start over with the next write
+			m.pending = nil
+			return err
+		} else if size == 0 {
+			return nil
+		}
+
+		raw := make([]byte, size)
+		copy(raw, m.pending[:size])
+		m.pending = m.pending[size:]
+
+		if err := m.handleBox(b, raw); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Muxer) handleBox(b box, raw []byte) error {
+	switch b.boxType {
+	case "ftyp":
+		m.init = raw
+		m.initDone = false
+	case "moov":
+		timescale, err := parseTimescale(b)
+		if err != nil {
+			return fmt.Errorf("could not parse init segment: %w", err)
+		}
+		m.init = append(m.init, raw...)
+		m.timescale = timescale
+		m.initDone = true
+		m.notify()
+	case "moof":
+		m.moof = raw
+	case "mdat":
+		if m.moof == nil || !m.initDone {
+			return nil
+		}
+		moof := m.moof
+		m.moof = nil
+
+		moofBox, _, err := nextBox(moof)
+		if err != nil {
+			return err
+		}
+		duration, independent, err := parseFragment(moofBox)
+		if err != nil {
+			return fmt.Errorf("could not parse fragment: %w", err)
+		}
+
+		m.addPart(&part{
+			data:        append(moof, raw...),
+			duration:    float64(duration) / float64(m.timescale),
+			independent: independent,
+		})
+	}
+
+	return nil
+}
+
+func (m *Muxer) addPart(p *part) {
+	// A part this long spans a gap in the input, such as the one left while the stream was idle
+	if p.duration > 2*m.config.SegmentDuration.Seconds() {
+		m.waitingForKeyFrame = true
+		return
+	}
+
+	if m.waitingForKeyFrame {
+		if !p.independent {
+			return
+		}
+		m.waitingForKeyFrame = false
+	}
+
+	current := m.currentSegment()
+	if current == nil || (p.independent && current.duration >= m.config.SegmentDuration.Seconds()) {
+		if current != nil {
+			current.complete = true
+			if duration := int(math.Round(current.duration)); duration > m.targetDuration {
+				m.targetDuration = duration
+			}
+		}
+
+		current = &segment{sequence: m.nextSequence}
+		m.nextSequence++
+		m.segments = append(m.segments, current)
+
+		// keep the configured amount of complete segments besides the one being built
+		if len(m.segments) > m.config.WindowSize+1 {
+			m.segments = m.segments[len(m.segments)-m.config.WindowSize-1:]
+		}
+	}
+
+	current.parts = append(current.parts, p)
+	current.duration += p.duration
+
+	m.notify()
+}
+
+func (m *Muxer) currentSegment() *segment {
+	if len(m.segments) == 0 {
+		return nil
+	}
+	return m.segments[len(m.segments)-1]
+}
+
+func (m *Muxer) findSegment(sequence uint64) *segment {
+	for _, s := range m.segments {
+		if s.sequence == sequence {
+			return s
+		}
+	}
+	return nil
+}
+
+func (m *Muxer) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// waitFor blocks until ready returns true, which is called with the lock held, or ctx is done. The lock is held when
+// it returns without error.
+func (m *Muxer) waitFor(ctx context.Context, ready func() (bool, error)) error {
+	for {
+		ok, err := ready()
+		if err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+
+		changed := m.changed
+		m.mu.Unlock()
+		select {
+		case <-changed:
+			m.mu.Lock()
+		case <-ctx.Done():
+			m.mu.Lock()
+			return ctx.Err()
+		}
+	}
+}
+
+// Reset drops every segment, keeping the init segment since the fragmenting muxer only writes it once. Segment
+// sequence numbers keep increasing.
+func (m *Muxer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.segments = nil
+	m.waitingForKeyFrame = true
+	m.notify()
+}
+
+// Init returns the init segment, waiting for the fragmenting muxer to write it
+func (m *Muxer) Init(ctx context.Context) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.waitFor(ctx, func() (bool, error) {
+		return m.initDone, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.init, nil
+}
+
+// Segment returns a complete segment
+func (m *Muxer) Segment(sequence uint64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.findSegment(sequence)
+	if s == nil || !s.complete {
+		return nil, ErrNotFound
+	}
+
+	return s.bytes(), nil
+}
+
+// Part returns a part of a segment. Requesting the next part of the segment being built blocks until it's available,
+// as advertised by the playlist's preload hint.
+func (m *Muxer) Part(ctx context.Context, sequence uint64, index int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result *part
+	err := m.waitFor(ctx, func() (bool, error) {
+		s := m.findSegment(sequence)
+		if s == nil {
+			current := m.currentSegment()
+			// the hinted part may start the next segment
+			if current != nil && sequence == current.sequence+1 && index == 0 {
+				return false, nil
+			}
+			return false, ErrNotFound
+		}
+
+		if index < len(s.parts) {
+			result = s.parts[index]
+			return true, nil
+		}
+
+		if s.complete || index > len(s.parts) {
+			return false, ErrNotFound
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.data, nil
+}
+
+// Playlist returns the media playlist. If sequence is not negative, it blocks until the segment with that sequence
+// number is complete or, if part is not negative, until that part of the segment is available. Without a sequence
+// number it waits for the first segment to be complete, so players have something to start with.
+func (m *Muxer) Playlist(ctx context.Context, sequence int64, part int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sequence >= 0 && uint64(sequence) > m.nextSequence+1 {
+		return nil, ErrInvalidRequest
+	}
+
+	err := m.waitFor(ctx, func() (bool, error) {
+		if !m.initDone || len(m.segments) == 0 {
+			return false, nil
+		}
+		if sequence < 0 {
+			return m.segments[0].complete, nil
+		}
+
+		current := m.currentSegment()
+		switch {
+		case uint64(sequence) < current.sequence:
+			return true, nil
+		case uint64(sequence) > current.sequence:
+			return false, nil
+		case part < 0:
+			return current.complete, nil
+		default:
+			return len(current.parts) > part, nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(m.playlist()), nil
+}
+
+func (m *Muxer) playlist() string {
+	partTarget := m.partTarget()
+
+	var builder strings.Builder
+	builder.WriteString("#EXTM3U\n")
+	builder.WriteString("#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&builder, "#EXT-X-TARGETDURATION:%d\n", m.targetDuration)
+	fmt.Fprintf(&builder, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget)
+	fmt.Fprintf(&builder, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	fmt.Fprintf(&builder, "#EXT-X-MEDIA-SEQUENCE:%d\n", m.segments[0].sequence)
+	builder.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+
+	for i, s := range m.segments {
+		// only the most recent segments list their parts
+		if i >= len(m.segments)-partsWindow-1 {
+			for j, p := range s.parts {
+				fmt.Fprintf(&builder, "#EXT-X-PART:DURATION=%.5f,URI=\"part/%d.%d.m4s\"", p.duration, s.sequence, j)
+				if p.independent {
+					builder.WriteString(",INDEPENDENT=YES")
+				}
+				builder.WriteString("\n")
+			}
+		}
+
+		if s.complete {
+			fmt.Fprintf(&builder, "#EXTINF:%.5f,\n", s.duration)
+			fmt.Fprintf(&builder, "segment/%d.m4s\n", s.sequence)
+		}
+	}
+
+	current := m.currentSegment()
+	if current.complete {
+		fmt.Fprintf(&builder, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part/%d.0.m4s\"\n", current.sequence+1)
+	} else {
+		fmt.Fprintf(&builder, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part/%d.%d.m4s\"\n", current.sequence, len(current.parts))
+	}
+
+	return builder.String()
+}
+
+// partTarget returns the advertised part duration. The fragmenting muxer may overshoot the configured duration by a
+// frame, so some headroom is left.
+func (m *Muxer) partTarget() float64 {
+	return m.config.PartDuration.Seconds() * 1.5
+}
@@ -0,0 +1,478 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds every blocking request of the tests
+const testTimeout = 2 * time.Second
+
+func testConfig(windowSize int) Config {
+	return Config{SegmentDuration: 2 * time.Second, PartDuration: 500 * time.Millisecond, WindowSize: windowSize}
+}
+
+// fragmentSpec describes a part fed to the muxer, lasting duration milliseconds
+type fragmentSpec struct {
+	keyFrame bool
+	duration uint32
+}
+
+var (
+	key   = fragmentSpec{true, 500}
+	delta = fragmentSpec{false, 500}
+)
+
+func newTestMuxer(t *testing.T, config Config, fragments ...fragmentSpec) *Muxer {
+	t.Helper()
+
+	muxer, err := NewMuxer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := muxer.Write(makeInit(1000)); err != nil {
+		t.Fatal(err)
+	}
+	writeFragments(t, muxer, fragments...)
+	return muxer
+}
+
+func writeFragments(t *testing.T, muxer *Muxer, fragments ...fragmentSpec) {
+	t.Helper()
+
+	for _, fragment := range fragments {
+		if err := muxer.Write(makeFragment(fragment.keyFrame, fragment.duration)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func repeat(fragment fragmentSpec, n int) []fragmentSpec {
+	fragments := make([]fragmentSpec, n)
+	for i := range fragments {
+		fragments[i] = fragment
+	}
+	return fragments
+}
+
+func concat(groups ...[]fragmentSpec) []fragmentSpec {
+	var fragments []fragmentSpec
+	for _, group := range groups {
+		fragments = append(fragments, group...)
+	}
+	return fragments
+}
+
+func TestNewMuxerConfig(t *testing.T) {
+	for _, config := range []Config{
+		{SegmentDuration: 0, PartDuration: time.Second, WindowSize: 1},
+		{SegmentDuration: time.Second, PartDuration: 0, WindowSize: 1},
+		{SegmentDuration: time.Second, PartDuration: 2 * time.Second, WindowSize: 1},
+		{SegmentDuration: time.Second, PartDuration: time.Second, WindowSize: 0},
+	} {
+		if _, err := NewMuxer(config); err == nil {
+			t.Errorf("expected config %+v to be rejected", config)
+		}
+	}
+}
+
+func TestMuxerWriteSplitBoxes(t *testing.T) {
+	init := makeInit(1000)
+	fragments := [][]byte{makeFragment(true, 500), makeFragment(false, 500)}
+	stream := bytes.Join(append([][]byte{init}, fragments...), nil)
+
+	for _, chunkSize := range []int{1, 3, 7, 64, len(stream)} {
+		muxer, err := NewMuxer(testConfig(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for offset := 0; offset < len(stream); offset += chunkSize {
+			end := offset + chunkSize
+			if end > len(stream) {
+				end = len(stream)
+			}
+			if err := muxer.Write(stream[offset:end]); err != nil {
+				t.Fatalf("chunks of %d bytes: unexpected error: %v", chunkSize, err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		if data, err := muxer.Init(ctx); err != nil || !bytes.Equal(data, init) {
+			t.Errorf("chunks of %d bytes: expected the init segment, got %d bytes (%v)", chunkSize, len(data), err)
+		}
+		for i, fragment := range fragments {
+			if data, err := muxer.Part(ctx, 0, i); err != nil || !bytes.Equal(data, fragment) {
+				t.Errorf("chunks of %d bytes: expected part %d to be its fragment, got %d bytes (%v)", chunkSize, i, len(data), err)
+			}
+		}
+	}
+}
+
+func TestMuxerWriteInvalidBox(t *testing.T) {
+	muxer := newTestMuxer(t, testConfig(2))
+
+	if err := muxer.Write([]byte{0, 0, 0, 0, 'm', 'o', 'o', 'f'}); err == nil {
+		t.Fatal("expected an unbounded box to be rejected")
+	}
+
+	// The stream starts over with the next write
+	writeFragments(t, muxer, key)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := muxer.Part(ctx, 0, 0); err != nil {
+		t.Errorf("expected the muxer to recover, got %v", err)
+	}
+}
+
+func TestMuxerSegments(t *testing.T) {
+	type segmentSpec struct {
+		sequence uint64
+		parts    int
+		complete bool
+	}
+
+	tests := []struct {
+		name       string
+		windowSize int
+		fragments  []fragmentSpec
+		segments   []segmentSpec
+	}{
+		{
+			name:      "cut at key frame once duration is reached",
+			fragments: []fragmentSpec{key, delta, delta, delta, key},
+			segments:  []segmentSpec{{0, 4, true}, {1, 1, false}},
+		},
+		{
+			name:      "delta frames past duration stay in segment",
+			fragments: []fragmentSpec{key, delta, delta, delta, delta, delta, key},
+			segments:  []segmentSpec{{0, 6, true}, {1, 1, false}},
+		},
+		{
+			name:      "key frame before duration does not cut",
+			fragments: []fragmentSpec{key, delta, key, delta, key},
+			segments:  []segmentSpec{{0, 4, true}, {1, 1, false}},
+		},
+		{
+			name:      "parts before the first key frame are dropped",
+			fragments: []fragmentSpec{delta, delta, key, delta},
+			segments:  []segmentSpec{{0, 2, false}},
+		},
+		{
+			name:      "gap waits for the next key frame",
+			fragments: []fragmentSpec{key, delta, delta, delta, {false, 5000}, delta, key, delta},
+			segments:  []segmentSpec{{0, 4, true}, {1, 2, false}},
+		},
+		{
+			name:       "window keeps the latest complete segments",
+			windowSize: 2,
+			fragments:  concat(repeat(key, 1), repeat(delta, 3), repeat(key, 4), repeat(key, 4), repeat(key, 4), repeat(key, 1)),
+			segments:   []segmentSpec{{2, 4, true}, {3, 4, true}, {4, 1, false}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			windowSize := test.windowSize
+			if windowSize == 0 {
+				windowSize = 6
+			}
+			muxer := newTestMuxer(t, testConfig(windowSize), test.fragments...)
+
+			if len(muxer.segments) != len(test.segments) {
+				t.Fatalf("expected %d segments, got %d", len(test.segments), len(muxer.segments))
+			}
+			for i, want := range test.segments {
+				got := muxer.segments[i]
+				if got.sequence != want.sequence || len(got.parts) != want.parts || got.complete != want.complete {
+					t.Errorf("expected segment %d to be %+v, got sequence %d with %d parts, complete %t",
+						i, want, got.sequence, len(got.parts), got.complete)
+				}
+			}
+		})
+	}
+}
+
+func TestMuxerSegment(t *testing.T) {
+	muxer := newTestMuxer(t, testConfig(6), key, delta, delta, delta, key)
+
+	want := bytes.Join([][]byte{
+		makeFragment(true, 500), makeFragment(false, 500), makeFragment(false, 500), makeFragment(false, 500),
+	}, nil)
+	if data, err := muxer.Segment(0); err != nil || !bytes.Equal(data, want) {
+		t.Errorf("expected segment 0 to hold its parts, got %d bytes (%v)", len(data), err)
+	}
+
+	for _, sequence := range []uint64{1, 2} {
+		if _, err := muxer.Segment(sequence); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected segment %d to not be found, got %v", sequence, err)
+		}
+	}
+}
+
+func TestMuxerPlaylist(t *testing.T) {
+	muxer := newTestMuxer(t, testConfig(6), key, delta, delta, delta, key, delta)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	playlist, err := muxer.Playlist(ctx, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=2.250
+#EXT-X-PART-INF:PART-TARGET=0.750
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-PART:DURATION=0.50000,URI="part/0.0.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.50000,URI="part/0.1.m4s"
+#EXT-X-PART:DURATION=0.50000,URI="part/0.2.m4s"
+#EXT-X-PART:DURATION=0.50000,URI="part/0.3.m4s"
+#EXTINF:2.00000,
+segment/0.m4s
+#EXT-X-PART:DURATION=0.50000,URI="part/1.0.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.50000,URI="part/1.1.m4s"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="part/1.2.m4s"
+`
+	if string(playlist) != want {
+		t.Errorf("expected playlist\n%s\ngot\n%s", want, playlist)
+	}
+}
+
+func TestMuxerPlaylistWindow(t *testing.T) {
+	// Six complete segments of 3 seconds, with one more being built
+	segment := concat(repeat(key, 1), repeat(delta, 5))
+	muxer := newTestMuxer(t, testConfig(4), concat(segment, segment, segment, segment, segment, segment, repeat(key, 1))...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	playlist, err := muxer.Playlist(ctx, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"#EXT-X-TARGETDURATION:3\n",
+		"#EXT-X-MEDIA-SEQUENCE:2\n",
+		"#EXTINF:3.00000,\nsegment/2.m4s\n",
+		"segment/5.m4s\n",
+		// Only the latest segments list their parts
+		`URI="part/3.0.m4s"`,
+		`URI="part/6.0.m4s"`,
+		`#EXT-X-PRELOAD-HINT:TYPE=PART,URI="part/6.1.m4s"`,
+	} {
+		if !strings.Contains(string(playlist), want) {
+			t.Errorf("expected playlist to contain %q, got\n%s", want, playlist)
+		}
+	}
+	for _, unwanted := range []string{"segment/1.m4s", `URI="part/2.0.m4s"`} {
+		if strings.Contains(string(playlist), unwanted) {
+			t.Errorf("expected playlist not to contain %q, got\n%s", unwanted, playlist)
+		}
+	}
+}
+
+// request runs a blocking muxer request in the background, returning a channel with its error
+func request(f func(ctx context.Context) error) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		result <- f(ctx)
+	}()
+	return result
+}
+
+// checkBlocked fails the test if the request already returned
+func checkBlocked(t *testing.T, result <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-result:
+		t.Fatalf("expected the request to block, it returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMuxerBlockingPlaylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence int64
+		part     int
+		// Fragments written while the request is blocked, none if it must not block
+		unblock []fragmentSpec
+		wantErr error
+	}{
+		{name: "complete segment", sequence: 0, part: -1},
+		{name: "available part", sequence: 1, part: 1},
+		{name: "next part", sequence: 1, part: 2, unblock: []fragmentSpec{delta}},
+		{name: "segment being built", sequence: 1, part: -1, unblock: []fragmentSpec{delta, delta, key}},
+		{name: "first part of next segment", sequence: 2, part: 0, unblock: []fragmentSpec{delta, delta, key}},
+		{name: "next segment", sequence: 2, part: -1, unblock: concat(repeat(delta, 2), repeat(key, 4), repeat(key, 1))},
+		{name: "too far ahead", sequence: 4, part: -1, wantErr: ErrInvalidRequest},
+		{name: "never available", sequence: 3, part: 0, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			muxer := newTestMuxer(t, testConfig(6), key, delta, delta, delta, key, delta)
+
+			var playlist []byte
+			result := request(func(ctx context.Context) error {
+				var err error
+				playlist, err = muxer.Playlist(ctx, test.sequence, test.part)
+				return err
+			})
+
+			if test.unblock != nil {
+				checkBlocked(t, result)
+				writeFragments(t, muxer, test.unblock...)
+			}
+
+			err := <-result
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The playlist lists what was asked for
+			want := "segment/" + strconv.FormatInt(test.sequence, 10) + ".m4s"
+			if test.part >= 0 {
+				want = `URI="part/` + strconv.FormatInt(test.sequence, 10) + "." + strconv.Itoa(test.part) + `.m4s"`
+			}
+			if !strings.Contains(string(playlist), want) {
+				t.Errorf("expected playlist to contain %s, got\n%s", want, playlist)
+			}
+		})
+	}
+}
+
+func TestMuxerPlaylistWaitsForFirstSegment(t *testing.T) {
+	muxer := newTestMuxer(t, testConfig(6), key, delta)
+
+	result := request(func(ctx context.Context) error {
+		_, err := muxer.Playlist(ctx, -1, -1)
+		return err
+	})
+	checkBlocked(t, result)
+
+	writeFragments(t, muxer, delta, delta, key)
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMuxerBlockingPart(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence uint64
+		index    int
+		unblock  []fragmentSpec
+		want     fragmentSpec
+		wantErr  error
+	}{
+		{name: "available part", sequence: 1, index: 0, want: key},
+		{name: "hinted part", sequence: 1, index: 2, unblock: []fragmentSpec{{false, 400}}, want: fragmentSpec{false, 400}},
+		{name: "first part of next segment", sequence: 2, index: 0, unblock: []fragmentSpec{delta, delta, {true, 300}}, want: fragmentSpec{true, 300}},
+		{name: "past the end of a complete segment", sequence: 0, index: 4, wantErr: ErrNotFound},
+		{name: "past the hinted part", sequence: 1, index: 3, wantErr: ErrNotFound},
+		{name: "later part of next segment", sequence: 2, index: 1, wantErr: ErrNotFound},
+		{name: "unknown segment", sequence: 7, index: 0, wantErr: ErrNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			muxer := newTestMuxer(t, testConfig(6), key, delta, delta, delta, key, delta)
+
+			var data []byte
+			result := request(func(ctx context.Context) error {
+				var err error
+				data, err = muxer.Part(ctx, test.sequence, test.index)
+				return err
+			})
+
+			if test.unblock != nil {
+				checkBlocked(t, result)
+				writeFragments(t, muxer, test.unblock...)
+			}
+
+			err := <-result
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := makeFragment(test.want.keyFrame, test.want.duration); !bytes.Equal(data, want) {
+				t.Errorf("expected the part's fragment, got %d bytes", len(data))
+			}
+		})
+	}
+}
+
+func TestMuxerInit(t *testing.T) {
+	muxer, err := NewMuxer(testConfig(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	result := request(func(ctx context.Context) error {
+		var err error
+		data, err = muxer.Init(ctx)
+		return err
+	})
+	checkBlocked(t, result)
+
+	init := makeInit(1000)
+	if err := muxer.Write(init); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, init) {
+		t.Errorf("expected the init segment, got %d bytes", len(data))
+	}
+}
+
+func TestMuxerReset(t *testing.T) {
+	muxer := newTestMuxer(t, testConfig(6), key, delta, delta, delta, key)
+	muxer.Reset()
+
+	if len(muxer.segments) != 0 {
+		t.Fatalf("expected no segments, got %d", len(muxer.segments))
+	}
+
+	// Delta frames are dropped until the next key frame, and sequence numbers keep going
+	writeFragments(t, muxer, delta, key)
+	if len(muxer.segments) != 1 || muxer.segments[0].sequence != 2 || len(muxer.segments[0].parts) != 1 {
+		t.Fatalf("expected segment 2 with a single part, got %d segments", len(muxer.segments))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if data, err := muxer.Init(ctx); err != nil || !bytes.Equal(data, makeInit(1000)) {
+		t.Errorf("expected the init segment to be kept, got %d bytes (%v)", len(data), err)
+	}
+}
@@ -0,0 +1,150 @@
+// Package onvif implements the parts of the ONVIF device, media and PTZ services needed to control cameras.
+package onvif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestTimeout limits every request to a device, in case the caller's context has no deadline
+const requestTimeout = 10 * time.Second
+
+var ErrPTZNotSupported = errors.New("device does not support ptz")
+
+// Capabilities holds the addresses of the services offered by a device. Missing services have an empty address.
+type Capabilities struct {
+	Device string `json:"device"`
+	Media  string `json:"media"`
+	PTZ    string `json:"ptz"`
+}
+
+type Client struct {
+	endpoint   string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	// Guards the cached capabilities and profile token, but is never held during requests
+	mu           sync.Mutex
+	capabilities *Capabilities
+	// Media profile the PTZ operations apply to
+	profileToken string
+}
+
+// NewClient creates a client for the device service at endpoint, usually http://<address>/onvif/device_service. An
+// empty username disables authentication.
+func NewClient(endpoint string, username string, password string) *Client {
+	return &Client{
+		endpoint:   endpoint,
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+type getCapabilitiesResponse struct {
+	Capabilities struct {
+		Device struct {
+			XAddr string `xml:"XAddr"`
+		} `xml:"Device"`
+		Media struct {
+			XAddr string `xml:"XAddr"`
+		} `xml:"Media"`
+		PTZ struct {
+			XAddr string `xml:"XAddr"`
+		} `xml:"PTZ"`
+	} `xml:"Capabilities"`
+}
+
+// GetCapabilities returns the services offered by the device. They're only requested once.
+func (c *Client) GetCapabilities(ctx context.Context) (Capabilities, error) {
+	c.mu.Lock()
+	cached := c.capabilities
+	c.mu.Unlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	// The lock isn't held during the request, so a slow device doesn't block other callers. Concurrent first calls
+	// may all send the request, which is harmless.
+	var response getCapabilitiesResponse
+	body := `<GetCapabilities xmlns="` + deviceNamespace + `"><Category>All</Category></GetCapabilities>`
+	if err := c.call(ctx, c.endpoint, body, &response); err != nil {
+		return Capabilities{}, fmt.Errorf("could not get device capabilities: %w", err)
+	}
+
+	capabilities := Capabilities{
+		Device: response.Capabilities.Device.XAddr,
+		Media:  response.Capabilities.Media.XAddr,
+		PTZ:    response.Capabilities.PTZ.XAddr,
+	}
+
+	c.mu.Lock()
+	c.capabilities = &capabilities
+	c.mu.Unlock()
+
+	return capabilities, nil
+}
+
+type getProfilesResponse struct {
+	Profiles []struct {
+		Token                     string `xml:"token,attr"`
+		Name                      string `xml:"Name"`
+		VideoEncoderConfiguration *struct {
+			Encoding   string `xml:"Encoding"`
+			Resolution struct {
+				Width  int `xml:"Width"`
+				Height int `xml:"Height"`
+			} `xml:"Resolution"`
+		} `xml:"VideoEncoderConfiguration"`
+		PTZConfiguration *struct{} `xml:"PTZConfiguration"`
+	} `xml:"Profiles"`
+}
+
+func (c *Client) getProfiles(ctx context.Context, mediaAddress string) (getProfilesResponse, error) {
+	var response getProfilesResponse
+	body := `<GetProfiles xmlns="` + mediaNamespace + `"/>`
+	if err := c.call(ctx, mediaAddress, body, &response); err != nil {
+		return getProfilesResponse{}, fmt.Errorf("could not get media profiles: %w", err)
+	}
+	return response, nil
+}
+
+// ptzTarget returns the address of the PTZ service and the media profile its operations apply to, which is the first
+// profile with a PTZ configuration
+func (c *Client) ptzTarget(ctx context.Context) (string, string, error) {
+	capabilities, err := c.GetCapabilities(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if capabilities.PTZ == "" || capabilities.Media == "" {
+		return "", "", ErrPTZNotSupported
+	}
+
+	c.mu.Lock()
+	profileToken := c.profileToken
+	c.mu.Unlock()
+	if profileToken != "" {
+		return capabilities.PTZ, profileToken, nil
+	}
+
+	response, err := c.getProfiles(ctx, capabilities.Media)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, profile := range response.Profiles {
+		if profile.PTZConfiguration != nil {
+			c.mu.Lock()
+			c.profileToken = profile.Token
+			c.mu.Unlock()
+			return capabilities.PTZ, profile.Token, nil
+		}
+	}
+
+	return "", "", ErrPTZNotSupported
+}
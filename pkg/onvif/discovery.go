@@ -0,0 +1,177 @@
+package onvif
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DiscoveryAddress is the multicast group and port WS-Discovery probes are sent to
+const DiscoveryAddress = "239.255.255.250:3702"
+
+const (
+	// probeAttempts is how many times the probe is sent, since UDP datagrams may be lost
+	probeAttempts = 2
+	// maxDatagramSize fits any probe match sent over UDP
+	maxDatagramSize = 65535
+)
+
+// Device is an ONVIF device that answered a WS-Discovery probe
+type Device struct {
+	// Endpoint reference of the device, usually a urn:uuid
+	Address string `json:"address"`
+	// Addresses of the device service
+	XAddrs []string `json:"xaddrs"`
+	Scopes []string `json:"scopes"`
+	// Name and hardware of the device according to its scopes, if it has them
+	Name     string `json:"name,omitempty"`
+	Hardware string `json:"hardware,omitempty"`
+}
+
+type probeMatchEnvelope struct {
+	Header struct {
+		RelatesTo string `xml:"RelatesTo"`
+	} `xml:"Header"`
+	Body struct {
+		ProbeMatches struct {
+			ProbeMatch []struct {
+				EndpointReference struct {
+					Address string `xml:"Address"`
+				} `xml:"EndpointReference"`
+				Scopes string `xml:"Scopes"`
+				XAddrs string `xml:"XAddrs"`
+			} `xml:"ProbeMatch"`
+		} `xml:"ProbeMatches"`
+	} `xml:"Body"`
+}
+
+func newMessageId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	// random UUID, version 4
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
+
+func probeMessage(messageId string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+		` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<e:Header>` +
+		`<w:MessageID>` + messageId + `</w:MessageID>` +
+		`<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>` +
+		`<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action>` +
+		`</e:Header>` +
+		`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>` +
+		`</e:Envelope>`)
+}
+
+// scopeValue returns the decoded value of the first onvif://www.onvif.org/<kind>/ scope
+func scopeValue(scopes []string, kind string) string {
+	prefix := "onvif://www.onvif.org/" + kind + "/"
+	for _, scope := range scopes {
+		if strings.HasPrefix(scope, prefix) {
+			value, err := url.PathUnescape(strings.TrimPrefix(scope, prefix))
+			if err != nil {
+				return strings.TrimPrefix(scope, prefix)
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// Discover sends a WS-Discovery probe for video transmitters to address, usually DiscoveryAddress, and collects the
+// devices that answer until ctx is done. Devices that answer more than once are only returned once.
+func Discover(ctx context.Context, address string) ([]Device, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, errors.New("discovery requires a context with a deadline")
+	}
+
+	destination, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discovery address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	messageId, err := newMessageId()
+	if err != nil {
+		return nil, err
+	}
+
+	probe := probeMessage(messageId)
+	for i := 0; i < probeAttempts; i++ {
+		if _, err := conn.WriteToUDP(probe, destination); err != nil {
+			return nil, fmt.Errorf("could not send discovery probe: %w", err)
+		}
+	}
+
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Unblock the read if ctx is canceled before its deadline
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var devices []Device
+	seen := make(map[string]bool)
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return devices, nil
+		} else if err != nil {
+			return devices, fmt.Errorf("could not read discovery response: %w", err)
+		}
+
+		var envelope probeMatchEnvelope
+		if err := xml.Unmarshal(buffer[:n], &envelope); err != nil || envelope.Header.RelatesTo != messageId {
+			continue
+		}
+
+		for _, match := range envelope.Body.ProbeMatches.ProbeMatch {
+			address := match.EndpointReference.Address
+			if address == "" || seen[address] {
+				continue
+			}
+			seen[address] = true
+
+			device := Device{
+				Address: address,
+				XAddrs:  strings.Fields(match.XAddrs),
+				Scopes:  strings.Fields(match.Scopes),
+			}
+			device.Name = scopeValue(device.Scopes, "name")
+			device.Hardware = scopeValue(device.Scopes, "hardware")
+
+			devices = append(devices, device)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package onvif
+
+import (
+	"context"
+	"encoding/xml"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func probeMatch(relatesTo string, address string, scopes string, xaddrs string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">` +
+		`<e:Header><w:RelatesTo>` + relatesTo + `</w:RelatesTo></e:Header>` +
+		`<e:Body><d:ProbeMatches><d:ProbeMatch>` +
+		`<w:EndpointReference><w:Address>` + address + `</w:Address></w:EndpointReference>` +
+		`<d:Scopes>` + scopes + `</d:Scopes>` +
+		`<d:XAddrs>` + xaddrs + `</d:XAddrs>` +
+		`</d:ProbeMatch></d:ProbeMatches></e:Body>` +
+		`</e:Envelope>`)
+}
+
+// startResponder answers every probe sent to the returned address with the matches built by respond
+func startResponder(t *testing.T, respond func(messageId string) [][]byte) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buffer := make([]byte, maxDatagramSize)
+		for {
+			n, sender, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			var probe struct {
+				Header struct {
+					MessageID string `xml:"MessageID"`
+				} `xml:"Header"`
+			}
+			if err := xml.Unmarshal(buffer[:n], &probe); err != nil {
+				t.Errorf("could not parse probe: %v", err)
+				return
+			}
+
+			for _, response := range respond(probe.Header.MessageID) {
+				_, _ = conn.WriteToUDP(response, sender)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDiscover(t *testing.T) {
+	address := startResponder(t, func(messageId string) [][]byte {
+		return [][]byte{
+			// An answer to another probe
+			probeMatch("urn:uuid:other", "urn:uuid:stale", "", "http://192.0.2.1/onvif/device_service"),
+			probeMatch(messageId, "urn:uuid:camera-1",
+				"onvif://www.onvif.org/name/Front%20door onvif://www.onvif.org/hardware/IPC-123 onvif://www.onvif.org/type/video_encoder",
+				"http://192.0.2.10/onvif/device_service http://[2001:db8::10]/onvif/device_service"),
+			probeMatch(messageId, "urn:uuid:camera-2", "", "http://192.0.2.11/onvif/device_service"),
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	// Every probe is sent more than once, so each camera answers repeatedly
+	devices, err := Discover(ctx, address)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []Device{
+		{
+			Address: "urn:uuid:camera-1",
+			XAddrs:  []string{"http://192.0.2.10/onvif/device_service", "http://[2001:db8::10]/onvif/device_service"},
+			Scopes: []string{"onvif://www.onvif.org/name/Front%20door", "onvif://www.onvif.org/hardware/IPC-123",
+				"onvif://www.onvif.org/type/video_encoder"},
+			Name:     "Front door",
+			Hardware: "IPC-123",
+		},
+		{
+			Address: "urn:uuid:camera-2",
+			XAddrs:  []string{"http://192.0.2.11/onvif/device_service"},
+			Scopes:  []string{},
+		},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("expected devices %+v, got %+v", want, devices)
+	}
+}
+
+func TestDiscoverCanceled(t *testing.T) {
+	address := startResponder(t, func(string) [][]byte { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	devices, err := Discover(ctx, address)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("expected no devices, got %+v", devices)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected discovery to stop when canceled, took %s", elapsed)
+	}
+}
+
+func TestDiscoverRequiresDeadline(t *testing.T) {
+	if _, err := Discover(context.Background(), "127.0.0.1:3702"); err == nil {
+		t.Fatal("expected an error without a deadline")
+	}
+}
+
+func TestScopeValue(t *testing.T) {
+	scopes := []string{
+		"onvif://www.onvif.org/type/video_encoder",
+		"onvif://www.onvif.org/name/Camera%20%231",
+		"onvif://www.onvif.org/name/second",
+		"onvif://www.onvif.org/hardware/bad%zzescape",
+		"onvif://www.onvif.org/location/",
+	}
+
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{kind: "name", want: "Camera #1"},
+		{kind: "type", want: "video_encoder"},
+		{kind: "hardware", want: "bad%zzescape"},
+		{kind: "location", want: ""},
+		{kind: "missing", want: ""},
+	}
+
+	for _, test := range tests {
+		if value := scopeValue(scopes, test.kind); value != test.want {
+			t.Errorf("scope %s: expected %q, got %q", test.kind, test.want, value)
+		}
+	}
+}
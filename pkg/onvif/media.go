@@ -0,0 +1,69 @@
+package onvif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrMediaNotSupported = errors.New("device does not support the media service")
+
+// MediaProfile is a video stream offered by a device
+type MediaProfile struct {
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+	Encoding string `json:"encoding,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	// RTSP URI of the stream
+	URI string `json:"uri"`
+}
+
+type getStreamUriResponse struct {
+	MediaUri struct {
+		Uri string `xml:"Uri"`
+	} `xml:"MediaUri"`
+}
+
+// GetStreamUris returns the RTSP URI of every media profile of the device
+func (c *Client) GetStreamUris(ctx context.Context) ([]MediaProfile, error) {
+	capabilities, err := c.GetCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if capabilities.Media == "" {
+		return nil, ErrMediaNotSupported
+	}
+
+	response, err := c.getProfiles(ctx, capabilities.Media)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]MediaProfile, 0, len(response.Profiles))
+	for _, profile := range response.Profiles {
+		mediaProfile := MediaProfile{Token: profile.Token, Name: profile.Name}
+		if encoder := profile.VideoEncoderConfiguration; encoder != nil {
+			mediaProfile.Encoding = encoder.Encoding
+			mediaProfile.Width = encoder.Resolution.Width
+			mediaProfile.Height = encoder.Resolution.Height
+		}
+
+		var uriResponse getStreamUriResponse
+		body := `<GetStreamUri xmlns="` + mediaNamespace + `">` +
+			`<StreamSetup>` +
+			`<Stream xmlns="` + schemaNamespace + `">RTP-Unicast</Stream>` +
+			`<Transport xmlns="` + schemaNamespace + `"><Protocol>RTSP</Protocol></Transport>` +
+			`</StreamSetup>` +
+			`<ProfileToken>` + escape(profile.Token) + `</ProfileToken>` +
+			`</GetStreamUri>`
+		if err := c.call(ctx, capabilities.Media, body, &uriResponse); err != nil {
+			return nil, fmt.Errorf("could not get stream uri of profile %s: %w", profile.Token, err)
+		}
+
+		mediaProfile.URI = uriResponse.MediaUri.Uri
+		profiles = append(profiles, mediaProfile)
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,74 @@
+package onvif
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetStreamUris(t *testing.T) {
+	device := newFakeDevice(t, map[string]string{
+		"GetProfiles": `<GetProfilesResponse>` +
+			`<Profiles token="main"><Name>Main</Name><VideoEncoderConfiguration>` +
+			`<Encoding>H264</Encoding><Resolution><Width>1920</Width><Height>1080</Height></Resolution>` +
+			`</VideoEncoderConfiguration></Profiles>` +
+			`<Profiles token="audio"><Name>Audio only</Name></Profiles>` +
+			`</GetProfilesResponse>`,
+		"GetStreamUri": `<GetStreamUriResponse><MediaUri><Uri>rtsp://192.0.2.10/stream</Uri></MediaUri></GetStreamUriResponse>`,
+	})
+
+	profiles, err := device.client().GetStreamUris(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []MediaProfile{
+		{Token: "main", Name: "Main", Encoding: "H264", Width: 1920, Height: 1080, URI: "rtsp://192.0.2.10/stream"},
+		{Token: "audio", Name: "Audio only", URI: "rtsp://192.0.2.10/stream"},
+	}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Errorf("expected profiles %+v, got %+v", want, profiles)
+	}
+
+	if count := device.requestCount("GetStreamUri"); count != 2 {
+		t.Fatalf("expected a stream uri request per profile, got %d", count)
+	}
+	body := device.lastRequest("GetStreamUri")
+	for _, want := range []string{`<ProfileToken>audio</ProfileToken>`, `>RTP-Unicast</Stream>`, `<Protocol>RTSP</Protocol>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected request %s to contain %s", body, want)
+		}
+	}
+}
+
+func TestGetStreamUrisWithoutMedia(t *testing.T) {
+	device := newFakeDevice(t, map[string]string{
+		"GetCapabilities": `<GetCapabilitiesResponse><Capabilities>` +
+			`<Device><XAddr>http://127.0.0.1/device</XAddr></Device>` +
+			`</Capabilities></GetCapabilitiesResponse>`,
+	})
+
+	if _, err := device.client().GetStreamUris(context.Background()); !errors.Is(err, ErrMediaNotSupported) {
+		t.Fatalf("expected ErrMediaNotSupported, got %v", err)
+	}
+}
+
+func TestGetStreamUrisFault(t *testing.T) {
+	device := newFakeDevice(t, map[string]string{
+		"GetProfiles": `<GetProfilesResponse><Profiles token="main"><Name>Main</Name></Profiles></GetProfilesResponse>`,
+		"GetStreamUri": `<s:Fault><s:Code><s:Value>s:Receiver</s:Value>` +
+			`<s:Subcode><s:Value>ter:ActionNotSupported</s:Value></s:Subcode></s:Code>` +
+			`<s:Reason><s:Text>not supported</s:Text></s:Reason></s:Fault>`,
+	})
+
+	_, err := device.client().GetStreamUris(context.Background())
+	var fault *Fault
+	if !errors.As(err, &fault) {
+		t.Fatalf("expected a fault, got %v", err)
+	}
+	if fault.Subcode != "ter:ActionNotSupported" || fault.Reason != "not supported" {
+		t.Errorf("unexpected fault %+v", fault)
+	}
+}
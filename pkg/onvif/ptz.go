@@ -0,0 +1,131 @@
+package onvif
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Velocity of a continuous move, with every component between -1 and 1
+type Velocity struct {
+	Pan  float64 `json:"pan"`
+	Tilt float64 `json:"tilt"`
+	Zoom float64 `json:"zoom"`
+}
+
+func (v Velocity) Validate() error {
+	for _, component := range []float64{v.Pan, v.Tilt, v.Zoom} {
+		if component < -1 || component > 1 {
+			return fmt.Errorf("velocity components must be between -1 and 1")
+		}
+	}
+	return nil
+}
+
+type Preset struct {
+	Token string `json:"token"`
+	Name  string `json:"name"`
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// xsdDuration formats a duration as an XML schema duration, such as PT1.5S
+func xsdDuration(duration time.Duration) string {
+	return "PT" + formatFloat(duration.Seconds()) + "S"
+}
+
+// ContinuousMove starts moving the camera at the given velocity until it's stopped, or until timeout if it's not zero
+func (c *Client) ContinuousMove(ctx context.Context, velocity Velocity, timeout time.Duration) error {
+	if err := velocity.Validate(); err != nil {
+		return err
+	}
+
+	address, profileToken, err := c.ptzTarget(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := `<ContinuousMove xmlns="` + ptzNamespace + `">` +
+		`<ProfileToken>` + escape(profileToken) + `</ProfileToken>` +
+		`<Velocity>` +
+		`<PanTilt xmlns="` + schemaNamespace + `" x="` + formatFloat(velocity.Pan) + `" y="` + formatFloat(velocity.Tilt) + `"/>` +
+		`<Zoom xmlns="` + schemaNamespace + `" x="` + formatFloat(velocity.Zoom) + `"/>` +
+		`</Velocity>`
+	if timeout > 0 {
+		body += `<Timeout>` + xsdDuration(timeout) + `</Timeout>`
+	}
+	body += `</ContinuousMove>`
+
+	if err := c.call(ctx, address, body, nil); err != nil {
+		return fmt.Errorf("could not move camera: %w", err)
+	}
+	return nil
+}
+
+// Stop stops the pan and tilt and/or the zoom movements of the camera
+func (c *Client) Stop(ctx context.Context, panTilt bool, zoom bool) error {
+	address, profileToken, err := c.ptzTarget(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := `<Stop xmlns="` + ptzNamespace + `">` +
+		`<ProfileToken>` + escape(profileToken) + `</ProfileToken>` +
+		`<PanTilt>` + strconv.FormatBool(panTilt) + `</PanTilt>` +
+		`<Zoom>` + strconv.FormatBool(zoom) + `</Zoom>` +
+		`</Stop>`
+
+	if err := c.call(ctx, address, body, nil); err != nil {
+		return fmt.Errorf("could not stop camera: %w", err)
+	}
+	return nil
+}
+
+type getPresetsResponse struct {
+	Presets []struct {
+		Token string `xml:"token,attr"`
+		Name  string `xml:"Name"`
+	} `xml:"Preset"`
+}
+
+func (c *Client) GetPresets(ctx context.Context) ([]Preset, error) {
+	address, profileToken, err := c.ptzTarget(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var response getPresetsResponse
+	body := `<GetPresets xmlns="` + ptzNamespace + `">` +
+		`<ProfileToken>` + escape(profileToken) + `</ProfileToken>` +
+		`</GetPresets>`
+	if err := c.call(ctx, address, body, &response); err != nil {
+		return nil, fmt.Errorf("could not get presets: %w", err)
+	}
+
+	presets := make([]Preset, 0, len(response.Presets))
+	for _, preset := range response.Presets {
+		presets = append(presets, Preset{Token: preset.Token, Name: preset.Name})
+	}
+	return presets, nil
+}
+
+// GotoPreset moves the camera to a preset position, returning once the device has accepted the request
+func (c *Client) GotoPreset(ctx context.Context, presetToken string) error {
+	address, profileToken, err := c.ptzTarget(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := `<GotoPreset xmlns="` + ptzNamespace + `">` +
+		`<ProfileToken>` + escape(profileToken) + `</ProfileToken>` +
+		`<PresetToken>` + escape(presetToken) + `</PresetToken>` +
+		`</GotoPreset>`
+
+	if err := c.call(ctx, address, body, nil); err != nil {
+		return fmt.Errorf("could not go to preset: %w", err)
+	}
+	return nil
+}
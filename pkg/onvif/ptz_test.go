@@ -0,0 +1,323 @@
+package onvif
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testUsername = "admin"
+	testPassword = "secret"
+)
+
+type requestEnvelope struct {
+	Header struct {
+		Security struct {
+			UsernameToken struct {
+				Username string `xml:"Username"`
+				Password string `xml:"Password"`
+				Nonce    string `xml:"Nonce"`
+				Created  string `xml:"Created"`
+			} `xml:"UsernameToken"`
+		} `xml:"Security"`
+	} `xml:"Header"`
+	Body struct {
+		Content []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// fakeDevice is an ONVIF device that answers the requests of a Client with canned responses. Requests without valid
+// credentials are answered with a NotAuthorized fault.
+type fakeDevice struct {
+	t      *testing.T
+	server *httptest.Server
+	// Responses by action, such as GetProfiles, as the body of the response envelope
+	responses map[string]string
+
+	mu       sync.Mutex
+	requests map[string][]string
+}
+
+func newFakeDevice(t *testing.T, responses map[string]string) *fakeDevice {
+	t.Helper()
+
+	device := &fakeDevice{t: t, responses: responses, requests: make(map[string][]string)}
+	device.server = httptest.NewServer(http.HandlerFunc(device.handle))
+	t.Cleanup(device.server.Close)
+
+	if _, ok := responses["GetCapabilities"]; !ok {
+		responses["GetCapabilities"] = `<GetCapabilitiesResponse><Capabilities>` +
+			`<Device><XAddr>` + device.server.URL + `/device</XAddr></Device>` +
+			`<Media><XAddr>` + device.server.URL + `/media</XAddr></Media>` +
+			`<PTZ><XAddr>` + device.server.URL + `/ptz</XAddr></PTZ>` +
+			`</Capabilities></GetCapabilitiesResponse>`
+	}
+
+	return device
+}
+
+func (d *fakeDevice) client() *Client {
+	return NewClient(d.server.URL+"/device", testUsername, testPassword)
+}
+
+func (d *fakeDevice) handle(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		d.t.Errorf("could not read request: %v", err)
+		return
+	}
+
+	var envelope requestEnvelope
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		d.t.Errorf("could not parse request %s: %v", data, err)
+		return
+	}
+
+	body := string(envelope.Body.Content)
+	action := body[1:strings.IndexAny(body, " />")]
+
+	d.mu.Lock()
+	d.requests[action] = append(d.requests[action], body)
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/soap+xml")
+	if !validToken(envelope) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeEnvelope(w, `<s:Fault><s:Code><s:Value>s:Sender</s:Value>`+
+			`<s:Subcode><s:Value>ter:NotAuthorized</s:Value></s:Subcode></s:Code>`+
+			`<s:Reason><s:Text xml:lang="en">Sender not authorized</s:Text></s:Reason></s:Fault>`)
+		return
+	}
+
+	response, ok := d.responses[action]
+	if !ok {
+		d.t.Errorf("unexpected %s request", action)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeEnvelope(w, response)
+}
+
+// lastRequest returns the body of the last request with the given action
+func (d *fakeDevice) lastRequest(action string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	requests := d.requests[action]
+	if len(requests) == 0 {
+		d.t.Fatalf("no %s request was sent", action)
+	}
+	return requests[len(requests)-1]
+}
+
+func (d *fakeDevice) requestCount(action string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.requests[action])
+}
+
+func writeEnvelope(w io.Writer, body string) {
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>%s</s:Body></s:Envelope>`, body)
+}
+
+// validToken checks the UsernameToken of a request the way devices do, with Base64(SHA1(nonce + created + password))
+func validToken(envelope requestEnvelope) bool {
+	token := envelope.Header.Security.UsernameToken
+	if token.Username != testUsername {
+		return false
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(token.Nonce)
+	if err != nil || len(nonce) == 0 {
+		return false
+	}
+	if _, err := time.Parse(time.RFC3339, token.Created); err != nil {
+		return false
+	}
+
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(token.Created))
+	hash.Write([]byte(testPassword))
+	return token.Password == base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+const ptzProfilesResponse = `<GetProfilesResponse xmlns:tt="http://www.onvif.org/ver10/schema">` +
+	`<Profiles token="main"><Name>main</Name></Profiles>` +
+	`<Profiles token="ptz"><Name>ptz</Name><PTZConfiguration token="ptzconfig"/></Profiles>` +
+	`</GetProfilesResponse>`
+
+func newPTZDevice(t *testing.T) *fakeDevice {
+	return newFakeDevice(t, map[string]string{
+		"GetProfiles":    ptzProfilesResponse,
+		"ContinuousMove": `<ContinuousMoveResponse/>`,
+		"Stop":           `<StopResponse/>`,
+		"GotoPreset":     `<GotoPresetResponse/>`,
+		"GetPresets": `<GetPresetsResponse>` +
+			`<Preset token="1"><Name>door</Name></Preset><Preset token="2"><Name>yard</Name></Preset>` +
+			`</GetPresetsResponse>`,
+	})
+}
+
+func TestContinuousMove(t *testing.T) {
+	tests := []struct {
+		name     string
+		velocity Velocity
+		timeout  time.Duration
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "with timeout",
+			velocity: Velocity{Pan: 0.5, Tilt: -0.25, Zoom: 0},
+			timeout:  1500 * time.Millisecond,
+			want: []string{
+				`<ProfileToken>ptz</ProfileToken>`,
+				`<PanTilt xmlns="` + schemaNamespace + `" x="0.5" y="-0.25"/>`,
+				`<Zoom xmlns="` + schemaNamespace + `" x="0"/>`,
+				`<Timeout>PT1.5S</Timeout>`,
+			},
+		},
+		{
+			name:     "until stopped",
+			velocity: Velocity{Zoom: 1},
+			want:     []string{`<Zoom xmlns="` + schemaNamespace + `" x="1"/>`},
+		},
+		{
+			name:     "velocity out of range",
+			velocity: Velocity{Pan: 1.5},
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := newPTZDevice(t)
+
+			err := device.client().ContinuousMove(context.Background(), test.velocity, test.timeout)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if count := device.requestCount("ContinuousMove"); count != 0 {
+					t.Errorf("expected no request to be sent, got %d", count)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			body := device.lastRequest("ContinuousMove")
+			for _, want := range test.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("expected request %s to contain %s", body, want)
+				}
+			}
+			if test.timeout == 0 && strings.Contains(body, "<Timeout>") {
+				t.Errorf("expected request %s to have no timeout", body)
+			}
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+	device := newPTZDevice(t)
+
+	if err := device.client().Stop(context.Background(), true, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	body := device.lastRequest("Stop")
+	for _, want := range []string{`<ProfileToken>ptz</ProfileToken>`, `<PanTilt>true</PanTilt>`, `<Zoom>false</Zoom>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected request %s to contain %s", body, want)
+		}
+	}
+}
+
+func TestGotoPreset(t *testing.T) {
+	device := newPTZDevice(t)
+	client := device.client()
+
+	if err := client.GotoPreset(context.Background(), "<2>"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	body := device.lastRequest("GotoPreset")
+	for _, want := range []string{`<ProfileToken>ptz</ProfileToken>`, `<PresetToken>&lt;2&gt;</PresetToken>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected request %s to contain %s", body, want)
+		}
+	}
+
+	presets, err := client.GetPresets(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(presets) != 2 || presets[0] != (Preset{"1", "door"}) || presets[1] != (Preset{"2", "yard"}) {
+		t.Errorf("unexpected presets %+v", presets)
+	}
+
+	// The capabilities and the profile are only looked up once
+	if count := device.requestCount("GetCapabilities"); count != 1 {
+		t.Errorf("expected capabilities to be requested once, got %d", count)
+	}
+	if count := device.requestCount("GetProfiles"); count != 1 {
+		t.Errorf("expected profiles to be requested once, got %d", count)
+	}
+}
+
+func TestPTZUnauthorized(t *testing.T) {
+	device := newPTZDevice(t)
+	client := NewClient(device.server.URL+"/device", testUsername, "wrong")
+
+	if err := client.Stop(context.Background(), true, true); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestPTZNotSupported(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]string
+	}{
+		{
+			name: "no ptz profile",
+			responses: map[string]string{
+				"GetProfiles": `<GetProfilesResponse><Profiles token="main"><Name>main</Name></Profiles></GetProfilesResponse>`,
+			},
+		},
+		{
+			name: "no ptz service",
+			responses: map[string]string{
+				"GetCapabilities": `<GetCapabilitiesResponse><Capabilities>` +
+					`<Media><XAddr>http://127.0.0.1/media</XAddr></Media>` +
+					`</Capabilities></GetCapabilitiesResponse>`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := newFakeDevice(t, test.responses)
+
+			err := device.client().ContinuousMove(context.Background(), Velocity{Pan: 1}, 0)
+			if !errors.Is(err, ErrPTZNotSupported) {
+				t.Fatalf("expected ErrPTZNotSupported, got %v", err)
+			}
+		})
+	}
+}
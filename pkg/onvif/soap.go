@@ -0,0 +1,157 @@
+package onvif
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	deviceNamespace = "http://www.onvif.org/ver10/device/wsdl"
+	mediaNamespace  = "http://www.onvif.org/ver10/media/wsdl"
+	ptzNamespace    = "http://www.onvif.org/ver20/ptz/wsdl"
+	schemaNamespace = "http://www.onvif.org/ver10/schema"
+)
+
+// maxResponseSize limits how much of a device's response is read
+const maxResponseSize = 1 << 20
+
+var ErrUnauthorized = errors.New("device rejected the credentials")
+
+// Fault is a SOAP fault returned by a device
+type Fault struct {
+	Code    string
+	Subcode string
+	Reason  string
+}
+
+func (f *Fault) Error() string {
+	if f.Subcode != "" {
+		return fmt.Sprintf("device returned fault %s (%s): %s", f.Code, f.Subcode, f.Reason)
+	}
+	return fmt.Sprintf("device returned fault %s: %s", f.Code, f.Reason)
+}
+
+type responseEnvelope struct {
+	Body struct {
+		Fault *struct {
+			Code struct {
+				Value   string `xml:"Value"`
+				Subcode struct {
+					Value string `xml:"Value"`
+				} `xml:"Subcode"`
+			} `xml:"Code"`
+			Reason struct {
+				Text string `xml:"Text"`
+			} `xml:"Reason"`
+		} `xml:"Fault"`
+		Content []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// escape returns text with the characters that are special in XML escaped
+func escape(text string) string {
+	var escaped strings.Builder
+	// writes to a strings.Builder never fail
+	_ = xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// securityHeader builds a WS-Security UsernameToken header with a password digest, as required by ONVIF devices
+func securityHeader(username string, password string, now time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	created := now.UTC().Format("2006-01-02T15:04:05.000Z")
+
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(created))
+	hash.Write([]byte(password))
+	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	return fmt.Sprintf(`<Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">`+
+		`<UsernameToken>`+
+		`<Username>%s</Username>`+
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">%s</Password>`+
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-soap-message-security-1.0#Base64Binary">%s</Nonce>`+
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">%s</Created>`+
+		`</UsernameToken>`+
+		`</Security>`,
+		escape(username), digest, base64.StdEncoding.EncodeToString(nonce), created), nil
+}
+
+// call sends a SOAP request with the given body to a service of the device, decoding the response's body into
+// response unless it's nil
+func (c *Client) call(ctx context.Context, address string, body string, response any) error {
+	header := ""
+	if c.username != "" {
+		var err error
+		if header, err = securityHeader(c.username, c.password, time.Now()); err != nil {
+			return fmt.Errorf("could not create security header: %w", err)
+		}
+	}
+
+	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		`<s:Header>` + header + `</s:Header>` +
+		`<s:Body>` + body + `</s:Body>` +
+		`</s:Envelope>`
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, address, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("could not reach device: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("could not read device response: %w", err)
+	}
+
+	var parsed responseEnvelope
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return fmt.Errorf("could not parse device response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if fault := parsed.Body.Fault; fault != nil {
+		// Devices report bad credentials as a fault instead of with the status code
+		if strings.HasSuffix(fault.Code.Subcode.Value, "NotAuthorized") {
+			return ErrUnauthorized
+		}
+		return &Fault{Code: fault.Code.Value, Subcode: fault.Code.Subcode.Value, Reason: fault.Reason.Text}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("device responded with status %d", resp.StatusCode)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	if err := xml.NewDecoder(bytes.NewReader(parsed.Body.Content)).Decode(response); err != nil {
+		return fmt.Errorf("could not parse device response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package recording
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindContinuous Kind = "continuous"
+	KindEvent      Kind = "event"
+)
+
+// File describes a recording stored on disk
+type File struct {
+	Kind Kind   `json:"kind"`
+	Name string `json:"name"`
+	Path string `json:"-"`
+	// Time of the first frame, and of the last write to the file
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Size  int64     `json:"size"`
+}
+
+// List returns the recordings in a recorder's directory, sorted by their start time. A missing directory has no
+// recordings.
+func List(directory string) ([]File, error) {
+	var files []File
+
+	for kind, folder := range map[Kind]string{KindContinuous: continuousFolder, KindEvent: eventsFolder} {
+		entries, err := os.ReadDir(filepath.Join(directory, folder))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			file, ok := parseFile(kind, filepath.Join(directory, folder), entry)
+			if ok {
+				files = append(files, file)
+			}
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Start.Before(files[j].Start)
+	})
+
+	return files, nil
+}
+
+func parseFile(kind Kind, folder string, entry fs.DirEntry) (File, bool) {
+	name := entry.Name()
+	if entry.IsDir() || !strings.HasSuffix(name, fileExtension) || len(name) < len(fileTimeLayout) {
+		return File{}, false
+	}
+
+	start, err := time.Parse(fileTimeLayout, name[:len(fileTimeLayout)])
+	if err != nil {
+		return File{}, false
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return File{}, false
+	}
+
+	return File{
+		Kind:  kind,
+		Name:  name,
+		Path:  filepath.Join(folder, name),
+		Start: start,
+		End:   info.ModTime(),
+		Size:  info.Size(),
+	}, true
+}
@@ -0,0 +1,369 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	continuousFolder = "continuous"
+	eventsFolder     = "events"
+
+	// fileTimeLayout names recordings after the time of their first frame
+	fileTimeLayout = "20060102T150405.000Z"
+	fileExtension  = ".webm"
+
+	// frameBufferSize is how many frames may be waiting to be written before new ones are dropped
+	frameBufferSize = 256
+)
+
+var ErrEventNotFound = errors.New("event recording not found")
+
+// Frame is a frame of VP8 encoded video
+type Frame struct {
+	Data     []byte
+	KeyFrame bool
+	// Time the frame was captured at
+	Time time.Time
+}
+
+type Config struct {
+	// Recordings are stored in the continuous and events folders inside this directory
+	Directory string
+	// Whether to record all the footage, besides event recordings
+	Continuous bool
+	// Continuous recordings are split into files of roughly this duration
+	ChunkDuration time.Duration
+	// Maximum footage kept in memory to be included at the start of event recordings
+	PreRoll time.Duration
+	// Event recordings are stopped automatically after this long, unless it's zero
+	MaxEventDuration time.Duration
+}
+
+// EventRecording describes an event recording, which covers from its pre-roll until it's stopped
+type EventRecording struct {
+	Id      int       `json:"id"`
+	Started time.Time `json:"started"`
+	// File is empty until the recording's first key frame is written
+	File    string     `json:"file,omitempty"`
+	Stopped *time.Time `json:"stopped,omitempty"`
+}
+
+type eventOutput struct {
+	info   EventRecording
+	writer *webmWriter
+}
+
+// Recorder writes the frames of a camera to disk, both continuously in chunks and on demand for events. Files always
+// start with a key frame.
+type Recorder struct {
+	config Config
+	logger *zap.SugaredLogger
+
+	frames  chan Frame
+	dropped atomic.Bool
+
+	mu sync.Mutex
+	// Frames from a key frame at least PreRoll old until the latest one
+	ring       []Frame
+	continuous *webmWriter
+	// Path of every file currently being written
+	activeFiles  map[string]bool
+	events       map[int]*eventOutput
+	eventCounter int
+}
+
+func NewRecorder(config Config, logger *zap.SugaredLogger) (*Recorder, error) {
+	if config.Continuous && config.ChunkDuration <= 0 {
+		return nil, fmt.Errorf("chunk duration must be positive")
+	}
+
+	for _, folder := range []string{continuousFolder, eventsFolder} {
+		if err := os.MkdirAll(filepath.Join(config.Directory, folder), 0755); err != nil {
+			return nil, fmt.Errorf("could not create recordings directory: %w", err)
+		}
+	}
+
+	return &Recorder{
+		config:      config,
+		logger:      logger.Named("Recorder").With("directory", config.Directory),
+		frames:      make(chan Frame, frameBufferSize),
+		activeFiles: make(map[string]bool),
+		events:      make(map[int]*eventOutput),
+	}, nil
+}
+
+// HandleFrame queues a frame to be recorded. It never blocks, so it can be called from the pipeline's streaming
+// thread; if the recorder falls behind frames are dropped until the next key frame.
+func (r *Recorder) HandleFrame(frame Frame) {
+	select {
+	case r.frames <- frame:
+	default:
+		if !r.dropped.Swap(true) {
+			r.logger.Warnw("recorder is falling behind, dropping frames")
+		}
+	}
+}
+
+// Run writes queued frames until ctx is canceled, closing every open file afterwards
+func (r *Recorder) Run(ctx context.Context) {
+	skipUntilKeyFrame := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.closeAll()
+			return
+		case frame := <-r.frames:
+			if r.dropped.Swap(false) {
+				skipUntilKeyFrame = true
+			}
+			if skipUntilKeyFrame {
+				if !frame.KeyFrame {
+					continue
+				}
+				skipUntilKeyFrame = false
+			}
+
+			r.writeFrame(frame)
+		}
+	}
+}
+
+func (r *Recorder) writeFrame(frame Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addToRing(frame)
+
+	if r.config.Continuous {
+		r.writeContinuous(frame)
+	}
+
+	for id, event := range r.events {
+		if event.writer == nil {
+			if !frame.KeyFrame {
+				continue
+			}
+			if err := r.openEvent(event, []Frame{frame}); err != nil {
+				r.logger.Errorw("could not create event recording", "event", id, "err", err)
+				delete(r.events, id)
+				continue
+			}
+		} else if err := event.writer.WriteFrame(frame.Time, frame.Data, frame.KeyFrame); err != nil {
+			r.logger.Errorw("could not write event recording", "event", id, "err", err)
+		}
+
+		if r.config.MaxEventDuration != 0 && frame.Time.Sub(event.info.Started) >= r.config.MaxEventDuration {
+			r.logger.Infow("event recording reached its maximum duration", "event", id)
+			if _, err := r.stopEventLocked(id); err != nil {
+				r.logger.Errorw("could not stop event recording", "event", id, "err", err)
+			}
+		}
+	}
+}
+
+func (r *Recorder) addToRing(frame Frame) {
+	if len(r.ring) == 0 && !frame.KeyFrame {
+		return
+	}
+	r.ring = append(r.ring, frame)
+
+	// Drop everything before the newest key frame that's still old enough to cover the pre-roll
+	cutoff := frame.Time.Add(-r.config.PreRoll)
+	start := 0
+	for i := len(r.ring) - 1; i > 0; i-- {
+		if r.ring[i].KeyFrame && !r.ring[i].Time.After(cutoff) {
+			start = i
+			break
+		}
+	}
+	if start > 0 {
+		r.ring = append([]Frame(nil), r.ring[start:]...)
+	}
+}
+
+// preRollFrames returns the buffered frames covering at least preRoll, starting with a key frame
+func (r *Recorder) preRollFrames(preRoll time.Duration) []Frame {
+	if len(r.ring) == 0 {
+		return nil
+	}
+
+	cutoff := r.ring[len(r.ring)-1].Time.Add(-preRoll)
+	for i := len(r.ring) - 1; i > 0; i-- {
+		if r.ring[i].KeyFrame && !r.ring[i].Time.After(cutoff) {
+			return r.ring[i:]
+		}
+	}
+	return r.ring
+}
+
+func (r *Recorder) writeContinuous(frame Frame) {
+	if r.continuous != nil && frame.KeyFrame && frame.Time.Sub(r.continuous.start) >= r.config.ChunkDuration {
+		r.closeWriter(r.continuous)
+		r.continuous = nil
+	}
+
+	if r.continuous == nil {
+		if !frame.KeyFrame {
+			return
+		}
+
+		writer, err := r.createWriter(continuousFolder, "", frame)
+		if err != nil {
+			r.logger.Errorw("could not create recording chunk", "err", err)
+			return
+		}
+		r.continuous = writer
+	}
+
+	if err := r.continuous.WriteFrame(frame.Time, frame.Data, frame.KeyFrame); err != nil {
+		r.logger.Errorw("could not write recording chunk", "err", err)
+	}
+}
+
+// createWriter creates a file in the given folder for a recording starting with a key frame. The suffix tells apart
+// recordings starting with the same frame.
+func (r *Recorder) createWriter(folder string, suffix string, first Frame) (*webmWriter, error) {
+	width, height, err := vp8Dimensions(first.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(r.config.Directory, folder, first.Time.UTC().Format(fileTimeLayout)+suffix+fileExtension)
+	writer, err := createWebm(path, first.Time, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	r.activeFiles[writer.file.Name()] = true
+	r.logger.Debugw("started recording file", "file", path)
+	return writer, nil
+}
+
+func (r *Recorder) closeWriter(writer *webmWriter) {
+	delete(r.activeFiles, writer.file.Name())
+	if err := writer.Close(); err != nil {
+		r.logger.Errorw("could not finish recording file", "file", writer.file.Name(), "err", err)
+		return
+	}
+	r.logger.Debugw("finished recording file", "file", writer.file.Name(), "duration", writer.Duration())
+}
+
+func (r *Recorder) openEvent(event *eventOutput, frames []Frame) error {
+	writer, err := r.createWriter(eventsFolder, fmt.Sprintf("_%d", event.info.Id), frames[0])
+	if err != nil {
+		return err
+	}
+
+	for _, frame := range frames {
+		if err := writer.WriteFrame(frame.Time, frame.Data, frame.KeyFrame); err != nil {
+			r.closeWriter(writer)
+			return err
+		}
+	}
+
+	event.writer = writer
+	event.info.File = filepath.Base(writer.file.Name())
+	return nil
+}
+
+// StartEvent starts an event recording that includes up to preRoll of footage from before it was started, limited by
+// the configured pre-roll.
+func (r *Recorder) StartEvent(preRoll time.Duration) (EventRecording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if preRoll < 0 {
+		return EventRecording{}, fmt.Errorf("invalid pre-roll %s", preRoll)
+	}
+	if preRoll > r.config.PreRoll {
+		preRoll = r.config.PreRoll
+	}
+
+	event := &eventOutput{
+		info: EventRecording{
+			Id:      r.eventCounter,
+			Started: time.Now(),
+		},
+	}
+	r.eventCounter++
+
+	if frames := r.preRollFrames(preRoll); len(frames) > 0 {
+		if err := r.openEvent(event, frames); err != nil {
+			return EventRecording{}, err
+		}
+	}
+
+	r.events[event.info.Id] = event
+	r.logger.Infow("started event recording", "event", event.info.Id, "pre-roll", preRoll)
+
+	return event.info, nil
+}
+
+// StopEvent stops an event recording, returning its final description
+func (r *Recorder) StopEvent(id int) (EventRecording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stopEventLocked(id)
+}
+
+func (r *Recorder) stopEventLocked(id int) (EventRecording, error) {
+	event, ok := r.events[id]
+	if !ok {
+		return EventRecording{}, ErrEventNotFound
+	}
+	delete(r.events, id)
+
+	stopped := time.Now()
+	event.info.Stopped = &stopped
+	if event.writer != nil {
+		r.closeWriter(event.writer)
+	}
+
+	r.logger.Infow("stopped event recording", "event", id)
+	return event.info, nil
+}
+
+// Events returns the event recordings in progress
+func (r *Recorder) Events() []EventRecording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]EventRecording, 0, len(r.events))
+	for _, event := range r.events {
+		events = append(events, event.info)
+	}
+	return events
+}
+
+// Active returns whether a file is still being written by the recorder
+func (r *Recorder) Active(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.activeFiles[path]
+}
+
+func (r *Recorder) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.continuous != nil {
+		r.closeWriter(r.continuous)
+		r.continuous = nil
+	}
+	for id := range r.events {
+		if _, err := r.stopEventLocked(id); err != nil {
+			r.logger.Errorw("could not stop event recording", "event", id, "err", err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package recording
+
+import (
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+var recordingStart = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// testFrames are a second apart, with a key frame every two seconds after a leading delta frame
+func testFrames() []Frame {
+	var frames []Frame
+	for i := -1; i < 6; i++ {
+		frame := Frame{Time: recordingStart.Add(time.Duration(i) * time.Second)}
+		if i >= 0 && i%2 == 0 {
+			frame.KeyFrame = true
+			frame.Data = vp8Frame(320, 240, byte(i))
+		} else {
+			frame.Data = vp8Frame(0, 0, byte(i))
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func frameOffsets(frames []Frame) []time.Duration {
+	offsets := make([]time.Duration, 0, len(frames))
+	for _, frame := range frames {
+		offsets = append(offsets, frame.Time.Sub(recordingStart))
+	}
+	return offsets
+}
+
+func equalOffsets(a []time.Duration, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRecorderRing(t *testing.T) {
+	tests := []struct {
+		name    string
+		preRoll time.Duration
+		frames  int
+		ring    []time.Duration
+	}{
+		{name: "leading delta frame", preRoll: 2 * time.Second, frames: 1},
+		{name: "single key frame", preRoll: 2 * time.Second, frames: 2, ring: []time.Duration{0}},
+		{
+			name:    "not yet trimmed",
+			preRoll: 2 * time.Second,
+			frames:  4,
+			ring:    []time.Duration{0, time.Second, 2 * time.Second},
+		},
+		{
+			name:    "trimmed to the last key frame covering the pre-roll",
+			preRoll: 2 * time.Second,
+			frames:  7,
+			ring:    []time.Duration{2 * time.Second, 3 * time.Second, 4 * time.Second, 5 * time.Second},
+		},
+		{
+			name:    "no pre-roll",
+			preRoll: 0,
+			frames:  7,
+			ring:    []time.Duration{4 * time.Second, 5 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &Recorder{config: Config{PreRoll: test.preRoll}}
+			for _, frame := range testFrames()[:test.frames] {
+				r.addToRing(frame)
+			}
+
+			if offsets := frameOffsets(r.ring); !equalOffsets(offsets, test.ring) {
+				t.Errorf("expected ring %v, got %v", test.ring, offsets)
+			}
+		})
+	}
+}
+
+func TestRecorderPreRollFrames(t *testing.T) {
+	r := &Recorder{config: Config{PreRoll: 4 * time.Second}}
+	if frames := r.preRollFrames(time.Second); frames != nil {
+		t.Errorf("expected no frames before the first key frame, got %d", len(frames))
+	}
+
+	for _, frame := range testFrames() {
+		r.addToRing(frame)
+	}
+
+	tests := []struct {
+		preRoll time.Duration
+		frames  []time.Duration
+	}{
+		{0, []time.Duration{4 * time.Second, 5 * time.Second}},
+		{time.Second, []time.Duration{4 * time.Second, 5 * time.Second}},
+		{2 * time.Second, []time.Duration{2 * time.Second, 3 * time.Second, 4 * time.Second, 5 * time.Second}},
+		// more than is buffered returns everything, starting with its key frame
+		{time.Minute, []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second, 5 * time.Second}},
+	}
+
+	for _, test := range tests {
+		if offsets := frameOffsets(r.preRollFrames(test.preRoll)); !equalOffsets(offsets, test.frames) {
+			t.Errorf("pre-roll %s: expected frames %v, got %v", test.preRoll, test.frames, offsets)
+		}
+	}
+}
+
+func TestRecorderFiles(t *testing.T) {
+	directory := t.TempDir()
+	r, err := NewRecorder(Config{
+		Directory:     directory,
+		Continuous:    true,
+		ChunkDuration: 2 * time.Second,
+		PreRoll:       2 * time.Second,
+	}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, frame := range testFrames() {
+		r.writeFrame(frame)
+	}
+
+	// limited to the configured pre-roll
+	event, err := r.StartEvent(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventPath := filepath.Join(directory, eventsFolder, event.File)
+	if event.File != "20230501T120002.000Z_0.webm" {
+		t.Errorf("expected the event to start at the key frame two seconds before, got %q", event.File)
+	}
+	if !r.Active(eventPath) {
+		t.Errorf("expected the event recording to be active")
+	}
+
+	r.writeFrame(Frame{Time: recordingStart.Add(6 * time.Second), Data: vp8Frame(0, 0, 6)})
+
+	stopped, err := r.StopEvent(event.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stopped.Stopped == nil || stopped.File != event.File {
+		t.Errorf("unexpected stopped event %+v", stopped)
+	}
+	if r.Active(eventPath) {
+		t.Errorf("expected the event recording to be finished")
+	}
+	if _, err := r.StopEvent(event.Id); err != ErrEventNotFound {
+		t.Errorf("expected stopping the event again to fail, got %v", err)
+	}
+
+	r.closeAll()
+
+	files, err := List(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, file := range files {
+		names = append(names, string(file.Kind)+"/"+file.Name)
+	}
+	// the event and the second chunk start with the same frame, so their order isn't defined
+	sort.Strings(names)
+	expected := []string{
+		"continuous/20230501T120000.000Z.webm",
+		"continuous/20230501T120002.000Z.webm",
+		"continuous/20230501T120004.000Z.webm",
+		"event/20230501T120002.000Z_0.webm",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("expected files %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected files %v, got %v", expected, names)
+		}
+	}
+
+	data, err := os.ReadFile(eventPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := parseElements(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segment, err := parseElements(top[1].payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := 0
+	for _, e := range segment {
+		if e.id != idCluster {
+			continue
+		}
+		clusterElements, err := parseElements(e.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ce := range clusterElements {
+			if ce.id == idSimpleBlock {
+				blocks++
+			}
+		}
+	}
+	// the pre-roll from 2s to 5s, and the frame at 6s
+	if blocks != 5 {
+		t.Errorf("expected 5 frames in the event recording, got %d", blocks)
+	}
+}
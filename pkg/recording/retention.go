@@ -0,0 +1,86 @@
+package recording
+
+import (
+	"context"
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+	"os"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy limits how much footage is kept. Zero values disable each limit.
+type RetentionPolicy struct {
+	MaxAge time.Duration
+	// Maximum size in bytes of all recordings together
+	MaxSize int64
+}
+
+// Enforce deletes the recordings in the given directories that are older than the maximum age, and then the oldest
+// ones until they fit in the maximum size. Files for which active returns true are never deleted.
+func (p RetentionPolicy) Enforce(directories []string, active func(path string) bool) ([]File, error) {
+	var files []File
+	for _, directory := range directories {
+		directoryFiles, err := List(directory)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, directoryFiles...)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].End.Before(files[j].End)
+	})
+
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.Size
+	}
+
+	var deleted []File
+	var result *multierror.Error
+	for _, file := range files {
+		expired := p.MaxAge != 0 && time.Since(file.End) > p.MaxAge
+		overSize := p.MaxSize != 0 && totalSize > p.MaxSize
+		if !expired && !overSize {
+			// files are sorted from oldest to newest, so the rest can be kept
+			break
+		}
+		if active(file.Path) {
+			continue
+		}
+
+		if err := os.Remove(file.Path); err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		totalSize -= file.Size
+		deleted = append(deleted, file)
+	}
+
+	return deleted, result.ErrorOrNil()
+}
+
+// Run enforces the policy every interval until ctx is canceled
+func (p RetentionPolicy) Run(ctx context.Context, interval time.Duration, directories func() []string, active func(path string) bool, logger *zap.SugaredLogger) {
+	logger = logger.Named("Retention")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := p.Enforce(directories(), active)
+		if err != nil {
+			logger.Errorw("could not delete old recordings", "err", err)
+		}
+		for _, file := range deleted {
+			logger.Infow("deleted recording", "file", file.Path, "size", file.Size)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package recording
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyEnforce(t *testing.T) {
+	now := time.Now()
+
+	// ten bytes each, finished the given time ago
+	files := []struct {
+		folder string
+		name   string
+		age    time.Duration
+	}{
+		{continuousFolder, "20230501T120000.000Z.webm", 4 * time.Hour},
+		{eventsFolder, "20230501T130000.000Z_0.webm", 3 * time.Hour},
+		{continuousFolder, "20230501T140000.000Z.webm", 2 * time.Hour},
+		{continuousFolder, "20230501T150000.000Z.webm", time.Minute},
+	}
+
+	tests := []struct {
+		name    string
+		policy  RetentionPolicy
+		active  string
+		deleted []string
+	}{
+		{name: "no limits", policy: RetentionPolicy{}},
+		{
+			name:    "max age",
+			policy:  RetentionPolicy{MaxAge: 150 * time.Minute},
+			deleted: []string{"20230501T120000.000Z.webm", "20230501T130000.000Z_0.webm"},
+		},
+		{
+			name:    "max size",
+			policy:  RetentionPolicy{MaxSize: 25},
+			deleted: []string{"20230501T120000.000Z.webm", "20230501T130000.000Z_0.webm"},
+		},
+		{
+			name:    "max size fits",
+			policy:  RetentionPolicy{MaxSize: 40},
+			deleted: nil,
+		},
+		{
+			name:    "both limits",
+			policy:  RetentionPolicy{MaxAge: 210 * time.Minute, MaxSize: 25},
+			deleted: []string{"20230501T120000.000Z.webm", "20230501T130000.000Z_0.webm"},
+		},
+		{
+			name:    "active files are kept",
+			policy:  RetentionPolicy{MaxSize: 25},
+			active:  "20230501T120000.000Z.webm",
+			deleted: []string{"20230501T130000.000Z_0.webm", "20230501T140000.000Z.webm"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			for _, folder := range []string{continuousFolder, eventsFolder} {
+				if err := os.MkdirAll(filepath.Join(directory, folder), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range files {
+				path := filepath.Join(directory, file.folder, file.name)
+				if err := os.WriteFile(path, make([]byte, 10), 0644); err != nil {
+					t.Fatal(err)
+				}
+				modTime := now.Add(-file.age)
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			active := func(path string) bool {
+				return filepath.Base(path) == test.active
+			}
+			deleted, err := test.policy.Enforce([]string{directory}, active)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var deletedNames []string
+			for _, file := range deleted {
+				deletedNames = append(deletedNames, file.Name)
+				if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+					t.Errorf("expected %s to be removed", file.Name)
+				}
+			}
+			if len(deletedNames) != len(test.deleted) {
+				t.Fatalf("expected %v to be deleted, got %v", test.deleted, deletedNames)
+			}
+			for i := range deletedNames {
+				if deletedNames[i] != test.deleted[i] {
+					t.Fatalf("expected %v to be deleted, got %v", test.deleted, deletedNames)
+				}
+			}
+
+			remaining, err := List(directory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(remaining)+len(deleted) != len(files) {
+				t.Errorf("expected %d files to remain, got %d", len(files)-len(deleted), len(remaining))
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyEnforceMissingDirectory(t *testing.T) {
+	deleted, err := RetentionPolicy{MaxSize: 1}.Enforce([]string{filepath.Join(t.TempDir(), "missing")}, func(string) bool {
+		return false
+	})
+	if err != nil || len(deleted) != 0 {
+		t.Errorf("expected nothing to be deleted from a missing directory, got %v and %v", deleted, err)
+	}
+}
+
+func TestListSkipsUnknownFiles(t *testing.T) {
+	directory := t.TempDir()
+	folder := filepath.Join(directory, continuousFolder)
+	if err := os.MkdirAll(filepath.Join(folder, "20230501T120000.000Z.webm"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"20230501T130000.000Z.webm", "notes.txt", "recording.webm", "20230501T110000.000Z.webm"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(folder, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := List(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []string
+	for _, file := range files {
+		listed = append(listed, file.Name)
+	}
+	expected := []string{"20230501T110000.000Z.webm", "20230501T130000.000Z.webm"}
+	if len(listed) != len(expected) || listed[0] != expected[0] || listed[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, listed)
+	}
+}
@@ -0,0 +1,346 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Matroska element ids, see https://www.matroska.org/technical/elements.html
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment       = 0x18538067
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idDateUTC       = 0x4461
+	idMuxingApp     = 0x4D80
+	idWritingApp    = 0x5741
+
+	idTracks      = 0x1654AE6B
+	idTrackEntry  = 0xAE
+	idTrackNumber = 0xD7
+	idTrackUID    = 0x73C5
+	idTrackType   = 0x83
+	idCodecID     = 0x86
+	idVideo       = 0xE0
+	idPixelWidth  = 0xB0
+	idPixelHeight = 0xBA
+
+	idCluster     = 0x1F43B675
+	idTimecode    = 0xE7
+	idSimpleBlock = 0xA3
+
+	idCues               = 0x1C53BB6B
+	idCuePoint           = 0xBB
+	idCueTime            = 0xB3
+	idCueTrackPositions  = 0xB7
+	idCueTrack           = 0xF7
+	idCueClusterPosition = 0xF1
+)
+
+const (
+	appName = "camera_streamer"
+	// Timestamps are stored in milliseconds
+	timecodeScale = 1000000
+	// Clusters are cut at every key frame, or once they reach this duration
+	maxClusterDuration = 5 * time.Second
+	// Size placeholder for the segment, which is filled in when the file is closed
+	unknownSize = 0x01FFFFFFFFFFFFFF
+)
+
+// matroskaEpoch is the origin of the DateUTC element
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type cuePoint struct {
+	time     int64
+	position int64
+}
+
+// webmWriter writes a single VP8 video track to a WebM file. Clusters are kept in memory until they're complete, and
+// the segment size, duration and cues are written when the file is closed so it can be seeked.
+type webmWriter struct {
+	file  *os.File
+	start time.Time
+
+	// Position of the segment size and its data, and of the duration's value
+	segmentSizeOffset int64
+	segmentDataOffset int64
+	durationOffset    int64
+	offset            int64
+
+	cluster     bytes.Buffer
+	clusterTime int64
+	lastTime    int64
+	cues        []cuePoint
+}
+
+// createWebm creates a file for a video with the given dimensions, whose first frame is captured at start
+func createWebm(path string, start time.Time, width int, height int) (*webmWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &webmWriter{file: file, start: start, clusterTime: -1}
+
+	header := ebmlElement(idEBML,
+		ebmlUint(idEBMLVersion, 1),
+		ebmlUint(idEBMLReadVersion, 1),
+		ebmlUint(idEBMLMaxIDLength, 4),
+		ebmlUint(idEBMLMaxSizeLength, 8),
+		ebmlString(idDocType, "webm"),
+		ebmlUint(idDocTypeVersion, 2),
+		ebmlUint(idDocTypeReadVersion, 2),
+	)
+
+	var segmentHeader []byte
+	segmentHeader = append(segmentHeader, ebmlID(idSegment)...)
+	w.segmentSizeOffset = int64(len(header) + len(segmentHeader))
+	segmentHeader = append(segmentHeader, ebmlFixedSize(unknownSize)...)
+	w.segmentDataOffset = int64(len(header) + len(segmentHeader))
+
+	// The duration goes first in the info element so its value's offset is easy to find
+	durationElement := ebmlFloat(idDuration, 0)
+	infoPayload := bytes.Join([][]byte{
+		durationElement,
+		ebmlUint(idTimecodeScale, timecodeScale),
+		ebmlInt(idDateUTC, start.Sub(matroskaEpoch).Nanoseconds()),
+		ebmlString(idMuxingApp, appName),
+		ebmlString(idWritingApp, appName),
+	}, nil)
+	info := ebmlElement(idInfo, infoPayload)
+	infoHeaderSize := len(info) - len(infoPayload)
+	w.durationOffset = w.segmentDataOffset + int64(infoHeaderSize+len(durationElement)-8)
+
+	tracks := ebmlElement(idTracks,
+		ebmlElement(idTrackEntry,
+			ebmlUint(idTrackNumber, 1),
+			ebmlUint(idTrackUID, 1),
+			ebmlUint(idTrackType, 1), // video
+			ebmlString(idCodecID, "V_VP8"),
+			ebmlElement(idVideo,
+				ebmlUint(idPixelWidth, uint64(width)),
+				ebmlUint(idPixelHeight, uint64(height)),
+			),
+		),
+	)
+
+	var data []byte
+	data = append(data, header...)
+	data = append(data, segmentHeader...)
+	data = append(data, info...)
+	data = append(data, tracks...)
+
+	if err := w.write(data); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *webmWriter) write(data []byte) error {
+	n, err := w.file.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// WriteFrame adds a frame captured at the given time
+func (w *webmWriter) WriteFrame(frameTime time.Time, data []byte, keyFrame bool) error {
+	timecode := frameTime.Sub(w.start).Milliseconds()
+	// Matroska timestamps can't go backwards within a track
+	if timecode < w.lastTime {
+		timecode = w.lastTime
+	}
+	w.lastTime = timecode
+
+	if w.clusterTime < 0 || keyFrame || timecode-w.clusterTime >= maxClusterDuration.Milliseconds() {
+		if err := w.flushCluster(); err != nil {
+			return err
+		}
+		w.clusterTime = timecode
+		w.cluster.Write(ebmlUint(idTimecode, uint64(timecode)))
+
+		if keyFrame {
+			w.cues = append(w.cues, cuePoint{timecode, w.offset - w.segmentDataOffset})
+		}
+	}
+
+	var flags byte
+	if keyFrame {
+		flags = 0x80
+	}
+
+	block := make([]byte, 0, len(data)+4)
+	block = append(block, 0x81) // track number 1
+	block = binary.BigEndian.AppendUint16(block, uint16(int16(timecode-w.clusterTime)))
+	block = append(block, flags)
+	block = append(block, data...)
+
+	w.cluster.Write(ebmlElement(idSimpleBlock, block))
+	return nil
+}
+
+func (w *webmWriter) flushCluster() error {
+	if w.cluster.Len() == 0 {
+		return nil
+	}
+
+	err := w.write(ebmlElement(idCluster, w.cluster.Bytes()))
+	w.cluster.Reset()
+	return err
+}
+
+// Duration returns the time covered by the frames written so far
+func (w *webmWriter) Duration() time.Duration {
+	return time.Duration(w.lastTime) * time.Millisecond
+}
+
+// Close writes the pending cluster and the cues, and fills in the segment size and duration
+func (w *webmWriter) Close() error {
+	err := w.finish()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *webmWriter) finish() error {
+	if err := w.flushCluster(); err != nil {
+		return err
+	}
+
+	if len(w.cues) > 0 {
+		var points [][]byte
+		for _, cue := range w.cues {
+			points = append(points, ebmlElement(idCuePoint,
+				ebmlUint(idCueTime, uint64(cue.time)),
+				ebmlElement(idCueTrackPositions,
+					ebmlUint(idCueTrack, 1),
+					ebmlUint(idCueClusterPosition, uint64(cue.position)),
+				),
+			))
+		}
+		if err := w.write(ebmlElement(idCues, points...)); err != nil {
+			return err
+		}
+	}
+
+	segmentSize := w.offset - w.segmentDataOffset
+	if _, err := w.file.WriteAt(ebmlFixedSize(uint64(segmentSize)), w.segmentSizeOffset); err != nil {
+		return err
+	}
+
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(float64(w.lastTime)))
+	if _, err := w.file.WriteAt(duration, w.durationOffset); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ebmlID encodes an element id, which already includes its length marker
+func ebmlID(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	default:
+		return []byte{byte(id)}
+	}
+}
+
+// ebmlSize encodes a size with the shortest variable length integer that fits it
+func ebmlSize(size uint64) []byte {
+	length := 1
+	// all ones is reserved for unknown sizes
+	for size >= (1<<(7*length))-1 && length < 8 {
+		length++
+	}
+
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = byte(size)
+		size >>= 8
+	}
+	encoded[0] |= 1 << (8 - length)
+	return encoded
+}
+
+// ebmlFixedSize encodes a size using eight bytes, so it can be overwritten later
+func ebmlFixedSize(size uint64) []byte {
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, size)
+	encoded[0] = 0x01
+	return encoded
+}
+
+func ebmlElement(id uint32, children ...[]byte) []byte {
+	var payload []byte
+	for _, child := range children {
+		payload = append(payload, child...)
+	}
+
+	element := ebmlID(id)
+	element = append(element, ebmlSize(uint64(len(payload)))...)
+	return append(element, payload...)
+}
+
+func ebmlUint(id uint32, value uint64) []byte {
+	var payload []byte
+	for shift := 56; shift > 0; shift -= 8 {
+		if value>>shift != 0 {
+			payload = append(payload, byte(value>>shift))
+		} else if len(payload) > 0 {
+			payload = append(payload, 0)
+		}
+	}
+	payload = append(payload, byte(value))
+	return ebmlElement(id, payload)
+}
+
+func ebmlInt(id uint32, value int64) []byte {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(value))
+	return ebmlElement(id, payload)
+}
+
+func ebmlFloat(id uint32, value float64) []byte {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, math.Float64bits(value))
+	return ebmlElement(id, payload)
+}
+
+func ebmlString(id uint32, value string) []byte {
+	return ebmlElement(id, []byte(value))
+}
+
+// vp8Dimensions reads the frame size from a VP8 key frame's header
+func vp8Dimensions(frame []byte) (int, int, error) {
+	if len(frame) < 10 || frame[0]&1 != 0 {
+		return 0, 0, fmt.Errorf("not a vp8 key frame")
+	}
+	if frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return 0, 0, fmt.Errorf("invalid vp8 start code")
+	}
+
+	width := int(binary.LittleEndian.Uint16(frame[6:8]) & 0x3fff)
+	height := int(binary.LittleEndian.Uint16(frame[8:10]) & 0x3fff)
+	return width, height, nil
+}
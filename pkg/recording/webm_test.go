@@ -0,0 +1,355 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type element struct {
+	id uint32
+	// Position of the element's id, relative to the start of its parent's payload
+	offset  int
+	payload []byte
+}
+
+// parseElements splits data into the elements it contains
+func parseElements(data []byte) ([]element, error) {
+	var elements []element
+	offset := 0
+	for offset < len(data) {
+		id, idLength, err := readVint(data[offset:], true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id at %d: %w", offset, err)
+		}
+		size, sizeLength, err := readVint(data[offset+idLength:], false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size at %d: %w", offset, err)
+		}
+
+		start := offset + idLength + sizeLength
+		if uint64(len(data)-start) < size {
+			return nil, fmt.Errorf("element at %d is %d bytes long, only %d left", offset, size, len(data)-start)
+		}
+		elements = append(elements, element{uint32(id), offset, data[start : start+int(size)]})
+		offset = start + int(size)
+	}
+	return elements, nil
+}
+
+// readVint reads a variable length integer, keeping its length marker for ids
+func readVint(data []byte, keepMarker bool) (uint64, int, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, fmt.Errorf("invalid variable length integer")
+	}
+
+	length := 1
+	for data[0]&(0x80>>(length-1)) == 0 {
+		length++
+	}
+	if len(data) < length {
+		return 0, 0, fmt.Errorf("truncated variable length integer")
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &^= 0x80 >> (length - 1)
+	}
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length, nil
+}
+
+// child returns the payload of the only child of an element with the given id
+func child(t *testing.T, parent []byte, id uint32) []byte {
+	t.Helper()
+
+	elements, err := parseElements(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []byte
+	for _, e := range elements {
+		if e.id == id {
+			if found != nil {
+				t.Fatalf("more than one element %x", id)
+			}
+			found = e.payload
+		}
+	}
+	if found == nil {
+		t.Fatalf("missing element %x", id)
+	}
+	return found
+}
+
+func readUint(payload []byte) uint64 {
+	var value uint64
+	for _, b := range payload {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// vp8Frame builds a frame with a VP8 header of the given dimensions, or a delta frame if they're zero
+func vp8Frame(width uint16, height uint16, payload ...byte) []byte {
+	if width == 0 && height == 0 {
+		return append([]byte{0x01, 0x00, 0x00}, payload...)
+	}
+
+	frame := []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(frame[6:8], width)
+	binary.LittleEndian.PutUint16(frame[8:10], height)
+	return append(frame, payload...)
+}
+
+func TestEbmlSize(t *testing.T) {
+	tests := []struct {
+		size    uint64
+		encoded []byte
+	}{
+		{0, []byte{0x80}},
+		{126, []byte{0xFE}},
+		// 127 would be all ones, which means unknown
+		{127, []byte{0x40, 0x7F}},
+		{16382, []byte{0x7F, 0xFE}},
+		{16383, []byte{0x20, 0x3F, 0xFF}},
+		{1 << 21, []byte{0x10, 0x20, 0x00, 0x00}},
+	}
+
+	for _, test := range tests {
+		if encoded := ebmlSize(test.size); !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("expected size %d to be encoded as %x, got %x", test.size, test.encoded, encoded)
+		}
+	}
+
+	if encoded := ebmlFixedSize(300); !bytes.Equal(encoded, []byte{0x01, 0, 0, 0, 0, 0, 0x01, 0x2C}) {
+		t.Errorf("unexpected fixed size encoding %x", encoded)
+	}
+}
+
+func TestEbmlElements(t *testing.T) {
+	tests := []struct {
+		name    string
+		element []byte
+		encoded []byte
+	}{
+		{"zero uint", ebmlUint(idTrackNumber, 0), []byte{0xD7, 0x81, 0x00}},
+		{"one byte uint", ebmlUint(idTrackNumber, 0xFF), []byte{0xD7, 0x81, 0xFF}},
+		{"uint with inner zeros", ebmlUint(idTrackUID, 0x10000), []byte{0x73, 0xC5, 0x83, 0x01, 0x00, 0x00}},
+		{"int", ebmlInt(idDateUTC, -1), []byte{0x44, 0x61, 0x88, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"float", ebmlFloat(idDuration, 1), []byte{0x44, 0x89, 0x88, 0x3F, 0xF0, 0, 0, 0, 0, 0, 0}},
+		{"string", ebmlString(idDocType, "webm"), []byte{0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'}},
+		{"four byte id", ebmlElement(idCluster, []byte{1}, []byte{2}), []byte{0x1F, 0x43, 0xB6, 0x75, 0x82, 1, 2}},
+		{"three byte id", ebmlElement(idTimecodeScale), []byte{0x2A, 0xD7, 0xB1, 0x80}},
+	}
+
+	for _, test := range tests {
+		if !bytes.Equal(test.element, test.encoded) {
+			t.Errorf("%s: expected %x, got %x", test.name, test.encoded, test.element)
+		}
+	}
+}
+
+func TestVp8Dimensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		width   int
+		height  int
+		wantErr bool
+	}{
+		{name: "key frame", frame: vp8Frame(1280, 720), width: 1280, height: 720},
+		// the upper two bits of each dimension are the scaling mode
+		{name: "scaled", frame: vp8Frame(0xC000|640, 0x4000|480), width: 640, height: 480},
+		{name: "delta frame", frame: vp8Frame(0, 0, make([]byte, 10)...), wantErr: true},
+		{name: "truncated", frame: vp8Frame(1280, 720)[:9], wantErr: true},
+		{name: "bad start code", frame: append([]byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2b}, 0, 5, 0, 5), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			width, height, err := vp8Dimensions(test.frame)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if width != test.width || height != test.height {
+				t.Errorf("expected %dx%d, got %dx%d", test.width, test.height, width, height)
+			}
+		})
+	}
+}
+
+func TestCreateWebmExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "existing.webm")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createWebm(path, time.Now(), 1, 1); err == nil {
+		t.Fatal("expected an existing file not to be overwritten")
+	}
+}
+
+func TestWebmWriter(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "recording.webm")
+
+	writer, err := createWebm(path, start, 640, 480)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []struct {
+		offset   time.Duration
+		keyFrame bool
+	}{
+		{0, true},
+		{40 * time.Millisecond, false},
+		{80 * time.Millisecond, false},
+		// long enough after the cluster's start to cut it, but without a cue
+		{6 * time.Second, false},
+		{6040 * time.Millisecond, true},
+		// going backwards is clamped to the previous timestamp
+		{6020 * time.Millisecond, false},
+	}
+	for i, frame := range frames {
+		if err := writer.WriteFrame(start.Add(frame.offset), []byte{byte(i)}, frame.keyFrame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if writer.Duration() != 6040*time.Millisecond {
+		t.Errorf("expected a duration of 6.04s, got %s", writer.Duration())
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top, err := parseElements(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].id != idEBML || top[1].id != idSegment {
+		t.Fatalf("expected an ebml header and a segment that covers the rest of the file, got %d elements", len(top))
+	}
+	if docType := child(t, top[0].payload, idDocType); string(docType) != "webm" {
+		t.Errorf("expected doc type webm, got %q", docType)
+	}
+
+	segment, err := parseElements(top[1].payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := child(t, top[1].payload, idInfo)
+	if duration := math.Float64frombits(readUint(child(t, info, idDuration))); duration != 6040 {
+		t.Errorf("expected a duration of 6040ms, got %f", duration)
+	}
+	if scale := readUint(child(t, info, idTimecodeScale)); scale != timecodeScale {
+		t.Errorf("expected timecode scale %d, got %d", timecodeScale, scale)
+	}
+	if date := int64(readUint(child(t, info, idDateUTC))); date != start.Sub(matroskaEpoch).Nanoseconds() {
+		t.Errorf("unexpected date %d", date)
+	}
+
+	track := child(t, child(t, top[1].payload, idTracks), idTrackEntry)
+	if codec := child(t, track, idCodecID); string(codec) != "V_VP8" {
+		t.Errorf("expected codec V_VP8, got %q", codec)
+	}
+	video := child(t, track, idVideo)
+	if width, height := readUint(child(t, video, idPixelWidth)), readUint(child(t, video, idPixelHeight)); width != 640 || height != 480 {
+		t.Errorf("expected 640x480, got %dx%d", width, height)
+	}
+
+	type block struct {
+		timecode int16
+		keyFrame bool
+		data     byte
+	}
+	expectedClusters := []struct {
+		timecode uint64
+		blocks   []block
+	}{
+		{0, []block{{0, true, 0}, {40, false, 1}, {80, false, 2}}},
+		{6000, []block{{0, false, 3}}},
+		{6040, []block{{0, true, 4}, {0, false, 5}}},
+	}
+
+	clusterOffsets := make(map[uint64]int)
+	var clusters []element
+	for _, e := range segment {
+		if e.id == idCluster {
+			clusters = append(clusters, e)
+		}
+	}
+	if len(clusters) != len(expectedClusters) {
+		t.Fatalf("expected %d clusters, got %d", len(expectedClusters), len(clusters))
+	}
+
+	for i, expected := range expectedClusters {
+		elements, err := parseElements(clusters[i].payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if timecode := readUint(child(t, clusters[i].payload, idTimecode)); timecode != expected.timecode {
+			t.Errorf("cluster %d: expected timecode %d, got %d", i, expected.timecode, timecode)
+		}
+		clusterOffsets[expected.timecode] = clusters[i].offset
+
+		var blocks []block
+		for _, e := range elements {
+			if e.id != idSimpleBlock {
+				continue
+			}
+			if len(e.payload) != 5 || e.payload[0] != 0x81 {
+				t.Fatalf("cluster %d: unexpected block %x", i, e.payload)
+			}
+			blocks = append(blocks, block{
+				timecode: int16(binary.BigEndian.Uint16(e.payload[1:3])),
+				keyFrame: e.payload[3] == 0x80,
+				data:     e.payload[4],
+			})
+		}
+		if fmt.Sprint(blocks) != fmt.Sprint(expected.blocks) {
+			t.Errorf("cluster %d: expected blocks %v, got %v", i, expected.blocks, blocks)
+		}
+	}
+
+	cues, err := parseElements(child(t, top[1].payload, idCues))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCues := []uint64{0, 6040}
+	if len(cues) != len(expectedCues) {
+		t.Fatalf("expected %d cues, got %d", len(expectedCues), len(cues))
+	}
+	for i, cue := range cues {
+		cueTime := readUint(child(t, cue.payload, idCueTime))
+		position := readUint(child(t, child(t, cue.payload, idCueTrackPositions), idCueClusterPosition))
+		if cueTime != expectedCues[i] {
+			t.Errorf("cue %d: expected time %d, got %d", i, expectedCues[i], cueTime)
+		}
+		if offset, ok := clusterOffsets[cueTime]; !ok || uint64(offset) != position {
+			t.Errorf("cue %d: expected position %d, got %d", i, offset, position)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package webhook delivers signed JSON notifications to HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// TimestampHeader carries the Unix time the request was signed at, so receivers can reject old requests
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the timestamp, a period and the body, keyed with the
+	// target's secret, prefixed by "sha256="
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Target is an endpoint that receives notifications. Requests are not signed if it has no secret.
+type Target struct {
+	URL    string
+	Secret string
+}
+
+type Sender struct {
+	client     *http.Client
+	maxRetries int
+	retryDelay time.Duration
+}
+
+// NewSender creates a sender that retries failed deliveries up to maxRetries times, waiting retryDelay before the
+// first retry and twice as long before each of the following ones
+func NewSender(timeout time.Duration, maxRetries int, retryDelay time.Duration) *Sender {
+	return &Sender{
+		client:     &http.Client{Timeout: timeout},
+		maxRetries: maxRetries,
+		retryDelay: retryDelay,
+	}
+}
+
+// Sign returns the signature of a request body sent at the given time, as found in SignatureHeader
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// permanentError is a delivery failure that retrying won't fix
+type permanentError struct {
+	error
+}
+
+// Send posts payload encoded as JSON to target, retrying until it's accepted, the retries run out or ctx is canceled
+func (s *Sender) Send(ctx context.Context, target Target, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not encode webhook payload: %w", err)
+	}
+
+	delay := s.retryDelay
+	for attempt := 0; ; attempt++ {
+		err = s.post(ctx, target, body)
+		var permanent permanentError
+		if err == nil {
+			return nil
+		} else if errors.As(err, &permanent) || attempt >= s.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, last error: %s", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (s *Sender) post(ctx context.Context, target Target, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{fmt.Errorf("invalid webhook request: %w", err)}
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if target.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(TimestampHeader, timestamp)
+		request.Header.Set(SignatureHeader, Sign(target.Secret, timestamp, body))
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("could not deliver webhook: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("webhook target responded with status %d", response.StatusCode)
+	// Client errors mean the request itself is wrong, except when the target asks to slow down
+	if response.StatusCode >= 400 && response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
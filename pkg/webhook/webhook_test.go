@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRetryDelay = 20 * time.Millisecond
+
+type request struct {
+	time      time.Time
+	body      []byte
+	timestamp string
+	signature string
+}
+
+// newTarget starts a server that answers the requests it receives with the given status codes in turn, repeating the
+// last one
+func newTarget(t *testing.T, statuses ...int) (*httptest.Server, func() []request) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("could not read request: %v", err)
+		}
+
+		mu.Lock()
+		requests = append(requests, request{
+			time:      time.Now(),
+			body:      body,
+			timestamp: r.Header.Get(TimestampHeader),
+			signature: r.Header.Get(SignatureHeader),
+		})
+		status := statuses[len(statuses)-1]
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]request(nil), requests...)
+	}
+}
+
+func TestSign(t *testing.T) {
+	server, requests := newTarget(t, http.StatusOK)
+
+	sender := NewSender(time.Second, 0, testRetryDelay)
+	if err := sender.Send(context.Background(), Target{URL: server.URL, Secret: "secret"}, map[string]int{"id": 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := requests()[0]
+	if string(sent.body) != `{"id":1}` {
+		t.Errorf("unexpected body %s", sent.body)
+	}
+	if timestamp, err := strconv.ParseInt(sent.timestamp, 10, 64); err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		t.Errorf("unexpected timestamp %q", sent.timestamp)
+	}
+
+	// Receivers verify the signature like this
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(sent.timestamp + "." + string(sent.body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if sent.signature != want {
+		t.Errorf("expected signature %s, got %s", want, sent.signature)
+	}
+	if signature := Sign("secret", sent.timestamp, sent.body); signature != want {
+		t.Errorf("expected Sign to return %s, got %s", want, signature)
+	}
+}
+
+func TestSendUnsigned(t *testing.T) {
+	server, requests := newTarget(t, http.StatusNoContent)
+
+	if err := NewSender(time.Second, 0, testRetryDelay).Send(context.Background(), Target{URL: server.URL}, "event"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sent := requests()[0]; sent.timestamp != "" || sent.signature != "" {
+		t.Errorf("expected no signature without a secret, got %q at %q", sent.signature, sent.timestamp)
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		wantErr  bool
+	}{
+		{name: "accepted", statuses: []int{http.StatusOK}, attempts: 1},
+		{name: "server errors", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, attempts: 3},
+		{name: "too many requests", statuses: []int{http.StatusTooManyRequests, http.StatusAccepted}, attempts: 2},
+		{name: "retries run out", statuses: []int{http.StatusServiceUnavailable}, attempts: 4, wantErr: true},
+		{name: "bad request", statuses: []int{http.StatusBadRequest}, attempts: 1, wantErr: true},
+		{name: "not found", statuses: []int{http.StatusNotFound}, attempts: 1, wantErr: true},
+		{name: "unauthorized after server error", statuses: []int{http.StatusInternalServerError, http.StatusUnauthorized}, attempts: 2, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := newTarget(t, test.statuses...)
+
+			err := NewSender(time.Second, 3, testRetryDelay).Send(context.Background(), Target{URL: server.URL}, "event")
+			if test.wantErr && err == nil {
+				t.Error("expected an error")
+			} else if !test.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			sent := requests()
+			if len(sent) != test.attempts {
+				t.Fatalf("expected %d attempts, got %d", test.attempts, len(sent))
+			}
+
+			// The delay doubles after every retry
+			delay := testRetryDelay
+			for i := 1; i < len(sent); i++ {
+				if elapsed := sent[i].time.Sub(sent[i-1].time); elapsed < delay {
+					t.Errorf("expected retry %d to wait at least %s, waited %s", i, delay, elapsed)
+				}
+				delay *= 2
+			}
+		})
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	server, requests := newTarget(t, http.StatusInternalServerError)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(testRetryDelay/2, cancel)
+
+	if err := NewSender(time.Second, 5, time.Minute).Send(ctx, Target{URL: server.URL}, "event"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts := len(requests()); attempts != 1 {
+		t.Errorf("expected no retries once canceled, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,214 @@
+package webrtcstream
+
+import (
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// EncodedFrame is a frame of the stream's VP8 encoded video
+type EncodedFrame struct {
+	Data     []byte
+	KeyFrame bool
+	// Time the frame was captured at, from its timestamp in the pipeline
+	Time time.Time
+}
+
+// EncodedFrameHandler is called from the pipeline's streaming thread with every encoded frame, so it must not block
+type EncodedFrameHandler func(frame EncodedFrame)
+
+// encodedBranch hands the encoded video to handlers that consume it outside of WebRTC, such as recorders. Frames
+// only flow through it while it has handlers.
+type encodedBranch struct {
+	queue *gst.Queue
+	valve *gst.Valve
+	sink  *gst.AppSink
+
+	mu           sync.Mutex
+	handlers     map[int]EncodedFrameHandler
+	handlerCount int
+	// Running time of the first frame since the branch was opened and the time it arrived, which the times of the
+	// frames after it are counted from. Unset until that frame arrives.
+	startRunningTime time.Duration
+	startTime        time.Time
+}
+
+// newEncodedBranch creates the branch's elements, adds them to the pipeline and links them to the given tee, which
+// carries the stream's encoded video
+func newEncodedBranch(id int, pipeline *gst.Pipeline, tee *gst.Tee) (*encodedBranch, error) {
+	return buildEncodedBranch(id, pipeline, tee, false, nil)
+}
+
+// newReencodedBranch creates a branch with its own encoder, linked to a tee carrying the decoded video, for when its
+// handlers must receive different video than viewers. The overlay is placed before the encoder, unless it's nil.
+func newReencodedBranch(id int, pipeline *gst.Pipeline, tee *gst.Tee, overlay *gst.ClockOverlay) (*encodedBranch, error) {
+	return buildEncodedBranch(id, pipeline, tee, true, overlay)
+}
+
+func buildEncodedBranch(id int, pipeline *gst.Pipeline, tee *gst.Tee, encode bool, overlay *gst.ClockOverlay) (*encodedBranch, error) {
+	var result *multierror.Error
+	queue, err := gst.NewQueue(fmt.Sprintf("%d-encoded-queue", id))
+	result = multierror.Append(result, err)
+	valve, err := gst.NewValve(fmt.Sprintf("%d-encoded-valve", id))
+	result = multierror.Append(result, err)
+	sink, err := gst.NewAppSink(fmt.Sprintf("%d-encoded-sink", id))
+	result = multierror.Append(result, err)
+	var enc *gst.Vp8Enc
+	if encode {
+		enc, err = gst.NewVp8Enc(fmt.Sprintf("%d-encoded-enc", id))
+		result = multierror.Append(result, err)
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	result = nil
+	// a slow handler must never stall the viewers sharing the tee
+	result = multierror.Append(result, queue.SetProperty("leaky", 2))
+	result = multierror.Append(result, valve.SetOpen(false))
+	result = multierror.Append(result, sink.SetProperty("emit-signals", true))
+	result = multierror.Append(result, sink.SetProperty("sync", false))
+	// the valve is usually closed, so the sink must not hold back the pipeline's state changes waiting for data
+	result = multierror.Append(result, sink.SetProperty("async", false))
+	if enc != nil {
+		result = multierror.Append(result, configureVp8Enc(enc))
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	pipeline.AddElement(queue)
+	pipeline.AddElement(valve)
+	pipeline.AddElement(sink)
+
+	result = nil
+	result = multierror.Append(result, gst.LinkElements(tee, queue))
+	result = multierror.Append(result, gst.LinkElements(queue, valve))
+	if enc != nil {
+		pipeline.AddElement(enc)
+		if overlay != nil {
+			pipeline.AddElement(overlay)
+			result = multierror.Append(result, gst.LinkElements(valve, overlay))
+			result = multierror.Append(result, gst.LinkElements(overlay, enc))
+		} else {
+			result = multierror.Append(result, gst.LinkElements(valve, enc))
+		}
+		result = multierror.Append(result, gst.LinkElements(enc, sink))
+	} else {
+		result = multierror.Append(result, gst.LinkElements(valve, sink))
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	branch := &encodedBranch{
+		queue:    queue,
+		valve:    valve,
+		sink:     sink,
+		handlers: make(map[int]EncodedFrameHandler),
+	}
+
+	sink.OnNewSample(branch.handleSample)
+
+	return branch, nil
+}
+
+func (b *encodedBranch) handleSample(sample *gst.Sample) {
+	buffer := sample.Buffer()
+	data := buffer.Bytes()
+	if len(data) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	frame := EncodedFrame{
+		Data:     data,
+		KeyFrame: buffer.IsKeyFrame(),
+		Time:     b.frameTime(sample),
+	}
+
+	for _, handler := range b.handlers {
+		handler(frame)
+	}
+}
+
+// frameTime returns the time of a sample from its running time, so the times of frames are as far apart as their
+// timestamps regardless of how long they took to get here. Samples without a timestamp are given the current time.
+func (b *encodedBranch) frameTime(sample *gst.Sample) time.Time {
+	now := time.Now()
+
+	pts, ok := sample.Buffer().PTS()
+	if !ok {
+		return now
+	}
+	segment, ok := sample.Segment()
+	if !ok {
+		return now
+	}
+	runningTime, ok := segment.ToRunningTime(pts)
+	if !ok {
+		return now
+	}
+
+	if b.startTime.IsZero() {
+		b.startRunningTime = runningTime
+		b.startTime = now
+	}
+	return b.startTime.Add(runningTime - b.startRunningTime)
+}
+
+// AddEncodedFrameHandler registers a handler for the encoded video, keeping the pipeline playing until it's removed
+func (s *WebRTCStream) AddEncodedFrameHandler(handler EncodedFrameHandler, logger *zap.SugaredLogger) (int, error) {
+	logger = logger.Named("AddEncodedFrameHandler").With("stream id", s.Id)
+
+	if err := s.addConsumer(logger); err != nil {
+		return 0, err
+	}
+
+	s.encoded.mu.Lock()
+	defer s.encoded.mu.Unlock()
+
+	if len(s.encoded.handlers) == 0 {
+		if err := s.encoded.valve.SetOpen(true); err != nil {
+			if err := s.removeConsumer(logger); err != nil {
+				logger.Errorw("could not release pipeline", "err", err)
+			}
+			return 0, err
+		}
+		// The pipeline may have been paused while the branch was closed, stopping its running time but not the
+		// clock, so frames are counted from the next one again
+		s.encoded.startTime = time.Time{}
+	}
+
+	id := s.encoded.handlerCount
+	s.encoded.handlerCount++
+	s.encoded.handlers[id] = handler
+
+	return id, nil
+}
+
+// RemoveEncodedFrameHandler unregisters a handler added with AddEncodedFrameHandler
+func (s *WebRTCStream) RemoveEncodedFrameHandler(id int, logger *zap.SugaredLogger) error {
+	logger = logger.Named("RemoveEncodedFrameHandler").With("stream id", s.Id)
+
+	s.encoded.mu.Lock()
+	if _, ok := s.encoded.handlers[id]; !ok {
+		s.encoded.mu.Unlock()
+		return fmt.Errorf("no encoded frame handler with id %d", id)
+	}
+	delete(s.encoded.handlers, id)
+
+	var result *multierror.Error
+	if len(s.encoded.handlers) == 0 {
+		result = multierror.Append(result, s.encoded.valve.SetOpen(false))
+	}
+	s.encoded.mu.Unlock()
+
+	result = multierror.Append(result, s.removeConsumer(logger))
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,172 @@
+package webrtcstream
+
+import (
+	"context"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"go.uber.org/zap"
+	"math"
+	"time"
+)
+
+const (
+	// sourceReconnectDelay is how long to wait after the source fails before connecting to it again
+	sourceReconnectDelay = 5 * time.Second
+	// bitrateInterval is how often the encoder's output is measured
+	bitrateInterval = 5 * time.Second
+	// bitrateChangeThreshold is the relative change in the measured bitrate that is reported
+	bitrateChangeThreshold = 0.2
+)
+
+type EventType string
+
+const (
+	EventSourceConnected    EventType = "source_connected"
+	EventSourceDisconnected EventType = "source_disconnected"
+	EventSourceReconnecting EventType = "source_reconnecting"
+	EventPipelineError      EventType = "pipeline_error"
+	EventBitrateChanged     EventType = "bitrate_changed"
+)
+
+// Event is a change in the state of a stream
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Element that failed and the text of its error, for pipeline errors
+	Element string `json:"element,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Measured output of the encoder in kbit/s, for bitrate changes
+	Bitrate int `json:"bitrate,omitempty"`
+}
+
+// EventHandler is called with every event of a stream, from the pipeline's threads, so it must not block
+type EventHandler func(event Event)
+
+// OnEvent registers a handler for the stream's events
+func (s *WebRTCStream) OnEvent(handler EventHandler) {
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	s.eventHandlers = append(s.eventHandlers, handler)
+}
+
+func (s *WebRTCStream) emit(event Event) {
+	event.Time = time.Now()
+
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	for _, handler := range s.eventHandlers {
+		handler(event)
+	}
+}
+
+// processMsgBus watches the pipeline's messages until ctx is canceled, reporting errors and reconnecting the source
+// after it fails
+func (s *WebRTCStream) processMsgBus(ctx context.Context, logger *zap.SugaredLogger) {
+	logger = logger.Named("MsgBus")
+
+	messages := s.bus.Watch(ctx, gst.ERROR|gst.END_OF_STREAM)
+
+	var reconnect <-chan time.Time
+	for {
+		var msg *gst.Message
+		select {
+		case <-ctx.Done():
+			return
+		case <-reconnect:
+			reconnect = nil
+			s.reconnectSource(logger)
+			continue
+		case m, ok := <-messages:
+			// The watch only stops once ctx is canceled
+			if !ok {
+				return
+			}
+			msg = m
+		}
+
+		switch msg.Type() {
+		case gst.ERROR:
+			pipelineError, _ := msg.ParseError()
+			logger.Errorw("pipeline error", "element", pipelineError.Source, "err", pipelineError.Message,
+				"domain", pipelineError.Domain, "code", pipelineError.Code, "debug", pipelineError.Debug)
+			s.emit(Event{Type: EventPipelineError, Element: pipelineError.Source, Error: pipelineError.Message})
+		case gst.END_OF_STREAM:
+			logger.Warnw("source ended its stream")
+		}
+
+		// Either way the camera stopped sending video, reconnecting is the only way to get it back
+		if s.sourceConnected.Swap(false) {
+			s.emit(Event{Type: EventSourceDisconnected})
+		}
+		if reconnect == nil {
+			reconnect = time.After(sourceReconnectDelay)
+		}
+	}
+}
+
+// reconnectSource restarts the source, whose pad is linked again once it connects to the camera. The elements
+// downstream of it keep the end of stream it may have sent until they're flushed, so a flush is sent through them
+// before the source starts again.
+func (s *WebRTCStream) reconnectSource(logger *zap.SugaredLogger) {
+	logger.Infow("reconnecting source")
+	s.emit(Event{Type: EventSourceReconnecting})
+
+	// Stopping the source removes its pad, unlinking it from the decoder
+	if err := s.source.SetState(gst.NULL); err != nil {
+		logger.Errorw("could not stop source", "err", err)
+		return
+	}
+	s.sourceLinked.Store(false)
+
+	decSinkPad, ok := s.dec.GetPad("sink")
+	if !ok {
+		logger.Errorw("could not get sink pad of decoder")
+		return
+	}
+	if err := decSinkPad.SendEvent(gst.NewFlushStartEvent()); err != nil {
+		logger.Errorw("could not flush pipeline", "err", err)
+		return
+	}
+	// The running time is kept, since the source timestamps its buffers with the pipeline's clock
+	if err := decSinkPad.SendEvent(gst.NewFlushStopEvent(false)); err != nil {
+		logger.Errorw("could not flush pipeline", "err", err)
+		return
+	}
+
+	if err := s.source.SyncStateWithParent(); err != nil {
+		logger.Errorw("could not restart source", "err", err)
+	}
+}
+
+// monitorBitrate measures the encoder's output every bitrateInterval until ctx is canceled, reporting significant
+// changes. Nothing is reported while the encoder is idle.
+func (s *WebRTCStream) monitorBitrate(ctx context.Context) {
+	ticker := time.NewTicker(bitrateInterval)
+	defer ticker.Stop()
+
+	reported := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		bitrate := int(s.encodedBytes.Swap(0) * 8 / 1000 / int64(bitrateInterval/time.Second))
+		if bitrate == 0 {
+			continue
+		}
+
+		if reported == 0 || math.Abs(float64(bitrate-reported))/float64(reported) > bitrateChangeThreshold {
+			reported = bitrate
+			s.emit(Event{Type: EventBitrateChanged, Bitrate: bitrate})
+		}
+	}
+}
+
+// countEncodedBytes is a buffer callback for the encoder's output, measured by monitorBitrate
+func (s *WebRTCStream) countEncodedBytes(_ *gst.Pad, buffer *gst.Buffer) bool {
+	s.encodedBytes.Add(int64(buffer.Size()))
+	return true
+}
@@ -0,0 +1,191 @@
+package webrtcstream
+
+import (
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/hls"
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	// hlsIdleTimeout is how long the HLS branch keeps running after its last request
+	hlsIdleTimeout = 30 * time.Second
+	// hlsBitrate is the H.264 encoder's target bitrate, in kbit/s
+	hlsBitrate = 2048
+	// hlsKeyFrameInterval is the maximum amount of frames between key frames, which bounds the segment duration
+	hlsKeyFrameInterval = 30
+)
+
+// hlsBranch encodes the decoded video as H.264 in fragmented MP4 and feeds it to an in memory HLS muxer. Like the
+// JPEG branch, frames only flow through it while HLS clients are requesting the stream.
+type hlsBranch struct {
+	queue *gst.Queue
+	valve *gst.Valve
+	enc   *gst.X264Enc
+	parse *gst.H264Parse
+	mux   *gst.Mp4Mux
+	sink  *gst.AppSink
+
+	muxer  *hls.Muxer
+	logger *zap.SugaredLogger
+
+	mu          sync.Mutex
+	active      bool
+	lastRequest time.Time
+}
+
+// newHlsBranch creates the branch's elements, adds them to the pipeline and links them to the given tee. The overlay
+// is placed before the encoder, unless it's nil.
+func newHlsBranch(id int, pipeline *gst.Pipeline, tee *gst.Tee, overlay *gst.ClockOverlay, logger *zap.SugaredLogger) (*hlsBranch, error) {
+	config := hls.DefaultConfig()
+	muxer, err := hls.NewMuxer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *multierror.Error
+	queue, err := gst.NewQueue(fmt.Sprintf("%d-hls-queue", id))
+	result = multierror.Append(result, err)
+	valve, err := gst.NewValve(fmt.Sprintf("%d-hls-valve", id))
+	result = multierror.Append(result, err)
+	enc, err := gst.NewX264Enc(fmt.Sprintf("%d-hls-enc", id))
+	result = multierror.Append(result, err)
+	parse, err := gst.NewH264Parse(fmt.Sprintf("%d-hls-parse", id))
+	result = multierror.Append(result, err)
+	mux, err := gst.NewMp4Mux(fmt.Sprintf("%d-hls-mux", id))
+	result = multierror.Append(result, err)
+	sink, err := gst.NewAppSink(fmt.Sprintf("%d-hls-sink", id))
+	result = multierror.Append(result, err)
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	result = nil
+	result = multierror.Append(result, queue.SetProperty("leaky", 2))
+	result = multierror.Append(result, valve.SetOpen(false))
+	result = multierror.Append(result, enc.SetProperty("tune", 0x4))       // zerolatency
+	result = multierror.Append(result, enc.SetProperty("speed-preset", 2)) // superfast
+	result = multierror.Append(result, enc.SetProperty("bitrate", hlsBitrate))
+	result = multierror.Append(result, enc.SetProperty("key-int-max", hlsKeyFrameInterval))
+	// mp4mux starts a new fragment on every key frame and whenever the fragment duration is reached
+	result = multierror.Append(result, mux.SetProperty("fragment-duration", int(config.PartDuration.Milliseconds())))
+	result = multierror.Append(result, mux.SetProperty("streamable", true))
+	result = multierror.Append(result, sink.SetProperty("emit-signals", true))
+	result = multierror.Append(result, sink.SetProperty("sync", false))
+	// the valve is usually closed, so the sink must not hold back the pipeline's state changes waiting for data
+	result = multierror.Append(result, sink.SetProperty("async", false))
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	pipeline.AddElement(queue)
+	pipeline.AddElement(valve)
+	pipeline.AddElement(enc)
+	pipeline.AddElement(parse)
+	pipeline.AddElement(mux)
+	pipeline.AddElement(sink)
+
+	result = nil
+	result = multierror.Append(result, gst.LinkElements(tee, queue))
+	result = multierror.Append(result, gst.LinkElements(queue, valve))
+	if overlay != nil {
+		pipeline.AddElement(overlay)
+		result = multierror.Append(result, gst.LinkElements(valve, overlay))
+		result = multierror.Append(result, gst.LinkElements(overlay, enc))
+	} else {
+		result = multierror.Append(result, gst.LinkElements(valve, enc))
+	}
+	result = multierror.Append(result, gst.LinkElements(enc, parse))
+	result = multierror.Append(result, gst.LinkElements(parse, mux))
+	result = multierror.Append(result, gst.LinkElements(mux, sink))
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	branch := &hlsBranch{
+		queue:  queue,
+		valve:  valve,
+		enc:    enc,
+		parse:  parse,
+		mux:    mux,
+		sink:   sink,
+		muxer:  muxer,
+		logger: logger.Named("HLS"),
+	}
+
+	sink.OnNewSample(branch.handleSample)
+
+	return branch, nil
+}
+
+func (b *hlsBranch) handleSample(sample *gst.Sample) {
+	// The muxer drops what it couldn't parse and recovers on the next key frame, so the stream keeps going
+	if err := b.muxer.Write(sample.Buffer().Bytes()); err != nil {
+		b.logger.Warnw("could not mux hls output", "err", err)
+	}
+}
+
+// HLS returns the stream's HLS muxer, starting the pipeline and the HLS branch if they aren't running. Every request
+// from an HLS client must go through here, the branch is stopped and its segments dropped once no requests have been
+// made for a while.
+func (s *WebRTCStream) HLS(logger *zap.SugaredLogger) (*hls.Muxer, error) {
+	logger = logger.Named("HLS").With("stream id", s.Id)
+
+	s.hls.mu.Lock()
+	defer s.hls.mu.Unlock()
+
+	s.hls.lastRequest = time.Now()
+
+	if s.hls.active {
+		return s.hls.muxer, nil
+	}
+
+	logger.Infow("starting hls branch")
+
+	if err := s.addConsumer(logger); err != nil {
+		return nil, err
+	}
+
+	if err := s.hls.valve.SetOpen(true); err != nil {
+		if err := s.removeConsumer(logger); err != nil {
+			logger.Errorw("could not release pipeline", "err", err)
+		}
+		return nil, err
+	}
+
+	s.hls.active = true
+	go s.stopIdleHls(logger)
+
+	return s.hls.muxer, nil
+}
+
+// stopIdleHls waits until no HLS requests have been made for hlsIdleTimeout, and then stops the HLS branch
+func (s *WebRTCStream) stopIdleHls(logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(hlsIdleTimeout / 4)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.hls.mu.Lock()
+		if time.Since(s.hls.lastRequest) < hlsIdleTimeout {
+			s.hls.mu.Unlock()
+			continue
+		}
+
+		logger.Infow("no hls clients left, stopping hls branch")
+
+		if err := s.hls.valve.SetOpen(false); err != nil {
+			logger.Errorw("could not close hls branch", "err", err)
+		}
+		if err := s.removeConsumer(logger); err != nil {
+			logger.Errorw("could not release pipeline after hls", "err", err)
+		}
+		s.hls.muxer.Reset()
+		s.hls.active = false
+
+		s.hls.mu.Unlock()
+		return
+	}
+}
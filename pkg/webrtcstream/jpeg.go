@@ -0,0 +1,189 @@
+package webrtcstream
+
+import (
+	"context"
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const defaultJpegQuality = 85
+
+// jpegBranch encodes the decoded video as JPEG frames and hands them to its subscribers. Frames only flow through
+// the branch while it has subscribers, so the encoder doesn't waste CPU time otherwise.
+type jpegBranch struct {
+	queue *gst.Queue
+	valve *gst.Valve
+	enc   *gst.JpegEnc
+	sink  *gst.AppSink
+
+	mu              sync.Mutex
+	subscribers     map[int]chan []byte
+	subscriberCount int
+}
+
+// newJpegBranch creates the branch's elements, adds them to the pipeline and links them to the given tee. The overlay
+// is placed before the encoder, unless it's nil.
+func newJpegBranch(id int, pipeline *gst.Pipeline, tee *gst.Tee, overlay *gst.ClockOverlay) (*jpegBranch, error) {
+	var result *multierror.Error
+	queue, err := gst.NewQueue(fmt.Sprintf("%d-jpeg-queue", id))
+	result = multierror.Append(result, err)
+	valve, err := gst.NewValve(fmt.Sprintf("%d-jpeg-valve", id))
+	result = multierror.Append(result, err)
+	enc, err := gst.NewJpegEnc(fmt.Sprintf("%d-jpeg-enc", id))
+	result = multierror.Append(result, err)
+	sink, err := gst.NewAppSink(fmt.Sprintf("%d-jpeg-sink", id))
+	result = multierror.Append(result, err)
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	result = nil
+	// only keep the latest frame around
+	result = multierror.Append(result, queue.SetProperty("leaky", 2))
+	result = multierror.Append(result, queue.SetProperty("max-size-buffers", 1))
+	result = multierror.Append(result, valve.SetOpen(false))
+	result = multierror.Append(result, enc.SetProperty("quality", defaultJpegQuality))
+	result = multierror.Append(result, sink.SetProperty("emit-signals", true))
+	result = multierror.Append(result, sink.SetProperty("max-buffers", 1))
+	result = multierror.Append(result, sink.SetProperty("drop", true))
+	result = multierror.Append(result, sink.SetProperty("sync", false))
+	// the valve is usually closed, so the sink must not hold back the pipeline's state changes waiting for a frame
+	result = multierror.Append(result, sink.SetProperty("async", false))
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	pipeline.AddElement(queue)
+	pipeline.AddElement(valve)
+	pipeline.AddElement(enc)
+	pipeline.AddElement(sink)
+
+	result = nil
+	result = multierror.Append(result, gst.LinkElements(tee, queue))
+	result = multierror.Append(result, gst.LinkElements(queue, valve))
+	if overlay != nil {
+		pipeline.AddElement(overlay)
+		result = multierror.Append(result, gst.LinkElements(valve, overlay))
+		result = multierror.Append(result, gst.LinkElements(overlay, enc))
+	} else {
+		result = multierror.Append(result, gst.LinkElements(valve, enc))
+	}
+	result = multierror.Append(result, gst.LinkElements(enc, sink))
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	branch := &jpegBranch{
+		queue:       queue,
+		valve:       valve,
+		enc:         enc,
+		sink:        sink,
+		subscribers: make(map[int]chan []byte),
+	}
+
+	sink.OnNewSample(branch.handleSample)
+
+	return branch, nil
+}
+
+func (b *jpegBranch) handleSample(sample *gst.Sample) {
+	frame := sample.Buffer().Bytes()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subscriber := range b.subscribers {
+		// Subscribers only care about the latest frame, replace the pending one if they haven't read it yet
+		select {
+		case <-subscriber:
+		default:
+		}
+		subscriber <- frame
+	}
+}
+
+// subscribe returns a channel that receives every new frame, and opens the valve if this is the first subscriber.
+// Slow subscribers skip frames instead of blocking the branch.
+func (b *jpegBranch) subscribe() (int, <-chan []byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.subscribers) == 0 {
+		if err := b.valve.SetOpen(true); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	id := b.subscriberCount
+	b.subscriberCount++
+
+	frames := make(chan []byte, 1)
+	b.subscribers[id] = frames
+
+	return id, frames, nil
+}
+
+// unsubscribe removes a subscriber, closing the valve if no subscribers are left
+func (b *jpegBranch) unsubscribe(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, id)
+
+	if len(b.subscribers) == 0 {
+		return b.valve.SetOpen(false)
+	}
+
+	return nil
+}
+
+// StreamJpeg calls write with every new JPEG frame of the stream, skipping frames to deliver at most maxFps frames per
+// second. The pipeline is kept playing until ctx is canceled or write returns an error, which is then returned.
+func (s *WebRTCStream) StreamJpeg(ctx context.Context, maxFps float64, write func(frame []byte) error, logger *zap.SugaredLogger) error {
+	logger = logger.Named("StreamJpeg").With("stream id", s.Id)
+
+	if maxFps <= 0 {
+		return fmt.Errorf("invalid frame rate %f", maxFps)
+	}
+	minInterval := time.Duration(float64(time.Second) / maxFps)
+
+	if err := s.addConsumer(logger); err != nil {
+		return err
+	}
+	defer func() {
+		if err := s.removeConsumer(logger); err != nil {
+			logger.Errorw("could not release pipeline after jpeg stream", "err", err)
+		}
+	}()
+
+	subscriberId, frames, err := s.jpeg.subscribe()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := s.jpeg.unsubscribe(subscriberId); err != nil {
+			logger.Errorw("could not close jpeg branch after jpeg stream", "err", err)
+		}
+	}()
+
+	var lastFrame time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frame := <-frames:
+			if time.Since(lastFrame) < minInterval {
+				continue
+			}
+			lastFrame = time.Now()
+
+			if err := write(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package webrtcstream
+
+import (
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"time"
+)
+
+// sourceStallTimeout is how long the source may go without producing frames before it's reported as stalled
+const sourceStallTimeout = 2 * time.Second
+
+type SourceStatus string
+
+const (
+	SourceActive  SourceStatus = "active"
+	SourceStalled SourceStatus = "stalled"
+)
+
+type TrackStatus struct {
+	Source SourceStatus `json:"source"`
+	Paused bool         `json:"paused"`
+	// Time the last frame reached the track, and how many frames were sent to the viewer
+	LastFrame *time.Time `json:"last_frame,omitempty"`
+	Frames    uint64     `json:"frames"`
+}
+
+// LiveTrack controls the track of a single viewer of a live stream, without affecting the other viewers
+type LiveTrack struct {
+	stream *WebRTCStream
+	sink   *WebRtcSink
+}
+
+// SetPaused stops or resumes sending video to the viewer. A key frame is requested when resuming, so the viewer
+// doesn't have to wait for the next one.
+func (t *LiveTrack) SetPaused(paused bool) error {
+	wasPaused := t.sink.Paused()
+	t.sink.SetPaused(paused)
+
+	if wasPaused && !paused {
+		return t.RequestKeyFrame()
+	}
+	return nil
+}
+
+// RequestKeyFrame asks the encoder for a key frame. It's shared by all viewers, so they all receive it.
+func (t *LiveTrack) RequestKeyFrame() error {
+	return t.sink.SendEvent(gst.NewUpstreamForceKeyUnitEvent(true, 0))
+}
+
+func (t *LiveTrack) Status() TrackStatus {
+	status := TrackStatus{
+		Source: SourceStalled,
+		Paused: t.sink.Paused(),
+		Frames: t.sink.Frames(),
+	}
+
+	if lastFrame := t.sink.LastSample(); !lastFrame.IsZero() {
+		status.LastFrame = &lastFrame
+		if time.Since(lastFrame) < sourceStallTimeout {
+			status.Source = SourceActive
+		}
+	}
+
+	return status
+}
+
+// Stream returns the stream the track belongs to
+func (t *LiveTrack) Stream() *WebRTCStream {
+	return t.stream
+}
@@ -0,0 +1,427 @@
+package webrtcstream
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	// Size of the grayscale frames motion is detected in
+	motionWidth  = 160
+	motionHeight = 120
+
+	defaultMotionFps       = 2
+	defaultMotionThreshold = 25
+	defaultMotionMinScore  = 0.02
+	defaultMotionEndDelay  = 3 * time.Second
+	// defaultMotionZone names the zone covering the whole image, watched when none are configured
+	defaultMotionZone = "frame"
+)
+
+var ErrMotionDisabled = errors.New("motion detection is not configured for this camera")
+
+// MotionZone is a region of the image watched for motion, in coordinates relative to the image as viewers see it
+type MotionZone struct {
+	Region
+	Name string `toml:"name" json:"name"`
+}
+
+type MotionConfig struct {
+	// Frames analyzed per second, defaults to defaultMotionFps
+	Fps int `toml:"fps" json:"fps"`
+	// Change in a pixel's brightness, between 1 and 255, for it to count as moving. Defaults to defaultMotionThreshold.
+	Threshold int `toml:"threshold" json:"threshold"`
+	// Fraction of a zone's pixels that must move for motion to start in it, defaults to defaultMotionMinScore
+	MinScore float64 `toml:"min_score" json:"min_score"`
+	// How long a zone must be still for its motion to end, defaults to defaultMotionEndDelay
+	EndDelay string `toml:"end_delay" json:"end_delay"`
+	// The whole image is watched if there are no zones
+	Zones []MotionZone `toml:"zones" json:"zones"`
+}
+
+type MotionEventType string
+
+const (
+	MotionStart MotionEventType = "start"
+	MotionEnd   MotionEventType = "end"
+)
+
+type MotionEvent struct {
+	Type MotionEventType `json:"type"`
+	Zone string          `json:"zone"`
+	// Bounding box of the moving pixels in the zone. End events cover all the motion since it started.
+	Region RegionRect `json:"region"`
+	// Fraction of the zone's pixels that moved. End events have the highest score since the motion started.
+	Score float64   `json:"score"`
+	Time  time.Time `json:"time"`
+}
+
+// MotionHandler is called from the pipeline's streaming thread with every motion event, so it must not block
+type MotionHandler func(event MotionEvent)
+
+type motionZoneState struct {
+	name   string
+	spans  []span
+	pixels int
+
+	active     bool
+	lastMotion time.Time
+	peakScore  float64
+	// Bounding box of the motion since it started
+	bounds motionBounds
+}
+
+// motionBounds is a bounding box of moving pixels, inclusive of its edges
+type motionBounds struct {
+	minX, minY, maxX, maxY int
+}
+
+func emptyMotionBounds() motionBounds {
+	return motionBounds{minX: motionWidth, minY: motionHeight, maxX: -1, maxY: -1}
+}
+
+func (b *motionBounds) extend(other motionBounds) {
+	if other.minX < b.minX {
+		b.minX = other.minX
+	}
+	if other.minY < b.minY {
+		b.minY = other.minY
+	}
+	if other.maxX > b.maxX {
+		b.maxX = other.maxX
+	}
+	if other.maxY > b.maxY {
+		b.maxY = other.maxY
+	}
+}
+
+// rect normalizes the bounding box
+func (b motionBounds) rect() RegionRect {
+	return RegionRect{
+		X:      float64(b.minX) / motionWidth,
+		Y:      float64(b.minY) / motionHeight,
+		Width:  float64(b.maxX-b.minX+1) / motionWidth,
+		Height: float64(b.maxY-b.minY+1) / motionHeight,
+	}
+}
+
+// motionDetector compares every frame with the previous one, looking for zones where enough pixels changed
+type motionDetector struct {
+	threshold int
+	minScore  float64
+	endDelay  time.Duration
+	zones     []*motionZoneState
+
+	previous []byte
+}
+
+func newMotionDetector(config MotionConfig) (*motionDetector, error) {
+	detector := &motionDetector{
+		threshold: config.Threshold,
+		minScore:  config.MinScore,
+		endDelay:  defaultMotionEndDelay,
+	}
+
+	if detector.threshold == 0 {
+		detector.threshold = defaultMotionThreshold
+	} else if detector.threshold < 1 || detector.threshold > 255 {
+		return nil, fmt.Errorf("invalid motion threshold %d", detector.threshold)
+	}
+	if detector.minScore == 0 {
+		detector.minScore = defaultMotionMinScore
+	} else if detector.minScore < 0 || detector.minScore > 1 {
+		return nil, fmt.Errorf("invalid motion minimum score %f", detector.minScore)
+	}
+	if config.EndDelay != "" {
+		var err error
+		if detector.endDelay, err = time.ParseDuration(config.EndDelay); err != nil || detector.endDelay < 0 {
+			return nil, fmt.Errorf("invalid motion end delay %s", config.EndDelay)
+		}
+	}
+
+	zones := config.Zones
+	if len(zones) == 0 {
+		zones = []MotionZone{{
+			Name:   defaultMotionZone,
+			Region: Region{Rect: &RegionRect{Width: 1, Height: 1}},
+		}}
+	}
+
+	for i, zone := range zones {
+		if err := zone.Validate(); err != nil {
+			return nil, fmt.Errorf("motion zone %d: %w", i, err)
+		}
+
+		state := &motionZoneState{
+			name:  zone.Name,
+			spans: zone.rasterize(motionWidth, motionHeight),
+		}
+		for _, span := range state.spans {
+			state.pixels += span.end - span.start
+		}
+		if state.pixels == 0 {
+			return nil, fmt.Errorf("motion zone %d is too small", i)
+		}
+
+		detector.zones = append(detector.zones, state)
+	}
+
+	return detector, nil
+}
+
+// reset forgets the previous frame and any motion in progress, for when frames stop flowing for a while
+func (d *motionDetector) reset() {
+	d.previous = nil
+	for _, zone := range d.zones {
+		zone.active = false
+	}
+}
+
+// process compares a frame with the previous one, returning the motion that started or ended in it
+func (d *motionDetector) process(frame []byte, now time.Time) []MotionEvent {
+	if len(frame) < motionWidth*motionHeight {
+		return nil
+	}
+
+	previous := d.previous
+	d.previous = frame
+	if previous == nil {
+		return nil
+	}
+
+	var events []MotionEvent
+	for _, zone := range d.zones {
+		changed := 0
+		bounds := emptyMotionBounds()
+		for _, span := range zone.spans {
+			row := span.row * motionWidth
+			for x := span.start; x < span.end; x++ {
+				difference := int(frame[row+x]) - int(previous[row+x])
+				if difference < 0 {
+					difference = -difference
+				}
+				if difference < d.threshold {
+					continue
+				}
+
+				changed++
+				bounds.extend(motionBounds{x, span.row, x, span.row})
+			}
+		}
+
+		score := float64(changed) / float64(zone.pixels)
+		if score >= d.minScore {
+			if !zone.active {
+				zone.active = true
+				zone.peakScore = 0
+				zone.bounds = emptyMotionBounds()
+				events = append(events, zone.event(MotionStart, bounds.rect(), score, now))
+			}
+			zone.lastMotion = now
+			if score > zone.peakScore {
+				zone.peakScore = score
+			}
+			zone.bounds.extend(bounds)
+		} else if zone.active && now.Sub(zone.lastMotion) >= d.endDelay {
+			zone.active = false
+			events = append(events, zone.event(MotionEnd, zone.bounds.rect(), zone.peakScore, now))
+		}
+	}
+
+	return events
+}
+
+func (z *motionZoneState) event(eventType MotionEventType, region RegionRect, score float64, now time.Time) MotionEvent {
+	return MotionEvent{
+		Type:   eventType,
+		Zone:   z.name,
+		Region: region,
+		Score:  score,
+		Time:   now,
+	}
+}
+
+// motionBranch scales the decoded video down to small grayscale frames at a low frame rate, and looks for motion in
+// them. Frames only flow through it while it has handlers.
+type motionBranch struct {
+	queue   *gst.Queue
+	valve   *gst.Valve
+	rate    *gst.VideoRate
+	scale   *gst.VideoScale
+	convert *gst.VideoConvert
+	filter  *gst.CapsFilter
+	sink    *gst.AppSink
+
+	mu           sync.Mutex
+	detector     *motionDetector
+	handlers     map[int]MotionHandler
+	handlerCount int
+}
+
+// newMotionBranch creates the branch's elements, adds them to the pipeline and links them to the given tee
+func newMotionBranch(id int, pipeline *gst.Pipeline, tee *gst.Tee, config MotionConfig) (*motionBranch, error) {
+	detector, err := newMotionDetector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	fps := config.Fps
+	if fps == 0 {
+		fps = defaultMotionFps
+	} else if fps < 0 {
+		return nil, fmt.Errorf("invalid motion detection fps %d", fps)
+	}
+
+	var result *multierror.Error
+	queue, err := gst.NewQueue(fmt.Sprintf("%d-motion-queue", id))
+	result = multierror.Append(result, err)
+	valve, err := gst.NewValve(fmt.Sprintf("%d-motion-valve", id))
+	result = multierror.Append(result, err)
+	rate, err := gst.NewVideoRate(fmt.Sprintf("%d-motion-rate", id))
+	result = multierror.Append(result, err)
+	scale, err := gst.NewVideoScale(fmt.Sprintf("%d-motion-scale", id))
+	result = multierror.Append(result, err)
+	convert, err := gst.NewVideoConvert(fmt.Sprintf("%d-motion-convert", id))
+	result = multierror.Append(result, err)
+	filter, err := gst.NewCapsFilter(fmt.Sprintf("%d-motion-filter", id))
+	result = multierror.Append(result, err)
+	sink, err := gst.NewAppSink(fmt.Sprintf("%d-motion-sink", id))
+	result = multierror.Append(result, err)
+	caps, err := gst.NewCapsFromString(fmt.Sprintf("video/x-raw,format=GRAY8,width=%d,height=%d", motionWidth, motionHeight))
+	result = multierror.Append(result, err)
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	result = nil
+	// only keep the latest frame around
+	result = multierror.Append(result, queue.SetProperty("leaky", 2))
+	result = multierror.Append(result, queue.SetProperty("max-size-buffers", 1))
+	result = multierror.Append(result, valve.SetOpen(false))
+	// frames are dropped before being scaled, so the skipped ones cost nothing
+	result = multierror.Append(result, rate.SetProperty("max-rate", fps))
+	result = multierror.Append(result, rate.SetProperty("drop-only", true))
+	result = multierror.Append(result, filter.SetProperty("caps", caps))
+	result = multierror.Append(result, sink.SetProperty("emit-signals", true))
+	result = multierror.Append(result, sink.SetProperty("max-buffers", 1))
+	result = multierror.Append(result, sink.SetProperty("drop", true))
+	result = multierror.Append(result, sink.SetProperty("sync", false))
+	// the valve is usually closed, so the sink must not hold back the pipeline's state changes waiting for a frame
+	result = multierror.Append(result, sink.SetProperty("async", false))
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	pipeline.AddElement(queue)
+	pipeline.AddElement(valve)
+	pipeline.AddElement(rate)
+	pipeline.AddElement(scale)
+	pipeline.AddElement(convert)
+	pipeline.AddElement(filter)
+	pipeline.AddElement(sink)
+
+	result = nil
+	result = multierror.Append(result, gst.LinkElements(tee, queue))
+	result = multierror.Append(result, gst.LinkElements(queue, valve))
+	result = multierror.Append(result, gst.LinkElements(valve, rate))
+	result = multierror.Append(result, gst.LinkElements(rate, scale))
+	result = multierror.Append(result, gst.LinkElements(scale, convert))
+	result = multierror.Append(result, gst.LinkElements(convert, filter))
+	result = multierror.Append(result, gst.LinkElements(filter, sink))
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	branch := &motionBranch{
+		queue:    queue,
+		valve:    valve,
+		rate:     rate,
+		scale:    scale,
+		convert:  convert,
+		filter:   filter,
+		sink:     sink,
+		detector: detector,
+		handlers: make(map[int]MotionHandler),
+	}
+
+	sink.OnNewSample(branch.handleSample)
+
+	return branch, nil
+}
+
+func (b *motionBranch) handleSample(sample *gst.Sample) {
+	frame := sample.Buffer().Bytes()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range b.detector.process(frame, time.Now()) {
+		for _, handler := range b.handlers {
+			handler(event)
+		}
+	}
+}
+
+// AddMotionHandler registers a handler for the stream's motion events, detecting motion and keeping the pipeline
+// playing until it's removed
+func (s *WebRTCStream) AddMotionHandler(handler MotionHandler, logger *zap.SugaredLogger) (int, error) {
+	logger = logger.Named("AddMotionHandler").With("stream id", s.Id)
+
+	if s.motion == nil {
+		return 0, ErrMotionDisabled
+	}
+
+	if err := s.addConsumer(logger); err != nil {
+		return 0, err
+	}
+
+	s.motion.mu.Lock()
+	defer s.motion.mu.Unlock()
+
+	if len(s.motion.handlers) == 0 {
+		// frames from before the valve was closed are not comparable with new ones
+		s.motion.detector.reset()
+		if err := s.motion.valve.SetOpen(true); err != nil {
+			if err := s.removeConsumer(logger); err != nil {
+				logger.Errorw("could not release pipeline", "err", err)
+			}
+			return 0, err
+		}
+	}
+
+	id := s.motion.handlerCount
+	s.motion.handlerCount++
+	s.motion.handlers[id] = handler
+
+	return id, nil
+}
+
+// RemoveMotionHandler unregisters a handler added with AddMotionHandler
+func (s *WebRTCStream) RemoveMotionHandler(id int, logger *zap.SugaredLogger) error {
+	logger = logger.Named("RemoveMotionHandler").With("stream id", s.Id)
+
+	if s.motion == nil {
+		return ErrMotionDisabled
+	}
+
+	s.motion.mu.Lock()
+	if _, ok := s.motion.handlers[id]; !ok {
+		s.motion.mu.Unlock()
+		return fmt.Errorf("no motion handler with id %d", id)
+	}
+	delete(s.motion.handlers, id)
+
+	var result *multierror.Error
+	if len(s.motion.handlers) == 0 {
+		result = multierror.Append(result, s.motion.valve.SetOpen(false))
+	}
+	s.motion.mu.Unlock()
+
+	result = multierror.Append(result, s.removeConsumer(logger))
+	return result.ErrorOrNil()
+}
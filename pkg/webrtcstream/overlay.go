@@ -0,0 +1,97 @@
+package webrtcstream
+
+import (
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/hashicorp/go-multierror"
+)
+
+const defaultOverlayTimeFormat = "%Y-%m-%d %H:%M:%S"
+
+type OverlayPosition string
+
+const (
+	OverlayTopLeft     OverlayPosition = "top_left"
+	OverlayTop         OverlayPosition = "top"
+	OverlayTopRight    OverlayPosition = "top_right"
+	OverlayBottomLeft  OverlayPosition = "bottom_left"
+	OverlayBottom      OverlayPosition = "bottom"
+	OverlayBottomRight OverlayPosition = "bottom_right"
+)
+
+// OverlayConfig describes the wall-clock time and camera name burned into a camera's video
+type OverlayConfig struct {
+	// Defaults to the top left corner
+	Position OverlayPosition `toml:"position" json:"position"`
+	// Pango font description, such as "Sans Bold 16". Empty uses the element's default font.
+	Font string `toml:"font" json:"font"`
+	// strftime format of the time, defaults to defaultOverlayTimeFormat
+	TimeFormat string `toml:"time_format" json:"time_format"`
+	// Whether to show the camera's name before the time
+	ShowName bool `toml:"show_name" json:"show_name"`
+
+	// Outputs the overlay appears on. Live covers WebRTC and HLS viewers.
+	Live      bool `toml:"live" json:"live"`
+	Snapshot  bool `toml:"snapshot" json:"snapshot"`
+	Recording bool `toml:"recording" json:"recording"`
+}
+
+// alignment returns the clockoverlay halignment and valignment values of a position
+func (p OverlayPosition) alignment() (int, int, error) {
+	const (
+		left, center, right = 0, 1, 2
+		bottom, top         = 1, 2
+	)
+
+	switch p {
+	case OverlayTopLeft, "":
+		return left, top, nil
+	case OverlayTop:
+		return center, top, nil
+	case OverlayTopRight:
+		return right, top, nil
+	case OverlayBottomLeft:
+		return left, bottom, nil
+	case OverlayBottom:
+		return center, bottom, nil
+	case OverlayBottomRight:
+		return right, bottom, nil
+	}
+	return 0, 0, fmt.Errorf("invalid overlay position %s", p)
+}
+
+// newOverlay creates a clockoverlay element configured for the given camera. Every output has its own element, since
+// they are placed in different branches of the pipeline.
+func newOverlay(name string, config OverlayConfig, cameraName string) (*gst.ClockOverlay, error) {
+	halignment, valignment, err := config.Position.alignment()
+	if err != nil {
+		return nil, err
+	}
+
+	overlay, err := gst.NewClockOverlay(name)
+	if err != nil {
+		return nil, err
+	}
+
+	timeFormat := config.TimeFormat
+	if timeFormat == "" {
+		timeFormat = defaultOverlayTimeFormat
+	}
+
+	var result *multierror.Error
+	result = multierror.Append(result, overlay.SetProperty("time-format", timeFormat))
+	result = multierror.Append(result, overlay.SetProperty("halignment", halignment))
+	result = multierror.Append(result, overlay.SetProperty("valignment", valignment))
+	result = multierror.Append(result, overlay.SetProperty("shaded-background", true))
+	if config.Font != "" {
+		result = multierror.Append(result, overlay.SetProperty("font-desc", config.Font))
+	}
+	if config.ShowName {
+		result = multierror.Append(result, overlay.SetProperty("text", cameraName))
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	return overlay, nil
+}
@@ -0,0 +1,428 @@
+package webrtcstream
+
+import (
+	"context"
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxPlaybackRate = 16
+
+// Seeks land on the key frame before the requested position, since frames are sent without decoding them
+const playbackSeekFlags = gst.SEEK_FLAG_FLUSH | gst.SEEK_FLAG_KEY_UNIT | gst.SEEK_FLAG_SNAP_BEFORE
+
+// playbackCounter gives unique names to the elements of every playback
+var playbackCounter atomic.Int64
+
+// PlaybackFile is a recorded VP8 WebM file whose first frame was captured at Start
+type PlaybackFile struct {
+	Path  string
+	Start time.Time
+}
+
+type PlaybackStatus struct {
+	Position time.Time `json:"position"`
+	Rate     float64   `json:"rate"`
+	Paused   bool      `json:"paused"`
+	Ended    bool      `json:"ended"`
+}
+
+// Playback plays a time range of recorded files as a WebRTC track, one file at a time
+type Playback struct {
+	id    int64
+	files []PlaybackFile
+	// Playback stops here, unless it's zero
+	end   time.Time
+	track *webrtc.TrackLocalStaticSample
+
+	mu       sync.Mutex
+	ctx      context.Context
+	current  int
+	pipeline *gst.Pipeline
+	// Messages of the current pipeline, whose watch ends when the pipeline is replaced
+	messages     <-chan *gst.Message
+	sink         *WebRtcSink
+	stopPipeline context.CancelFunc
+	rate         float64
+	paused       bool
+	ended        bool
+	// Position within the current file to seek to once it has prerolled
+	pendingSeek *time.Duration
+	// Whether the pipeline must be started once the pending seek completes
+	startAfterSeek bool
+}
+
+// NewPlayback creates a playback of the given files, starting at start and ending at end if it's not zero
+func NewPlayback(files []PlaybackFile, start time.Time, end time.Time) (*Playback, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings to play")
+	}
+
+	files = append([]PlaybackFile(nil), files...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Start.Before(files[j].Start)
+	})
+
+	id := playbackCounter.Add(1)
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType: "video/vp8",
+	}, "video", fmt.Sprintf("playback-%d", id))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Playback{
+		id:    id,
+		files: files,
+		end:   end,
+		track: track,
+		rate:  1,
+	}
+
+	p.current, _ = p.locate(start)
+	offset := start.Sub(files[p.current].Start)
+	if offset > 0 {
+		p.pendingSeek = &offset
+	}
+
+	return p, nil
+}
+
+func (p *Playback) Track() webrtc.TrackLocal {
+	return p.track
+}
+
+// locate returns the file containing a time, and the position within it
+func (p *Playback) locate(t time.Time) (int, time.Duration) {
+	index := 0
+	for i, file := range p.files {
+		if !file.Start.After(t) {
+			index = i
+		}
+	}
+
+	offset := t.Sub(p.files[index].Start)
+	if offset < 0 {
+		offset = 0
+	}
+	return index, offset
+}
+
+// Run plays the files until ctx is canceled. Reaching the end doesn't stop the playback, so viewers may still seek
+// back.
+func (p *Playback) Run(ctx context.Context, logger *zap.SugaredLogger) error {
+	logger = logger.Named("Playback").With("playback id", p.id)
+
+	p.mu.Lock()
+	p.ctx = ctx
+	err := p.openLocked(p.current, logger)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err := p.closeLocked(); err != nil {
+			logger.Errorw("could not stop playback pipeline", "err", err)
+		}
+	}()
+
+	for {
+		p.mu.Lock()
+		messages := p.messages
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			// a closed watch belonged to a pipeline that has been replaced
+			if ok {
+				if err := p.handleMessage(messages, msg, logger); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handleMessage handles a message from the pipeline watched by messages, unless it has been replaced since
+func (p *Playback) handleMessage(messages <-chan *gst.Message, msg *gst.Message, logger *zap.SugaredLogger) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if messages != p.messages {
+		return nil
+	}
+
+	switch msg.Type() {
+	case gst.ASYNC_DONE:
+		return p.handlePrerolledLocked()
+	case gst.END_OF_STREAM:
+		if p.current+1 < len(p.files) && !p.pastEnd(p.files[p.current+1].Start) {
+			logger.Debugw("playing next recording", "file", p.files[p.current+1].Path)
+			return p.openLocked(p.current+1, logger)
+		}
+		logger.Debugw("playback ended")
+		p.ended = true
+	case gst.ERROR:
+		playbackError, _ := msg.ParseError()
+		return fmt.Errorf("error playing %s: %w", p.files[p.current].Path, playbackError)
+	}
+
+	return nil
+}
+
+func (p *Playback) pastEnd(t time.Time) bool {
+	return !p.end.IsZero() && !t.Before(p.end)
+}
+
+// handlePrerolledLocked applies the pending seek once the pipeline has prerolled, and starts it after the seek
+func (p *Playback) handlePrerolledLocked() error {
+	if p.pendingSeek != nil {
+		offset := *p.pendingSeek
+		p.pendingSeek = nil
+		p.startAfterSeek = true
+		return p.seekLocked(offset)
+	}
+
+	if p.startAfterSeek {
+		p.startAfterSeek = false
+		if !p.paused {
+			return p.pipeline.SetState(gst.PLAYING)
+		}
+	}
+
+	return nil
+}
+
+func (p *Playback) seekLocked(offset time.Duration) error {
+	// The range ends within the current file if there's no file after it that starts before the end
+	stop := time.Duration(-1)
+	if !p.end.IsZero() && (p.current+1 == len(p.files) || p.pastEnd(p.files[p.current+1].Start)) {
+		stop = p.end.Sub(p.files[p.current].Start)
+	}
+
+	return p.pipeline.Seek(p.rate, playbackSeekFlags, offset, stop)
+}
+
+// openLocked replaces the current pipeline with one playing the file at index. It's started once it has prerolled
+// and any pending seek is done.
+func (p *Playback) openLocked(index int, logger *zap.SugaredLogger) error {
+	if err := p.closeLocked(); err != nil {
+		logger.Errorw("could not stop previous playback pipeline", "err", err)
+	}
+
+	name := fmt.Sprintf("playback-%d-%d", p.id, index)
+
+	pipeline, err := gst.NewGstPipeline(name + "-pipeline")
+	if err != nil {
+		return err
+	}
+	bus, err := pipeline.Bus()
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	src, err := gst.NewFileSrc(name+"-src", p.files[index].Path)
+	result = multierror.Append(result, err)
+	demux, err := gst.NewMatroskaDemux(name + "-demux")
+	result = multierror.Append(result, err)
+	queue, err := gst.NewQueue(name + "-queue")
+	result = multierror.Append(result, err)
+	sink, err := NewWebRtcSink(name+"-sink", p.track)
+	result = multierror.Append(result, err)
+	if result.ErrorOrNil() != nil {
+		return result
+	}
+
+	sink.SetRate(p.rate)
+
+	pipeline.AddElement(src)
+	pipeline.AddElement(demux)
+	pipeline.AddElement(queue)
+	pipeline.AddElement(sink)
+
+	result = nil
+	result = multierror.Append(result, gst.LinkElements(src, demux))
+	result = multierror.Append(result, gst.LinkElements(queue, sink))
+	if result.ErrorOrNil() != nil {
+		return result
+	}
+
+	demux.OnPadAdded(func(pad *gst.Pad) {
+		sinkPad, ok := queue.GetPad("sink")
+		if !ok {
+			logger.Errorw("could not get sink pad of playback queue")
+			return
+		}
+
+		if err := gst.LinkPads(pad, sinkPad); err != nil {
+			logger.Errorw("could not link demuxer to playback queue", "err", err)
+		}
+	})
+
+	p.current = index
+	p.pipeline = pipeline
+	p.sink = sink
+	p.ended = false
+	p.startAfterSeek = true
+	// the rate is only applied through a seek
+	if p.rate != 1 && p.pendingSeek == nil {
+		start := time.Duration(0)
+		p.pendingSeek = &start
+	}
+
+	if err := pipeline.SetState(gst.PAUSED); err != nil {
+		return fmt.Errorf("could not start playback pipeline: %w", err)
+	}
+
+	pipelineCtx, stopPipeline := context.WithCancel(p.ctx)
+	p.stopPipeline = stopPipeline
+	p.messages = bus.Watch(pipelineCtx, gst.END_OF_STREAM|gst.ERROR|gst.ASYNC_DONE)
+	go runPlaybackSink(pipelineCtx, sink, logger)
+
+	return nil
+}
+
+// runPlaybackSink sends the samples of a playback pipeline to its track until ctx is canceled. The sink stops at the
+// end of the recordings, so it's started again once seeking back flushes the end of stream away.
+func runPlaybackSink(ctx context.Context, sink *WebRtcSink, logger *zap.SugaredLogger) {
+	for {
+		if err := sink.Start(ctx); err != nil {
+			logger.Errorw("playback track stopped", "err", err)
+			return
+		}
+
+		for ctx.Err() == nil && sink.IsEOS() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(sinkPullTimeout):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (p *Playback) closeLocked() error {
+	if p.pipeline == nil {
+		return nil
+	}
+
+	p.stopPipeline()
+	err := p.pipeline.SetState(gst.NULL)
+
+	p.pipeline = nil
+	p.messages = nil
+	p.sink = nil
+	return err
+}
+
+// Seek moves the playback to a time within the recordings
+func (p *Playback) Seek(t time.Time, logger *zap.SugaredLogger) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pipeline == nil {
+		return fmt.Errorf("playback is not running")
+	}
+
+	index, offset := p.locate(t)
+	p.ended = false
+
+	if index == p.current && p.pendingSeek == nil {
+		return p.seekLocked(offset)
+	}
+
+	p.pendingSeek = &offset
+	if index == p.current {
+		return nil
+	}
+	return p.openLocked(index, logger)
+}
+
+// SetRate changes the playback speed. Only forward playback is supported, since frames are sent without decoding.
+func (p *Playback) SetRate(rate float64) error {
+	if rate <= 0 || rate > maxPlaybackRate {
+		return fmt.Errorf("playback rate must be between 0 and %d", maxPlaybackRate)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pipeline == nil {
+		return fmt.Errorf("playback is not running")
+	}
+
+	position, err := p.pipeline.QueryPosition()
+	if err != nil {
+		return err
+	}
+
+	p.rate = rate
+	p.sink.SetRate(rate)
+
+	if p.pendingSeek != nil {
+		// the new rate is used by the pending seek
+		return nil
+	}
+	return p.seekLocked(position)
+}
+
+func (p *Playback) SetPaused(paused bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pipeline == nil {
+		return fmt.Errorf("playback is not running")
+	}
+
+	p.paused = paused
+
+	// a pipeline still waiting for its seek is started afterwards
+	if p.startAfterSeek {
+		return nil
+	}
+
+	if paused {
+		return p.pipeline.SetState(gst.PAUSED)
+	}
+	return p.pipeline.SetState(gst.PLAYING)
+}
+
+func (p *Playback) Status() PlaybackStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := PlaybackStatus{
+		Rate:   p.rate,
+		Paused: p.paused,
+		Ended:  p.ended,
+	}
+
+	file := p.files[p.current]
+	if p.pendingSeek != nil {
+		status.Position = file.Start.Add(*p.pendingSeek)
+	} else if p.pipeline != nil {
+		if position, err := p.pipeline.QueryPosition(); err == nil {
+			status.Position = file.Start.Add(position)
+		}
+	}
+	if status.Position.IsZero() {
+		status.Position = file.Start
+	}
+
+	return status
+}
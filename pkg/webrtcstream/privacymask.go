@@ -0,0 +1,234 @@
+package webrtcstream
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/hashicorp/go-multierror"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maskFormat is the pixel format frames are converted to before being masked
+	maskFormat = "I420"
+	// maskBlurBlocks is how many blocks wide a blurred region of the whole frame would be
+	maskBlurBlocks = 32
+	// Values of the luma and chroma planes that paint a solid mask black
+	maskSolidLuma   = 16
+	maskSolidChroma = 128
+)
+
+type PrivacyMaskStyle string
+
+const (
+	PrivacyMaskSolid PrivacyMaskStyle = "solid"
+	// PrivacyMaskBlur hides the region behind large blocks of its average color
+	PrivacyMaskBlur PrivacyMaskStyle = "blur"
+)
+
+// PrivacyMask hides a region of the camera's image from every output. Like motion zones, its coordinates are relative
+// to the image after its orientation is applied.
+type PrivacyMask struct {
+	Region
+	// Defaults to solid
+	Style PrivacyMaskStyle `toml:"style" json:"style"`
+}
+
+func (m PrivacyMask) Validate() error {
+	switch m.Style {
+	case PrivacyMaskSolid, PrivacyMaskBlur, "":
+	default:
+		return fmt.Errorf("invalid privacy mask style %s", m.Style)
+	}
+
+	return m.Region.Validate()
+}
+
+// privacyMasker paints the masks over every frame. Their pixels are computed once per plane size, since frames rarely
+// change resolution.
+type privacyMasker struct {
+	masks []PrivacyMask
+	// Layout of the frames, updated whenever their caps change
+	info atomic.Pointer[gst.VideoInfo]
+
+	mu sync.Mutex
+	// Spans of every mask, by plane width and height
+	spans map[[2]int][][]span
+}
+
+func newPrivacyMasker(masks []PrivacyMask) (*privacyMasker, error) {
+	var result *multierror.Error
+	for i, mask := range masks {
+		if err := mask.Validate(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("privacy mask %d: %w", i, err))
+		}
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	return &privacyMasker{
+		masks: masks,
+		spans: make(map[[2]int][][]span),
+	}, nil
+}
+
+func (m *privacyMasker) planeSpans(width int, height int) [][]span {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]int{width, height}
+	if spans, ok := m.spans[key]; ok {
+		return spans
+	}
+
+	spans := make([][]span, len(m.masks))
+	for i, mask := range m.masks {
+		spans[i] = mask.rasterize(width, height)
+	}
+	m.spans[key] = spans
+
+	return spans
+}
+
+// handleEvent keeps track of the layout of the frames from the caps events that precede them
+func (m *privacyMasker) handleEvent(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+	event, _ := info.Event()
+	caps, ok := event.ParseCaps()
+	if !ok {
+		return gst.PAD_PROBE_OK
+	}
+
+	// Frames are dropped until caps that describe raw video arrive
+	videoInfo, err := gst.NewVideoInfoFromCaps(caps)
+	if err != nil {
+		videoInfo = nil
+	}
+	m.info.Store(videoInfo)
+
+	return gst.PAD_PROBE_OK
+}
+
+// handleBuffer masks a frame in place. Frames that can't be masked are dropped, rather than let through unmasked.
+func (m *privacyMasker) handleBuffer(_ *gst.Pad, buffer *gst.Buffer) bool {
+	info := m.info.Load()
+	if info == nil {
+		return false
+	}
+
+	frame, err := gst.MapVideoFrame(buffer, info)
+	if err != nil {
+		return false
+	}
+	defer frame.Unmap()
+
+	if frame.Format() != maskFormat {
+		return false
+	}
+
+	for planeIndex := 0; planeIndex < frame.NPlanes(); planeIndex++ {
+		plane := frame.Plane(planeIndex)
+
+		solidValue := byte(maskSolidChroma)
+		if planeIndex == 0 {
+			solidValue = maskSolidLuma
+		}
+
+		blockSize := plane.Width / maskBlurBlocks
+		if blockSize < 1 {
+			blockSize = 1
+		}
+
+		for i, spans := range m.planeSpans(plane.Width, plane.Height) {
+			if m.masks[i].Style == PrivacyMaskBlur {
+				pixelate(plane, spans, blockSize)
+			} else {
+				fill(plane, spans, solidValue)
+			}
+		}
+	}
+
+	return true
+}
+
+func fill(plane gst.VideoPlane, spans []span, value byte) {
+	for _, span := range spans {
+		row := plane.Data[span.row*plane.Stride:]
+		for x := span.start; x < span.end; x++ {
+			row[x] = value
+		}
+	}
+}
+
+// pixelate replaces the pixels of every blockSize square block covered by the spans with their average
+func pixelate(plane gst.VideoPlane, spans []span, blockSize int) {
+	blockColumns := (plane.Width + blockSize - 1) / blockSize
+	sums := make([]int, blockColumns)
+	counts := make([]int, blockColumns)
+
+	// spans are sorted by row, so the ones of each band of blocks are contiguous
+	for first := 0; first < len(spans); {
+		band := spans[first].row / blockSize
+		last := first
+		for last < len(spans) && spans[last].row/blockSize == band {
+			last++
+		}
+
+		for i := range sums {
+			sums[i], counts[i] = 0, 0
+		}
+		for _, span := range spans[first:last] {
+			row := plane.Data[span.row*plane.Stride:]
+			for x := span.start; x < span.end; x++ {
+				sums[x/blockSize] += int(row[x])
+				counts[x/blockSize]++
+			}
+		}
+		for _, span := range spans[first:last] {
+			row := plane.Data[span.row*plane.Stride:]
+			for x := span.start; x < span.end; x++ {
+				row[x] = byte(sums[x/blockSize] / counts[x/blockSize])
+			}
+		}
+
+		first = last
+	}
+}
+
+// newPrivacyMaskElements creates the elements that convert frames to maskFormat before the masks are painted over
+// them as they leave the caps filter
+func newPrivacyMaskElements(id int, masks []PrivacyMask) (*gst.VideoConvert, *gst.CapsFilter, error) {
+	masker, err := newPrivacyMasker(masks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result *multierror.Error
+	convert, err := gst.NewVideoConvert(fmt.Sprintf("%d-mask-convert", id))
+	result = multierror.Append(result, err)
+	filter, err := gst.NewCapsFilter(fmt.Sprintf("%d-mask-filter", id))
+	result = multierror.Append(result, err)
+	caps, err := gst.NewCapsFromString("video/x-raw,format=" + maskFormat)
+	result = multierror.Append(result, err)
+	if result.ErrorOrNil() != nil {
+		return nil, nil, result
+	}
+
+	if err := filter.SetProperty("caps", caps); err != nil {
+		return nil, nil, err
+	}
+
+	pad, ok := filter.GetPad("src")
+	if !ok {
+		return nil, nil, errors.New("could not get source pad of mask caps filter")
+	}
+	if _, err := pad.AddProbe(gst.PAD_PROBE_TYPE_EVENT_DOWNSTREAM, masker.handleEvent); err != nil {
+		return nil, nil, err
+	}
+	if err := pad.OnWritableBuffer(masker.handleBuffer); err != nil {
+		return nil, nil, err
+	}
+
+	return convert, filter, nil
+}
@@ -0,0 +1,97 @@
+package webrtcstream
+
+import (
+	"errors"
+	"sort"
+)
+
+// RegionRect is a rectangle in normalized coordinates, with its origin at the top left corner
+type RegionRect struct {
+	X      float64 `toml:"x" json:"x"`
+	Y      float64 `toml:"y" json:"y"`
+	Width  float64 `toml:"width" json:"width"`
+	Height float64 `toml:"height" json:"height"`
+}
+
+// Region is an area of the image, either a rectangle or a polygon. Coordinates are normalized between 0 and 1, so they
+// hold across resolutions, and are relative to the image as viewers see it, after the camera's orientation is applied.
+type Region struct {
+	// Vertices of a polygon as [x, y] pairs, in order. Ignored if Rect is set.
+	Polygon [][2]float64 `toml:"polygon" json:"polygon,omitempty"`
+	Rect    *RegionRect  `toml:"rect" json:"rect,omitempty"`
+}
+
+// vertices returns the polygon covered by the region
+func (r Region) vertices() [][2]float64 {
+	if r.Rect != nil {
+		return [][2]float64{
+			{r.Rect.X, r.Rect.Y},
+			{r.Rect.X + r.Rect.Width, r.Rect.Y},
+			{r.Rect.X + r.Rect.Width, r.Rect.Y + r.Rect.Height},
+			{r.Rect.X, r.Rect.Y + r.Rect.Height},
+		}
+	}
+	return r.Polygon
+}
+
+func (r Region) Validate() error {
+	if r.Rect != nil && (r.Rect.Width <= 0 || r.Rect.Height <= 0) {
+		return errors.New("region rectangle must have a positive size")
+	}
+
+	vertices := r.vertices()
+	if len(vertices) < 3 {
+		return errors.New("region must be a rectangle or a polygon of at least three points")
+	}
+	for _, vertex := range vertices {
+		if vertex[0] < 0 || vertex[0] > 1 || vertex[1] < 0 || vertex[1] > 1 {
+			return errors.New("region coordinates must be between 0 and 1")
+		}
+	}
+
+	return nil
+}
+
+// span is a run of pixels in a row covered by a region, from start up to but not including end
+type span struct {
+	row, start, end int
+}
+
+// rasterize returns the pixels of a width by height image covered by the region, sampling the center of every pixel.
+// Spans are sorted by row.
+func (r Region) rasterize(width int, height int) []span {
+	vertices := r.vertices()
+	var spans []span
+	var crossings []float64
+
+	for row := 0; row < height; row++ {
+		y := (float64(row) + 0.5) / float64(height)
+
+		crossings = crossings[:0]
+		for i := range vertices {
+			a, b := vertices[i], vertices[(i+1)%len(vertices)]
+			if (a[1] <= y) == (b[1] <= y) {
+				continue
+			}
+			crossings = append(crossings, a[0]+(y-a[1])/(b[1]-a[1])*(b[0]-a[0]))
+		}
+		sort.Float64s(crossings)
+
+		// even-odd rule, pixels whose centers fall between pairs of crossings are inside
+		for i := 0; i+1 < len(crossings); i += 2 {
+			start := int(crossings[i]*float64(width) + 0.5)
+			end := int(crossings[i+1]*float64(width) + 0.5)
+			if start < 0 {
+				start = 0
+			}
+			if end > width {
+				end = width
+			}
+			if start < end {
+				spans = append(spans, span{row, start, end})
+			}
+		}
+	}
+
+	return spans
+}
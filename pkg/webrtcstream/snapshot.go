@@ -0,0 +1,154 @@
+package webrtcstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"time"
+)
+
+// snapshotTTL is how long a captured frame is reused for new snapshot requests
+const snapshotTTL = 2 * time.Second
+
+type SnapshotOptions struct {
+	// Width of the snapshot, scaled keeping the aspect ratio. Zero keeps the original width.
+	Width int
+	// JPEG quality between 1 and 100. Zero keeps the encoder's quality.
+	Quality int
+}
+
+// Snapshot returns the latest frame of the stream encoded as a JPEG image. If nobody is watching the stream, the
+// pipeline is started until a frame is captured.
+func (s *WebRTCStream) Snapshot(ctx context.Context, options SnapshotOptions, logger *zap.SugaredLogger) ([]byte, error) {
+	logger = logger.Named("Snapshot").With("stream id", s.Id)
+
+	if options.Quality < 0 || options.Quality > 100 {
+		return nil, fmt.Errorf("invalid jpeg quality %d", options.Quality)
+	}
+	if options.Width < 0 {
+		return nil, fmt.Errorf("invalid width %d", options.Width)
+	}
+
+	frame, err := s.latestJpegFrame(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Width == 0 && options.Quality == 0 {
+		return frame, nil
+	}
+
+	return transcodeJpeg(frame, options)
+}
+
+// latestJpegFrame returns the cached frame if it's fresh enough, capturing a new one otherwise
+func (s *WebRTCStream) latestJpegFrame(ctx context.Context, logger *zap.SugaredLogger) ([]byte, error) {
+	// Only one capture at a time, concurrent requests will find the frame in the cache
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	if s.snapshot != nil && time.Since(s.snapshotTime) < snapshotTTL {
+		logger.Debugw("using cached snapshot")
+		return s.snapshot, nil
+	}
+
+	logger.Debugw("capturing snapshot")
+
+	if err := s.addConsumer(logger); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := s.removeConsumer(logger); err != nil {
+			logger.Errorw("could not release pipeline after snapshot", "err", err)
+		}
+	}()
+
+	subscriberId, frames, err := s.jpeg.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := s.jpeg.unsubscribe(subscriberId); err != nil {
+			logger.Errorw("could not close jpeg branch after snapshot", "err", err)
+		}
+	}()
+
+	select {
+	case frame := <-frames:
+		s.snapshot = frame
+		s.snapshotTime = time.Now()
+		return frame, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no frame received from camera: %w", ctx.Err())
+	}
+}
+
+// transcodeJpeg scales and re-encodes a JPEG frame. Images are never scaled up.
+func transcodeJpeg(frame []byte, options SnapshotOptions) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode frame: %w", err)
+	}
+
+	bounds := img.Bounds()
+	if options.Width != 0 && options.Width < bounds.Dx() {
+		height := bounds.Dy() * options.Width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		img = scaleImage(img, options.Width, height)
+	}
+
+	quality := options.Quality
+	if quality == 0 {
+		quality = defaultJpegQuality
+	}
+
+	var output bytes.Buffer
+	if err := jpeg.Encode(&output, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("could not encode frame: %w", err)
+	}
+
+	return output.Bytes(), nil
+}
+
+// scaleImage downscales an image by averaging the source pixels covered by each destination pixel
+func scaleImage(src image.Image, width int, height int) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		srcY0 := bounds.Min.Y + y*bounds.Dy()/height
+		srcY1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if srcY1 == srcY0 {
+			srcY1++
+		}
+
+		for x := 0; x < width; x++ {
+			srcX0 := bounds.Min.X + x*bounds.Dx()/width
+			srcX1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if srcX1 == srcX0 {
+				srcX1++
+			}
+
+			var r, g, b, count uint32
+			for sy := srcY0; sy < srcY1; sy++ {
+				for sx := srcX0; sx < srcX1; sx++ {
+					pr, pg, pb, _ := src.At(sx, sy).RGBA()
+					r += pr >> 8
+					g += pg >> 8
+					b += pb >> 8
+					count++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / count), G: uint8(g / count), B: uint8(b / count), A: 0xff})
+		}
+	}
+
+	return dst
+}
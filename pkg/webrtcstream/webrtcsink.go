@@ -3,55 +3,247 @@ package webrtcstream
 import "C"
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
+	"io"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sinkPullTimeout is how long the sink waits for a sample before checking whether it was stopped
+	sinkPullTimeout = 100 * time.Millisecond
+	// sinkMaxWriteErrors is how many writes to the track may fail in a row before the sink gives up
+	sinkMaxWriteErrors = 50
+	// Samples queued in the sink before the oldest are dropped. Frames are sent one at a time, but RTP packets come
+	// in bursts of a whole frame.
+	sinkMaxSamples    = 1
+	sinkMaxRTPPackets = 128
 )
 
 type WebRtcSink struct {
 	*gst.AppSink
 	track *webrtc.TrackLocalStaticSample
+	// Used instead of track when the pipeline payloads the video, so packets keep the pipeline's timestamps
+	rtpTrack *webrtc.TrackLocalStaticRTP
+	// Sequence number of the next packet sent to rtpTrack. Packets are numbered by the sink, so the ones dropped while
+	// paused don't look lost to the viewer.
+	sequenceNumber uint16
+	// Bits of the float64 playback rate, sample durations are divided by it
+	rate atomic.Uint64
+	// Samples are still pulled while paused, so the sink never holds back the rest of the pipeline
+	paused atomic.Bool
+	// Set when resuming, since frames depending on the ones dropped can't be decoded
+	waitKeyFrame atomic.Bool
+	frames       atomic.Uint64
+	// Unix nanoseconds of the last sample that reached the sink, whether it was sent or not
+	lastSample atomic.Int64
+	// Writes to the track that failed in a row, only used by Start
+	writeErrors int
 }
 
 func NewWebRtcSink(name string, track *webrtc.TrackLocalStaticSample) (*WebRtcSink, error) {
-	createdAppSink, err := gst.NewAppSink(name)
+	sink, err := newWebRtcSink(name, sinkMaxSamples)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := createdAppSink.SetProperty("sync", true); err != nil {
+	sink.track = track
+	return sink, nil
+}
+
+// NewWebRtcRTPSink creates a sink that sends RTP packets to the track as they are. The track rewrites their SSRC and
+// payload type for every viewer.
+func NewWebRtcRTPSink(name string, track *webrtc.TrackLocalStaticRTP) (*WebRtcSink, error) {
+	sink, err := newWebRtcSink(name, sinkMaxRTPPackets)
+	if err != nil {
 		return nil, err
 	}
 
-	return &WebRtcSink{createdAppSink, track}, nil
+	sink.rtpTrack = track
+	sink.sequenceNumber = uint16(rand.Uint32())
+	return sink, nil
 }
 
-func (w *WebRtcSink) Start(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			// If context is done stop loop
-			return
-		default:
-			sample, err := w.PullSample()
+// newWebRtcSink creates a sink that drops its oldest samples once it holds maxBuffers, so a slow viewer never holds
+// back the rest of the pipeline
+func newWebRtcSink(name string, maxBuffers int) (*WebRtcSink, error) {
+	createdAppSink, err := gst.NewAppSink(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *multierror.Error
+	result = multierror.Append(result, createdAppSink.SetProperty("sync", true))
+	result = multierror.Append(result, createdAppSink.SetProperty("max-buffers", maxBuffers))
+	result = multierror.Append(result, createdAppSink.SetProperty("drop", true))
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	sink := &WebRtcSink{AppSink: createdAppSink}
+	sink.SetRate(1)
 
-			if err != nil {
-				// Don't process if nothing is available yet
-				continue
-			}
+	return sink, nil
+}
+
+// SetRate sets the rate at which the sink's samples are being played, so the track's timestamps advance at the same
+// speed as the samples are sent
+func (w *WebRtcSink) SetRate(rate float64) {
+	w.rate.Store(math.Float64bits(rate))
+}
+
+// SetPaused stops or resumes sending samples to the track. After resuming, samples are sent from the next key frame.
+func (w *WebRtcSink) SetPaused(paused bool) {
+	if !paused && w.paused.Load() {
+		w.waitKeyFrame.Store(true)
+	}
+	w.paused.Store(paused)
+}
+
+func (w *WebRtcSink) Paused() bool {
+	return w.paused.Load()
+}
+
+// Frames returns how many samples were sent to the track
+func (w *WebRtcSink) Frames() uint64 {
+	return w.frames.Load()
+}
 
-			buffer := sample.Buffer()
-			data := buffer.Bytes()
-			duration := buffer.Duration()
+// LastSample returns when the sink last received a sample, or the zero time if it never did
+func (w *WebRtcSink) LastSample() time.Time {
+	lastSample := w.lastSample.Load()
+	if lastSample == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lastSample)
+}
+
+// Start sends the sink's samples to the track until ctx is canceled or the sink reaches EOS, returning the error that
+// stopped it if the samples can't be read or writing to the track keeps failing
+func (w *WebRtcSink) Start(ctx context.Context) error {
+	for ctx.Err() == nil {
+		pullStarted := time.Now()
+		sample, ok := w.TryPullSample(sinkPullTimeout)
+		if !ok {
+			if w.IsEOS() {
+				return nil
+			}
 
-			if err := w.track.WriteSample(media.Sample{
-				Data:     data,
-				Duration: duration,
-			}); err != nil {
-				break
+			// A sink that isn't running returns at once instead of waiting for a sample
+			select {
+			case <-ctx.Done():
+			case <-time.After(sinkPullTimeout - time.Since(pullStarted)):
 			}
+			continue
+		}
+
+		if err := w.handleSample(sample); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *WebRtcSink) handleSample(sample *gst.Sample) error {
+	w.lastSample.Store(time.Now().UnixNano())
+	if w.paused.Load() {
+		return nil
+	}
+
+	// Tracks copy what they send before writing returns, so the sample's memory is used without copying it first
+	buffer := sample.Buffer()
+	mapInfo, err := buffer.Map()
+	if err != nil {
+		return fmt.Errorf("could not read sample: %w", err)
+	}
+	defer mapInfo.Unmap()
+	data := mapInfo.Data
+
+	if w.rtpTrack != nil {
+		return w.writeRTP(data)
+	}
+
+	if w.waitKeyFrame.Load() {
+		if len(data) == 0 || !buffer.IsKeyFrame() {
+			return nil
+		}
+		w.waitKeyFrame.Store(false)
+	}
+
+	duration := time.Duration(float64(buffer.Duration()) / math.Float64frombits(w.rate.Load()))
+
+	if err := w.track.WriteSample(media.Sample{
+		Data:     data,
+		Duration: duration,
+	}); err != nil {
+		return w.writeFailed(fmt.Errorf("could not write sample to track: %w", err))
+	}
+	w.writeErrors = 0
+	w.frames.Add(1)
+
+	return nil
+}
+
+// writeFailed returns the error of a failed write if the sink should stop. Writes to the binding of a viewer that is
+// leaving fail until the track is removed, and other failures may pass, so only those that persist stop the sink.
+func (w *WebRtcSink) writeFailed(err error) error {
+	if errors.Is(err, io.ErrClosedPipe) {
+		return nil
+	}
+
+	w.writeErrors++
+	if w.writeErrors < sinkMaxWriteErrors {
+		return nil
+	}
+	return err
+}
+
+func (w *WebRtcSink) writeRTP(data []byte) error {
+	// A packet that can't be parsed is dropped like one lost on the network, which viewers recover from
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(data); err != nil {
+		return nil
+	}
+
+	if w.waitKeyFrame.Load() {
+		if !startsVP8KeyFrame(packet.Payload) {
+			return nil
 		}
+		w.waitKeyFrame.Store(false)
+	}
+
+	packet.SequenceNumber = w.sequenceNumber
+	w.sequenceNumber++
+
+	if err := w.rtpTrack.WriteRTP(packet); err != nil {
+		return w.writeFailed(fmt.Errorf("could not write packet to track: %w", err))
+	}
+	w.writeErrors = 0
+	// the last packet of every frame is marked
+	if packet.Marker {
+		w.frames.Add(1)
+	}
+
+	return nil
+}
 
+// startsVP8KeyFrame returns whether an RTP payload is the first packet of a VP8 key frame
+func startsVP8KeyFrame(payload []byte) bool {
+	var vp8Packet codecs.VP8Packet
+	if _, err := vp8Packet.Unmarshal(payload); err != nil {
+		return false
 	}
 
+	// VP8 marks key frames with a cleared lowest bit in the frame tag, found at the start of the first partition
+	return vp8Packet.S == 1 && vp8Packet.PID == 0 && len(vp8Packet.Payload) > 0 && vp8Packet.Payload[0]&1 == 0
 }
@@ -3,16 +3,30 @@ package webrtcstream
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/SmartFactory-Tec/camera_streamer/pkg/gst"
+	"github.com/SmartFactory-Tec/camera_streamer/pkg/onvif"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pion/webrtc/v3"
 	"go.uber.org/zap"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// idleProbeTimeout is how long to wait for data to stop flowing through a pad before relinking it
+const idleProbeTimeout = 2 * time.Second
+
+// rtpMTU is the largest RTP packet payloaders produce, leaving room for the SRTP overhead within pion's MTU
+const rtpMTU = 1200
+
+var (
+	ErrStreamInUse  = errors.New("stream is in use")
+	ErrStreamClosed = errors.New("stream is closed")
+)
+
 type Orientation string
 
 const (
@@ -44,11 +58,29 @@ func (co *Orientation) UnmarshalJSON(data []byte) error {
 	}
 }
 
+// OnvifConfig holds the address and credentials of a camera's ONVIF device service
+type OnvifConfig struct {
+	Endpoint string `toml:"endpoint" json:"endpoint"`
+	Username string `toml:"username" json:"username"`
+	Password string `toml:"password" json:"password"`
+}
+
 type Config struct {
 	Name             string      `toml:"name" json:"name"`
 	Id               int         `toml:"id" json:"id"`
 	ConnectionString string      `toml:"connection_string" json:"connection_string"`
 	Orientation      Orientation `toml:"orientation" json:"orientation"`
+	// Cameras without ONVIF support leave it empty
+	Onvif *OnvifConfig `toml:"onvif" json:"onvif"`
+	// Text burned into the video, none if empty
+	Overlay *OverlayConfig `toml:"overlay" json:"overlay"`
+	// Regions hidden from every output
+	PrivacyMasks []PrivacyMask `toml:"privacy_masks" json:"privacy_masks"`
+	// Motion is only detected if set
+	Motion *MotionConfig `toml:"motion" json:"motion"`
+	// Whether the pipeline payloads the video into RTP packets for viewers, keeping the pipeline's timestamps, instead
+	// of leaving it to WebRTC
+	RTP bool `toml:"rtp" json:"rtp"`
 }
 
 type WebRTCStream struct {
@@ -59,15 +91,35 @@ type WebRTCStream struct {
 	bus      *gst.Bus
 
 	source       *gst.RtspSource
-	sourceLinked bool
+	sourceLinked atomic.Bool
+	// Whether the source is delivering video, since it was linked
+	sourceConnected atomic.Bool
 
 	// Shared elements across all tracks
 	queue      *gst.Queue
 	dec        *gst.DecodeBin3
+	decodedTee *gst.Tee
+	encQueue   *gst.Queue
 	enc        *gst.Vp8Enc
 	sourceTee  *gst.Tee
 	multiqueue *gst.Multiqueue
 
+	// Branch of the decoded video that feeds snapshots
+	jpeg *jpegBranch
+	// Branch of the decoded video that feeds HLS clients
+	hls *hlsBranch
+	// Branch of the encoded video for consumers outside of WebRTC
+	encoded *encodedBranch
+	// Branch of the decoded video that detects motion, nil if the camera has no motion detection
+	motion *motionBranch
+
+	// Client for the camera's ONVIF services, nil if it has none
+	onvif *onvif.Client
+
+	overlayConfig *OverlayConfig
+	// Overlay element of every output showing it
+	overlays []*gst.ClockOverlay
+
 	// Pads between source and multiqueue and multiqueue and sinks
 	sourceTeeSrcPads   map[int]*gst.Pad
 	multiqueueSinkPads map[int]*gst.Pad
@@ -75,9 +127,27 @@ type WebRTCStream struct {
 
 	// Maps for per track elements
 	sinks map[int]*WebRtcSink // Map of track ID to appsink elements
+	// Payloader of every track, only used in RTP mode
+	payloaders map[int]*gst.RtpVp8Pay
+	rtp        bool
 
 	streamMu    sync.Mutex
 	sinkCounter int
+	// Number of tracks and other outputs that currently need the pipeline to be playing
+	consumers int
+	// Set once the stream is closed, after which it can't be used again
+	closed bool
+
+	snapshotMu   sync.Mutex
+	snapshot     []byte
+	snapshotTime time.Time
+
+	eventMu       sync.Mutex
+	eventHandlers []EventHandler
+	// Bytes produced by the encoder since the bitrate was last measured
+	encodedBytes atomic.Int64
+	// Stops the stream's background work once it's closed
+	cancel context.CancelFunc
 }
 
 func orientationToMethod(orientation Orientation) gst.VideoOrientationMethod {
@@ -96,7 +166,9 @@ func orientationToMethod(orientation Orientation) gst.VideoOrientationMethod {
 
 // New constructs a stream with a given name and id that pulls data from a given source gst element.
 // The element is expected to provide the stream with x-raw video, so it must decode any video it sends.
-func New(config Config) (*WebRTCStream, error) {
+func New(config Config, logger *zap.SugaredLogger) (*WebRTCStream, error) {
+	logger = logger.Named("WebRTCStream").With("stream id", config.Id)
+
 	// First create the pipeline
 	pipeline, err := gst.NewGstPipeline(fmt.Sprintf("%d-pipeline", config.Id))
 	if err != nil {
@@ -118,6 +190,10 @@ func New(config Config) (*WebRTCStream, error) {
 	result = multierror.Append(result, err)
 	dec, err := gst.NewDecodeBin3(fmt.Sprintf("%d-dec", config.Id))
 	result = multierror.Append(result, err)
+	decodedTee, err := gst.NewTee(fmt.Sprintf("%d-decoded-tee", config.Id))
+	result = multierror.Append(result, err)
+	encQueue, err := gst.NewQueue(fmt.Sprintf("%d-enc-queue", config.Id))
+	result = multierror.Append(result, err)
 	enc, err := gst.NewVp8Enc(fmt.Sprintf("%d-enc", config.Id))
 	result = multierror.Append(result, err)
 	srcTee, err := gst.NewTee(fmt.Sprintf("%d-source-tee", config.Id))
@@ -147,27 +223,70 @@ func New(config Config) (*WebRTCStream, error) {
 	}
 
 	//configure encoder
+	if err := configureVp8Enc(enc); err != nil {
+		return nil, err
+	}
+
+	// Every output showing the overlay has its own element, created only if the overlay is enabled for it
+	var overlayConfig OverlayConfig
+	if config.Overlay != nil {
+		overlayConfig = *config.Overlay
+	}
+	var overlays []*gst.ClockOverlay
+	makeOverlay := func(output string, enabled bool) (*gst.ClockOverlay, error) {
+		if !enabled {
+			return nil, nil
+		}
+		overlay, err := newOverlay(fmt.Sprintf("%d-%s-overlay", config.Id, output), overlayConfig, config.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s overlay: %w", output, err)
+		}
+		overlays = append(overlays, overlay)
+		return overlay, nil
+	}
+
 	result = nil
-	result = multierror.Append(result, enc.SetProperty("deadline", 30000))
-	result = multierror.Append(result, enc.SetProperty("cpu-used", 4))
-	result = multierror.Append(result, enc.SetProperty("bits-per-pixel", float32(0.02)))
-	result = multierror.Append(result, enc.SetProperty("end-usage", 0))
-	result = multierror.Append(result, enc.SetProperty("error-resilient", 0x1))
+	liveOverlay, err := makeOverlay("live", overlayConfig.Live)
+	result = multierror.Append(result, err)
+	hlsOverlay, err := makeOverlay("hls", overlayConfig.Live)
+	result = multierror.Append(result, err)
+	snapshotOverlay, err := makeOverlay("snapshot", overlayConfig.Snapshot)
+	result = multierror.Append(result, err)
+	// Recordings share the live encoder unless they show the overlay differently
+	reencodeRecording := overlayConfig.Recording != overlayConfig.Live
+	recordingOverlay, err := makeOverlay("recording", reencodeRecording && overlayConfig.Recording)
+	result = multierror.Append(result, err)
 	if result.ErrorOrNil() != nil {
 		return nil, result
 	}
 
-	// configure tee
+	// Masks are painted after the orientation is applied, so they share the coordinates of every other region
+	var maskConvert *gst.VideoConvert
+	var maskFilter *gst.CapsFilter
+	if len(config.PrivacyMasks) > 0 {
+		maskConvert, maskFilter, err = newPrivacyMaskElements(config.Id, config.PrivacyMasks)
+		if err != nil {
+			return nil, fmt.Errorf("error creating privacy masks: %w", err)
+		}
+	}
+
+	// configure tees
 	err = srcTee.SetProperty("allow-not-linked", true)
 	if err != nil {
 		return nil, err
 	}
+	err = decodedTee.SetProperty("allow-not-linked", true)
+	if err != nil {
+		return nil, err
+	}
 
 	// Build pipeline
 	pipeline.AddElement(src)
 	pipeline.AddElement(queue)
 	pipeline.AddElement(videoFlip)
 	pipeline.AddElement(dec)
+	pipeline.AddElement(decodedTee)
+	pipeline.AddElement(encQueue)
 	pipeline.AddElement(enc)
 	pipeline.AddElement(srcTee)
 	pipeline.AddElement(multiqueue)
@@ -175,34 +294,90 @@ func New(config Config) (*WebRTCStream, error) {
 	// Link pipeline together
 	result = nil
 	result = multierror.Append(result, gst.LinkElements(queue, videoFlip))
-	result = multierror.Append(result, gst.LinkElements(videoFlip, enc))
+	if maskFilter != nil {
+		pipeline.AddElement(maskConvert)
+		pipeline.AddElement(maskFilter)
+		result = multierror.Append(result, gst.LinkElements(videoFlip, maskConvert))
+		result = multierror.Append(result, gst.LinkElements(maskConvert, maskFilter))
+		result = multierror.Append(result, gst.LinkElements(maskFilter, decodedTee))
+	} else {
+		result = multierror.Append(result, gst.LinkElements(videoFlip, decodedTee))
+	}
+	if liveOverlay != nil {
+		pipeline.AddElement(liveOverlay)
+		result = multierror.Append(result, gst.LinkElements(decodedTee, liveOverlay))
+		result = multierror.Append(result, gst.LinkElements(liveOverlay, encQueue))
+	} else {
+		result = multierror.Append(result, gst.LinkElements(decodedTee, encQueue))
+	}
+	result = multierror.Append(result, gst.LinkElements(encQueue, enc))
 	result = multierror.Append(result, gst.LinkElements(enc, srcTee))
 	if result.ErrorOrNil() != nil {
-		return nil, err
+		return nil, result
+	}
+
+	jpeg, err := newJpegBranch(config.Id, pipeline, decodedTee, snapshotOverlay)
+	if err != nil {
+		return nil, fmt.Errorf("error creating jpeg branch: %w", err)
+	}
+
+	hls, err := newHlsBranch(config.Id, pipeline, decodedTee, hlsOverlay, logger)
+	if err != nil {
+		return nil, fmt.Errorf("error creating hls branch: %w", err)
+	}
+
+	var encoded *encodedBranch
+	if reencodeRecording {
+		encoded, err = newReencodedBranch(config.Id, pipeline, decodedTee, recordingOverlay)
+	} else {
+		encoded, err = newEncodedBranch(config.Id, pipeline, srcTee)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating encoded branch: %w", err)
+	}
+
+	var motion *motionBranch
+	if config.Motion != nil {
+		if motion, err = newMotionBranch(config.Id, pipeline, decodedTee, *config.Motion); err != nil {
+			return nil, fmt.Errorf("error creating motion branch: %w", err)
+		}
+	}
+
+	var onvifClient *onvif.Client
+	if config.Onvif != nil && config.Onvif.Endpoint != "" {
+		onvifClient = onvif.NewClient(config.Onvif.Endpoint, config.Onvif.Username, config.Onvif.Password)
 	}
 
 	stream := WebRTCStream{
-		config.Id,
-		config.Name,
-		pipeline,
-		bus,
-		src,
-		false,
-		queue,
-		dec,
-		enc,
-		srcTee,
-		multiqueue,
-		make(map[int]*gst.Pad),
-		make(map[int]*gst.Pad),
-		make(map[int]*gst.Pad),
-		make(map[int]*WebRtcSink),
-		sync.Mutex{},
-		0,
+		Id:                 config.Id,
+		Name:               config.Name,
+		pipeline:           pipeline,
+		bus:                bus,
+		source:             src,
+		queue:              queue,
+		dec:                dec,
+		decodedTee:         decodedTee,
+		encQueue:           encQueue,
+		enc:                enc,
+		sourceTee:          srcTee,
+		multiqueue:         multiqueue,
+		jpeg:               jpeg,
+		hls:                hls,
+		encoded:            encoded,
+		motion:             motion,
+		onvif:              onvifClient,
+		overlayConfig:      config.Overlay,
+		overlays:           overlays,
+		sourceTeeSrcPads:   make(map[int]*gst.Pad),
+		multiqueueSinkPads: make(map[int]*gst.Pad),
+		multiqueueSrcPads:  make(map[int]*gst.Pad),
+		sinks:              make(map[int]*WebRtcSink),
+		payloaders:         make(map[int]*gst.RtpVp8Pay),
+		rtp:                config.RTP,
 	}
 
 	src.OnPadAdded(func(pad *gst.Pad) {
-		if stream.sourceLinked {
+		if stream.sourceLinked.Load() {
 			return
 		}
 		sinkPad, ok := dec.GetPad("sink")
@@ -216,7 +391,10 @@ func New(config Config) (*WebRTCStream, error) {
 			panic(err)
 		}
 
-		stream.sourceLinked = true
+		stream.sourceLinked.Store(true)
+		if !stream.sourceConnected.Swap(true) {
+			stream.emit(Event{Type: EventSourceConnected})
+		}
 	})
 
 	dec.OnPadAdded(func(pad *gst.Pad) {
@@ -235,27 +413,156 @@ func New(config Config) (*WebRTCStream, error) {
 		}
 	})
 
+	encSrcPad, ok := enc.GetPad("src")
+	if !ok {
+		return nil, fmt.Errorf("could not get source pad of encoder")
+	}
+	if err := encSrcPad.OnBuffer(stream.countEncodedBytes); err != nil {
+		return nil, fmt.Errorf("could not measure encoder bitrate: %w", err)
+	}
+
 	err = stream.pipeline.SetState(gst.PAUSED)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing the pipeline: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	stream.cancel = cancel
+	go stream.processMsgBus(ctx, logger)
+	go stream.monitorBitrate(ctx)
+
 	return &stream, nil
 }
 
-// removeTrack stops execution of a give webrtc track by removing its sink and stopping the pipeline if it's the last
-// track playing.
-func (s *WebRTCStream) removeTrack(track *webrtc.TrackLocalStaticSample, logger *zap.SugaredLogger) error {
-	logger = logger.Named("removeTrack").With("id", track.StreamID())
+// Close stops the stream's pipeline for good. Streams still in use can't be closed, and closed streams can't get new
+// consumers.
+func (s *WebRTCStream) Close() error {
 	s.streamMu.Lock()
 	defer s.streamMu.Unlock()
 
-	logger.Debugw("removing track")
+	if s.consumers > 0 {
+		return ErrStreamInUse
+	}
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	s.cancel()
+	return s.pipeline.SetState(gst.NULL)
+}
+
+// Onvif returns the client for the camera's ONVIF services, if it has them
+func (s *WebRTCStream) Onvif() (*onvif.Client, bool) {
+	return s.onvif, s.onvif != nil
+}
+
+// CameraName returns the current name of the stream's camera
+func (s *WebRTCStream) CameraName() string {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	return s.Name
+}
+
+// SetName updates the name of the stream's camera, which viewers are notified of and overlays show
+func (s *WebRTCStream) SetName(name string) error {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	s.Name = name
+
+	if s.overlayConfig == nil || !s.overlayConfig.ShowName {
+		return nil
+	}
+
+	var result *multierror.Error
+	for _, overlay := range s.overlays {
+		result = multierror.Append(result, overlay.SetProperty("text", name))
+	}
+	return result.ErrorOrNil()
+}
+
+// newRtpPayloader creates a VP8 payloader whose packets fit in the MTU WebRTC sends packets with
+func newRtpPayloader(name string) (*gst.RtpVp8Pay, error) {
+	payloader, err := gst.NewRtpVp8Pay(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := payloader.SetProperty("mtu", rtpMTU); err != nil {
+		return nil, err
+	}
+
+	return payloader, nil
+}
+
+// configureVp8Enc tunes a VP8 encoder for real time streaming
+func configureVp8Enc(enc *gst.Vp8Enc) error {
+	var result *multierror.Error
+	result = multierror.Append(result, enc.SetProperty("deadline", 30000))
+	result = multierror.Append(result, enc.SetProperty("cpu-used", 4))
+	result = multierror.Append(result, enc.SetProperty("bits-per-pixel", float32(0.02)))
+	result = multierror.Append(result, enc.SetProperty("end-usage", 0))
+	result = multierror.Append(result, enc.SetProperty("error-resilient", 0x1))
+	return result.ErrorOrNil()
+}
+
+// addConsumer registers a new user of the pipeline's output, starting the pipeline if it's the first one. Elements that
+// join an already running pipeline must be set to playing by the caller.
+func (s *WebRTCStream) addConsumer(logger *zap.SugaredLogger) error {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	return s.addConsumerLocked(logger)
+}
 
-	if len(s.sinks) == 0 {
-		return fmt.Errorf("no tracks currently playing")
+func (s *WebRTCStream) addConsumerLocked(logger *zap.SugaredLogger) error {
+	if s.closed {
+		return ErrStreamClosed
 	}
 
+	if s.consumers == 0 {
+		logger.Debugw("starting pipeline")
+		if err := s.pipeline.SetState(gst.PLAYING); err != nil {
+			return err
+		}
+	}
+
+	s.consumers++
+	return nil
+}
+
+// removeConsumer unregisters a user of the pipeline's output, pausing the pipeline if it was the last one
+func (s *WebRTCStream) removeConsumer(logger *zap.SugaredLogger) error {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	return s.removeConsumerLocked(logger)
+}
+
+func (s *WebRTCStream) removeConsumerLocked(logger *zap.SugaredLogger) error {
+	if s.consumers == 0 {
+		return fmt.Errorf("pipeline has no consumers")
+	}
+
+	s.consumers--
+
+	if s.consumers == 0 {
+		logger.Debugw("no consumers left, pausing pipeline")
+		return s.pipeline.SetState(gst.PAUSED)
+	}
+
+	return nil
+}
+
+// removeTrack stops execution of a give webrtc track by removing its sink and stopping the pipeline if it's the last
+// track playing.
+func (s *WebRTCStream) removeTrack(track webrtc.TrackLocal, logger *zap.SugaredLogger) error {
+	logger = logger.Named("removeTrack").With("id", track.StreamID())
+
+	logger.Debugw("removing track")
+
 	parsedInt, err := strconv.ParseInt(track.StreamID(), 10, 0)
 	trackId := int(parsedInt)
 
@@ -263,37 +570,95 @@ func (s *WebRTCStream) removeTrack(track *webrtc.TrackLocalStaticSample, logger
 		return fmt.Errorf("invalid track stream id: %w", err)
 	}
 
-	sink := s.sinks[trackId]
+	// The track is taken out of the stream first, so it's torn down only once, without holding up the stream's other
+	// outputs while waiting for it to be idle
+	s.streamMu.Lock()
+	sink, ok := s.sinks[trackId]
+	if !ok {
+		s.streamMu.Unlock()
+		return fmt.Errorf("track %d is not playing", trackId)
+	}
+	payloader, hasPayloader := s.payloaders[trackId]
 	sourceTeePad := s.sourceTeeSrcPads[trackId]
 	multiqueueSinkPad := s.multiqueueSinkPads[trackId]
 
 	delete(s.sinks, trackId)
+	delete(s.payloaders, trackId)
 	delete(s.sourceTeeSrcPads, trackId)
 	delete(s.multiqueueSrcPads, trackId)
 	delete(s.multiqueueSinkPads, trackId)
+	s.streamMu.Unlock()
 
-	if len(s.sinks) == 0 {
-		logger.Debugw("no tracks left, pausing pipeline")
-		if err := s.pipeline.SetState(gst.PAUSED); err != nil {
-			return err
+	unlink := func() error {
+		if !sourceTeePad.IsLinked() {
+			return nil
 		}
+		return gst.UnlinkPads(sourceTeePad, multiqueueSinkPad)
 	}
 
-	if err := sink.SetState(gst.NULL); err != nil {
-		return err
+	var result *multierror.Error
+
+	// The branch is cut off from the tee between frames, so no other track sees a frame go missing half way. A
+	// branch that never becomes idle is most likely stuck, and is cut off anyway, since releasing the tee's pad
+	// below keeps whatever the branch returns from reaching the other tracks.
+	if err := whenIdle(sourceTeePad, unlink); err != nil {
+		logger.Warnw("track did not become idle, unlinking it while it's in use", "err", err)
+		result = multierror.Append(result, unlink())
 	}
 
-	s.pipeline.RemoveElement(sink)
 	s.sourceTee.ReleaseRequestPad(sourceTeePad)
+
+	result = multierror.Append(result, sink.SetState(gst.NULL))
+	s.pipeline.RemoveElement(sink)
+	if hasPayloader {
+		result = multierror.Append(result, payloader.SetState(gst.NULL))
+		s.pipeline.RemoveElement(payloader)
+	}
 	s.multiqueue.ReleaseRequestPad(multiqueueSinkPad)
 
-	return nil
+	result = multierror.Append(result, s.removeConsumer(logger))
+	return result.ErrorOrNil()
+}
+
+// whenIdle calls f once no data is flowing through pad, so its links can be changed while the pipeline is playing.
+// Pads that don't become idle within idleProbeTimeout are given up on, and f is never called for them.
+func whenIdle(pad *gst.Pad, f func() error) error {
+	const (
+		waiting int32 = iota
+		called
+		canceled
+	)
+	var state atomic.Int32
+
+	done := make(chan error, 1)
+	id, err := pad.AddProbe(gst.PAD_PROBE_TYPE_IDLE, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if state.CompareAndSwap(waiting, called) {
+			done <- f()
+		}
+		return gst.PAD_PROBE_REMOVE
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(idleProbeTimeout):
+	}
+
+	// Removing the probe doesn't stop a call that already started, so the call is either canceled or waited on
+	if !state.CompareAndSwap(waiting, canceled) {
+		return <-done
+	}
+	pad.RemoveProbe(id)
+	return fmt.Errorf("pad %s did not become idle", pad.Name())
 }
 
 // GenerateTrack generates a new track with a given resolution from the stream source
 // It creates new elements as needed, reusing them if they already exist.
 // (Hopefully) Concurrency safe
-func (s *WebRTCStream) createTrack(ctx context.Context, logger *zap.SugaredLogger) (track *webrtc.TrackLocalStaticSample, err error) {
+func (s *WebRTCStream) createTrack(ctx context.Context, cancelTrack context.CancelFunc, logger *zap.SugaredLogger) (track webrtc.TrackLocal, webrtcSink *WebRtcSink, err error) {
 	logger = logger.Named("createTrack")
 	defer func() {
 		if err != nil {
@@ -316,89 +681,136 @@ func (s *WebRTCStream) createTrack(ctx context.Context, logger *zap.SugaredLogge
 	s.streamMu.Lock()
 	defer s.streamMu.Unlock()
 
+	if s.closed {
+		return nil, nil, ErrStreamClosed
+	}
+
 	logger.Debugw("creating track", "id", s.sinkCounter)
 	// first create the webrtc track and the sink
-	track, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
-		MimeType: "video/vp8",
-	}, "video", strconv.Itoa(s.sinkCounter))
-	if err != nil {
-		return nil, err
-	}
+	codec := webrtc.RTPCodecCapability{MimeType: "video/vp8"}
+	sinkName := fmt.Sprintf("%d-sink", s.sinkCounter)
+	var payloader *gst.RtpVp8Pay
+	if s.rtp {
+		rtpTrack, err := webrtc.NewTrackLocalStaticRTP(codec, "video", strconv.Itoa(s.sinkCounter))
+		if err != nil {
+			return nil, nil, err
+		}
+		track = rtpTrack
 
-	logger.Debugw("creating webrtc sink")
-	webrtcSink, err := NewWebRtcSink(fmt.Sprintf("%d-sink", s.sinkCounter), track)
-	if err != nil {
-		return nil, err
+		logger.Debugw("creating webrtc rtp sink")
+		if webrtcSink, err = NewWebRtcRTPSink(sinkName, rtpTrack); err != nil {
+			return nil, nil, err
+		}
+		if payloader, err = newRtpPayloader(fmt.Sprintf("%d-pay", s.sinkCounter)); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		sampleTrack, err := webrtc.NewTrackLocalStaticSample(codec, "video", strconv.Itoa(s.sinkCounter))
+		if err != nil {
+			return nil, nil, err
+		}
+		track = sampleTrack
+
+		logger.Debugw("creating webrtc sink")
+		if webrtcSink, err = NewWebRtcSink(sinkName, sampleTrack); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	sourceTeePad, err := s.sourceTee.RequestPad("src_%u")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	s.sourceTeeSrcPads[s.sinkCounter] = sourceTeePad
 
 	mqSinkPad, err := s.multiqueue.RequestPad(fmt.Sprintf("sink_%d", s.sinkCounter))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	s.multiqueueSinkPads[s.sinkCounter] = mqSinkPad
 
 	mqSourcePad, ok := s.multiqueue.GetPad(fmt.Sprintf("src_%d", s.sinkCounter))
 	if !ok {
-		return nil, fmt.Errorf("could not get multiqueue src pad with id %d", s.sinkCounter)
+		return nil, nil, fmt.Errorf("could not get multiqueue src pad with id %d", s.sinkCounter)
 	}
 	s.multiqueueSrcPads[s.sinkCounter] = mqSourcePad
 
+	// In RTP mode the multiqueue feeds the payloader, which feeds the sink
 	sinkPad, ok := webrtcSink.GetPad("sink")
+	if payloader != nil {
+		sinkPad, ok = payloader.GetPad("sink")
+	}
 	if !ok {
-		return nil, err
+		return nil, nil, fmt.Errorf("could not get sink pad of track %d", s.sinkCounter)
 	}
 
 	s.pipeline.AddElement(webrtcSink)
-
-	err = gst.LinkPads(sourceTeePad, mqSinkPad)
-	if err != nil {
-		return nil, err
+	if payloader != nil {
+		s.pipeline.AddElement(payloader)
+		if err = gst.LinkElements(payloader, webrtcSink); err != nil {
+			return nil, nil, err
+		}
+		s.payloaders[s.sinkCounter] = payloader
 	}
 
 	err = gst.LinkPads(mqSourcePad, sinkPad)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// If this is the only track
-	if len(s.sinks) == 0 {
-		logger.Debugw("starting pipeline")
-		err = s.pipeline.SetState(gst.PLAYING)
-	} else {
+	// If the pipeline is already running the sink must be started by hand, before any frame reaches it
+	if s.consumers != 0 {
 		logger.Debugw("joining already running pipeline")
 		err = webrtcSink.SetState(gst.PLAYING)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		if payloader != nil {
+			if err = payloader.SetState(gst.PLAYING); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// The branch is joined to the tee between frames, so no frame reaches it half way
+	err = whenIdle(sourceTeePad, func() error {
+		return gst.LinkPads(sourceTeePad, mqSinkPad)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.addConsumerLocked(logger)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	s.sinks[s.sinkCounter] = webrtcSink
 
 	s.sinkCounter++
 
-	go webrtcSink.Start(ctx)
+	go func(sinkName string) {
+		if err := webrtcSink.Start(ctx); err != nil {
+			logger.Errorw("track stopped", "sink", sinkName, "err", err)
+			s.emit(Event{Type: EventPipelineError, Element: sinkName, Error: err.Error()})
+			// Ends the viewer's session, which removes the track
+			cancelTrack()
+		}
+	}(webrtcSink.Name())
 
 	return
 }
 
-type TrackRequestHandler func(ctx context.Context, track webrtc.TrackLocal)
+type TrackRequestHandler func(ctx context.Context, track webrtc.TrackLocal, liveTrack *LiveTrack)
 
 func (s *WebRTCStream) HandleTrackRequest(ctx context.Context, logger *zap.SugaredLogger, handler TrackRequestHandler) {
 	logger = logger.Named("HandleTrackRequest").With("stream id", s.Id)
 
 	ctx, cancelTrack := context.WithCancel(ctx)
 
-	go s.processMsgBus(ctx, logger)
-
 	logger.Debugw("creating track from stream")
 
-	track, err := s.createTrack(ctx, logger)
+	track, sink, err := s.createTrack(ctx, cancelTrack, logger)
 	if err != nil {
 		logger.Error(fmt.Errorf("error creating track: %w", err))
 		cancelTrack()
@@ -412,7 +824,7 @@ func (s *WebRTCStream) HandleTrackRequest(ctx context.Context, logger *zap.Sugar
 
 	logger.Debugw("latency", "min", min, "max", max)
 
-	handler(ctx, track)
+	handler(ctx, track, &LiveTrack{stream: s, sink: sink})
 
 	cancelTrack()
 
@@ -423,33 +835,3 @@ func (s *WebRTCStream) HandleTrackRequest(ctx context.Context, logger *zap.Sugar
 		return
 	}
 }
-
-type BusMessageHandlerFunc func(ctx context.Context, message *gst.Message)
-
-func (s *WebRTCStream) processMsgBus(ctx context.Context, logger *zap.SugaredLogger) {
-	logger = logger.Named("MsgBus")
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			msg, err := s.bus.PopMessageWithFilter(gst.LATENCY)
-
-			// If there's an error, there's no message to process
-			if err != nil {
-				time.Sleep(50 * time.Millisecond)
-				continue
-			}
-			switch msg.Type() {
-			case gst.LATENCY:
-				//latency := s.pipeline.Latency()
-				//if latency == -1 {
-				//	s.pipeline.SetProperty("latency", 0)
-				//} else {
-				//	logger.Debugw("track latency", "latency", s.pipeline.Latency().Milliseconds())
-				//}
-			}
-		}
-		time.Sleep(50 * time.Millisecond)
-	}
-}